@@ -17,6 +17,28 @@ type TransactionResponse struct {
 	Hashes           []string
 }
 
+type TransactionProof struct {
+	BlockHash string
+	Height    uint32
+	DataHash  string
+	Index     uint32
+	Leaves    uint32
+	Siblings  []string
+}
+
+type Receipt struct {
+	TransactionHash string
+	Status          string
+	Error           string
+	Fee             uint64
+	GasUsed         uint64
+}
+
+type Nonce struct {
+	Address string
+	Nonce   uint64
+}
+
 type APIError struct {
 	Error string
 }
@@ -24,10 +46,14 @@ type APIError struct {
 type Block struct {
 	Hash          string
 	Version       uint32
+	ChainID       uint64
 	DataHash      string
+	StateRoot     string
 	PrevBlockHash string
 	Height        uint32
 	Timestamp     int64
+	Difficulty    uint64
+	Nonce         uint64
 	Validator     string
 	Signature     string
 
@@ -58,7 +84,10 @@ func (s *Server) Start() error {
 
 	e.GET("/block/:hashorid", s.handleGetBlock)
 	e.GET("/Transaction/:hash", s.handleGetTransaction)
+	e.GET("/Transaction/:hash/proof", s.handleGetTransactionProof)
+	e.GET("/Transaction/:hash/receipt", s.handleGetReceipt)
 	e.POST("/Transaction", s.handlePostTransaction)
+	e.GET("/account/:address/nonce", s.handleGetNonce)
 
 	return e.Start(s.ListenAddr)
 }
@@ -89,6 +118,70 @@ func (s *Server) handleGetTransaction(c echo.Context) error {
 	return c.JSON(http.StatusOK, transaction)
 }
 
+func (s *Server) handleGetTransactionProof(c echo.Context) error {
+	hash := c.Param("hash")
+
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) != types.HASH_LENGHT {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid transaction hash"})
+	}
+
+	block, proof, err := s.bc.GetTransactionProof(types.HashFromBytes(b))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	siblings := make([]string, len(proof.Siblings))
+	for i, sibling := range proof.Siblings {
+		siblings[i] = sibling.String()
+	}
+
+	return c.JSON(http.StatusOK, TransactionProof{
+		BlockHash: block.Hash(core.BlockHasher{}).String(),
+		Height:    block.Height,
+		DataHash:  block.DataHash.String(),
+		Index:     proof.Index,
+		Leaves:    proof.Leaves,
+		Siblings:  siblings,
+	})
+}
+
+func (s *Server) handleGetReceipt(c echo.Context) error {
+	hash := c.Param("hash")
+
+	b, err := hex.DecodeString(hash)
+	if err != nil || len(b) != types.HASH_LENGHT {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid transaction hash"})
+	}
+
+	receipt, err := s.bc.GetReceipt(types.HashFromBytes(b))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, Receipt{
+		TransactionHash: receipt.TransactionHash.String(),
+		Status:          receipt.Status.String(),
+		Error:           receipt.Error,
+		Fee:             receipt.Fee,
+		GasUsed:         receipt.GasUsed,
+	})
+}
+
+func (s *Server) handleGetNonce(c echo.Context) error {
+	b, err := hex.DecodeString(c.Param("address"))
+	if err != nil || len(b) != types.ADDRESS_MAX_LENGHT {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid address"})
+	}
+
+	address := types.AddressFromBytes(b)
+
+	return c.JSON(http.StatusOK, Nonce{
+		Address: address.String(),
+		Nonce:   s.bc.GetNonce(address),
+	})
+}
+
 func (s *Server) handleGetBlock(c echo.Context) error {
 	hashOrID := c.Param("hashorid")
 
@@ -130,10 +223,14 @@ func intoJSONBlock(block *core.Block) Block {
 	return Block{
 		Hash:                block.Hash(core.BlockHasher{}).String(),
 		Version:             block.Header.Version,
+		ChainID:             block.Header.ChainID,
 		Height:              block.Header.Height,
 		DataHash:            block.Header.DataHash.String(),
+		StateRoot:           block.Header.StateRoot.String(),
 		PrevBlockHash:       block.Header.PrevBlockHash.String(),
 		Timestamp:           block.Header.Timestamp,
+		Difficulty:          block.Header.Difficulty,
+		Nonce:               block.Header.Nonce,
 		Validator:           block.Validator.Address().String(),
 		Signature:           block.Signature.String(),
 		TransactionResponse: transactionResponse,
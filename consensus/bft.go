@@ -0,0 +1,56 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+)
+
+var ErrMissingCommit = errors.New("block has no commit certificate")
+
+// BFT only accepts blocks that carry a commit certificate of the validators,
+// committed blocks are final. The blocks are agreed on by the protocol in
+// the network package, the engine only checks the outcome.
+type BFT struct{}
+
+func (BFT) Prepare(ChainReader, *core.Header, *core.Header) error {
+	return nil
+}
+
+// Seal signs the block, it is committed once the validators voted for it.
+func (BFT) Seal(_ ChainReader, b *core.Block, privKey crypto.PrivateKey, _ <-chan struct{}) error {
+	return b.Sign(privKey)
+}
+
+func (BFT) VerifyHeader(ChainReader, *core.Header, *core.Header) error {
+	return nil
+}
+
+// VerifySeal checks the commit certificate of the block, the block has to be
+// built by one of the validators.
+func (BFT) VerifySeal(chain ChainReader, b *core.Block, _ *core.Header) error {
+	if b.Commit == nil {
+		return ErrMissingCommit
+	}
+
+	hash := b.Hash(core.BlockHasher{})
+	if b.Commit.Height != b.Height || b.Commit.BlockHash != hash {
+		return fmt.Errorf("%w: certificate is not for block (%s)", core.ErrInvalidCommit, hash)
+	}
+
+	if !isValidator(chain, b.Validator) {
+		return fmt.Errorf("block (%s) is signed by (%s) which is not a validator", hash, b.Validator.Address())
+	}
+
+	return b.Commit.Verify(chain.ChainID(), chain.Validators())
+}
+
+func (BFT) Finalize(chain ChainReader, state *core.AccountState, b *core.Block, fees uint64) error {
+	return core.RewardValidator(chain, state, b, fees)
+}
+
+func (BFT) ForkChoice() core.ForkChoice {
+	return core.LongestChain{}
+}
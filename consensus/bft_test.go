@@ -0,0 +1,58 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func precommit(t *testing.T, privKey crypto.PrivateKey, height uint32, hash types.Hash) *core.Vote {
+	vote := &core.Vote{
+		Type:      core.VotePrecommit,
+		Height:    height,
+		BlockHash: hash,
+	}
+	assert.Nil(t, vote.Sign(privKey))
+
+	return vote
+}
+
+func TestBFTRequiresCommit(t *testing.T) {
+	privKeys := []crypto.PrivateKey{
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+	}
+	bc := newChain(t, validatorGenesis(privKeys...), BFT{})
+
+	b := proposeBlock(t, bc, privKeys[1], time.Millisecond)
+	assert.ErrorIs(t, bc.AddBlock(b), ErrMissingCommit)
+
+	hash := b.Hash(core.BlockHasher{})
+	b.Commit = &core.CommitCertificate{Height: 1, BlockHash: hash}
+	for _, privKey := range privKeys[:2] {
+		b.Commit.Precommits = append(b.Commit.Precommits, precommit(t, privKey, 1, hash))
+	}
+	assert.ErrorIs(t, bc.AddBlock(b), core.ErrInvalidCommit)
+
+	b.Commit.Precommits = append(b.Commit.Precommits, precommit(t, privKeys[2], 1, hash))
+	assert.Nil(t, bc.AddBlock(b))
+
+	stored, err := bc.GetBlock(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b.Commit, stored.Commit)
+
+	// A committed block has to be built by a validator.
+	outsider := proposeBlock(t, bc, crypto.GeneratePrivateKey(), time.Millisecond)
+	hash = outsider.Hash(core.BlockHasher{})
+	outsider.Commit = &core.CommitCertificate{Height: 2, BlockHash: hash}
+	for _, privKey := range privKeys {
+		outsider.Commit.Precommits = append(outsider.Commit.Precommits, precommit(t, privKey, 2, hash))
+	}
+	assert.NotNil(t, bc.AddBlock(outsider))
+	assert.Equal(t, uint32(1), bc.Height())
+}
@@ -0,0 +1,44 @@
+// Package consensus holds the consensus engines a chain can run with.
+package consensus
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+)
+
+// Engine is implemented by every consensus engine. It is declared in core,
+// so the blockchain can call into it without importing this package.
+type Engine = core.Engine
+
+// ChainReader is the view of the chain an engine works with.
+type ChainReader = core.ChainReader
+
+// NoOp accepts every block that passes the checks all chains share, it is
+// meant for tests.
+type NoOp = core.NopEngine
+
+var (
+	// ErrNotProposer is returned by Seal when the key may not seal the
+	// block right now.
+	ErrNotProposer = errors.New("not the proposer of the block")
+	// ErrSealAborted is returned by Seal when it was stopped before the
+	// block was sealed.
+	ErrSealAborted = errors.New("sealing aborted")
+	// ErrRoundNotStarted is returned by VerifySeal for a block of a round
+	// that has not started yet by the local clock.
+	ErrRoundNotStarted = errors.New("round of the block has not started")
+)
+
+// isValidator reports whether the key is part of the validator set.
+func isValidator(chain ChainReader, key crypto.PublicKey) bool {
+	for _, validator := range chain.Validators() {
+		if bytes.Equal(validator, key) {
+			return true
+		}
+	}
+
+	return false
+}
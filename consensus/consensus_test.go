@@ -0,0 +1,52 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/util"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func newChain(t *testing.T, genesis core.Genesis, engine Engine) *core.Blockchain {
+	block, err := genesis.Block()
+	assert.Nil(t, err)
+
+	bc, err := core.NewBlockchainWithOpts(log.NewNopLogger(), block, core.BlockchainOpts{
+		Genesis: genesis,
+		Engine:  engine,
+	})
+	assert.Nil(t, err)
+
+	return bc
+}
+
+func validatorGenesis(privKeys ...crypto.PrivateKey) core.Genesis {
+	genesis := core.Genesis{Timestamp: time.Now().UnixNano()}
+	for _, privKey := range privKeys {
+		genesis.Validators = append(genesis.Validators, privKey.PublicKey())
+	}
+
+	return genesis
+}
+
+// proposeBlock returns the next block signed by the given key, timestamped
+// the given duration after the current head.
+func proposeBlock(t *testing.T, bc *core.Blockchain, privKey crypto.PrivateKey, after time.Duration) *core.Block {
+	header, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+
+	return util.ProposeBlock(t, bc, privKey, time.Unix(0, header.Timestamp).Add(after))
+}
+
+func TestNoOp(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	bc := newChain(t, validatorGenesis(crypto.GeneratePrivateKey()), NoOp{})
+
+	// Anyone may add a block, even outside of the validator set.
+	assert.Nil(t, bc.AddBlock(proposeBlock(t, bc, privKey, time.Millisecond)))
+	assert.Equal(t, uint32(1), bc.Height())
+}
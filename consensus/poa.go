@@ -0,0 +1,109 @@
+package consensus
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+)
+
+// defaultTimeout is how long the validators wait for the proposer of a block
+// before the next validator takes over.
+const defaultTimeout = 15 * time.Second
+
+// PoA lets the validators of the chain take turns proposing blocks in a
+// round robin by height. A proposer that misses its round is skipped.
+type PoA struct {
+	// Period is the minimum time between two blocks.
+	Period time.Duration
+	// Timeout is how long a proposer has to propose its block before the
+	// next validator takes over. Defaults to 15 seconds.
+	Timeout time.Duration
+}
+
+// Round returns the round a block with the given timestamp is proposed in.
+// Round 0 starts with the previous block, every Timeout that passes without
+// a new block starts the next round.
+func (e PoA) Round(parent *core.Header, timestamp int64) uint64 {
+	elapsed := timestamp - parent.Timestamp
+	if elapsed <= 0 {
+		return 0
+	}
+
+	return uint64(elapsed / int64(e.timeout()))
+}
+
+func (e PoA) timeout() time.Duration {
+	if e.Timeout == 0 {
+		return defaultTimeout
+	}
+
+	return e.Timeout
+}
+
+// Prepare makes sure the block is at least Period after its parent.
+func (e PoA) Prepare(_ ChainReader, header *core.Header, parent *core.Header) error {
+	if earliest := parent.Timestamp + int64(e.Period); header.Timestamp < earliest {
+		header.Timestamp = earliest
+	}
+
+	return nil
+}
+
+// Seal signs the block when the key belongs to the proposer of the round the
+// timestamp of the block falls in, once that time has come.
+func (e PoA) Seal(chain ChainReader, b *core.Block, privKey crypto.PrivateKey, stop <-chan struct{}) error {
+	parent, err := chain.GetBlockByHash(b.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+
+	proposer := chain.Proposer(b.Height, e.Round(parent.Header, b.Timestamp))
+	if proposer != nil && !bytes.Equal(proposer, privKey.PublicKey()) {
+		return ErrNotProposer
+	}
+
+	select {
+	case <-time.After(time.Until(time.Unix(0, b.Timestamp))):
+	case <-stop:
+		return ErrSealAborted
+	}
+
+	return b.Sign(privKey)
+}
+
+func (e PoA) VerifyHeader(ChainReader, *core.Header, *core.Header) error {
+	return nil
+}
+
+// VerifySeal checks that the block is signed by the proposer of the round its
+// timestamp falls in. The proposer picks the timestamp, so a block of a later
+// round is only accepted once that round started by the local clock, or the
+// next validator could take over right away.
+func (e PoA) VerifySeal(chain ChainReader, b *core.Block, parent *core.Header) error {
+	round := e.Round(parent, b.Timestamp)
+	proposer := chain.Proposer(b.Height, round)
+	if proposer == nil {
+		return nil
+	}
+
+	if now := chain.Now().UnixNano(); round > 0 && b.Timestamp > now {
+		return fmt.Errorf("%w: block (%s) of round (%d) has timestamp (%d) local time is (%d)", ErrRoundNotStarted, b.Hash(core.BlockHasher{}), round, b.Timestamp, now)
+	}
+
+	if !bytes.Equal(proposer, b.Validator) {
+		return fmt.Errorf("%w: block (%s) is signed by (%s) expected (%s)", core.ErrWrongProposer, b.Hash(core.BlockHasher{}), b.Validator.Address(), proposer.Address())
+	}
+
+	return nil
+}
+
+func (e PoA) Finalize(chain ChainReader, state *core.AccountState, b *core.Block, fees uint64) error {
+	return core.RewardValidator(chain, state, b, fees)
+}
+
+func (e PoA) ForkChoice() core.ForkChoice {
+	return core.LongestChain{}
+}
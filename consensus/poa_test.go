@@ -0,0 +1,107 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoARoundRobin(t *testing.T) {
+	privKeys := []crypto.PrivateKey{
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+	}
+	bc := newChain(t, validatorGenesis(privKeys...), PoA{Timeout: time.Second})
+
+	for height := uint32(1); height <= 6; height++ {
+		proposer := privKeys[height%3]
+		assert.Equal(t, proposer.PublicKey(), bc.Proposer(height, 0))
+
+		wrong := privKeys[(height+1)%3]
+		assert.ErrorIs(t, bc.AddBlock(proposeBlock(t, bc, wrong, time.Millisecond)), core.ErrWrongProposer)
+
+		assert.Nil(t, bc.AddBlock(proposeBlock(t, bc, proposer, time.Millisecond)))
+		assert.Equal(t, height, bc.Height())
+	}
+}
+
+func TestPoATimeout(t *testing.T) {
+	privKeys := []crypto.PrivateKey{
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+	}
+	poa := PoA{Timeout: time.Second}
+	// The genesis is old enough for the later rounds to have started.
+	genesis := validatorGenesis(privKeys...)
+	genesis.Timestamp = time.Now().Add(-time.Minute).UnixNano()
+	bc := newChain(t, genesis, poa)
+
+	header, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), poa.Round(header, header.Timestamp+int64(999*time.Millisecond)))
+	assert.Equal(t, uint64(2), poa.Round(header, header.Timestamp+int64(2*time.Second)))
+
+	// The proposer of height 1 is offline, once its round timed out the
+	// next validator takes over and the offline one is rejected.
+	offline, next := privKeys[1], privKeys[2]
+	assert.ErrorIs(t, bc.AddBlock(proposeBlock(t, bc, next, time.Millisecond)), core.ErrWrongProposer)
+	assert.ErrorIs(t, bc.AddBlock(proposeBlock(t, bc, offline, 1500*time.Millisecond)), core.ErrWrongProposer)
+	assert.Nil(t, bc.AddBlock(proposeBlock(t, bc, next, 1500*time.Millisecond)))
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestPoAEarlySeal(t *testing.T) {
+	privKeys := []crypto.PrivateKey{
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+	}
+	bc := newChain(t, validatorGenesis(privKeys...), PoA{})
+
+	// The next validator stamps its block with the start of round 1 right
+	// away, which is within the allowed clock drift but not its turn yet.
+	proposer, next := privKeys[1], privKeys[2]
+	assert.ErrorIs(t, bc.AddBlock(proposeBlock(t, bc, next, defaultTimeout)), ErrRoundNotStarted)
+
+	assert.Nil(t, bc.AddBlock(proposeBlock(t, bc, proposer, time.Millisecond)))
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestPoASeal(t *testing.T) {
+	privKeys := []crypto.PrivateKey{
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+	}
+	poa := PoA{Period: time.Millisecond, Timeout: time.Hour}
+	bc := newChain(t, validatorGenesis(privKeys...), poa)
+
+	header, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+	b, err := core.NewBlockFromPrevHeader(header, []*core.Transaction{}, bc.Now())
+	assert.Nil(t, err)
+	b.Timestamp = header.Timestamp
+	assert.Nil(t, poa.Prepare(bc, b.Header, header))
+	assert.Equal(t, header.Timestamp+int64(time.Millisecond), b.Timestamp)
+
+	b.Validator = privKeys[1].PublicKey()
+	assert.Nil(t, bc.SetStateRoot(b))
+	assert.ErrorIs(t, poa.Seal(bc, b, privKeys[0], nil), ErrNotProposer)
+
+	// Sealing a block far in the future waits until it is stopped.
+	future := *b
+	future.Header = &core.Header{}
+	*future.Header = *b.Header
+	future.Timestamp = header.Timestamp + int64(30*time.Minute)
+	stop := make(chan struct{})
+	close(stop)
+	assert.ErrorIs(t, poa.Seal(bc, &future, privKeys[1], stop), ErrSealAborted)
+
+	assert.Nil(t, poa.Seal(bc, b, privKeys[1], nil))
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(1), bc.Height())
+}
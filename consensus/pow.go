@@ -0,0 +1,201 @@
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+)
+
+// defaultRetargetInterval is the number of blocks between two difficulty
+// adjustments when no interval is configured.
+const defaultRetargetInterval = 10
+
+// sealBatch is the number of nonces tried before checking whether sealing
+// was stopped.
+const sealBatch = 1 << 14
+
+var ErrInvalidProofOfWork = errors.New("block hash does not meet the difficulty target")
+
+// maxTarget is the target of difficulty 1, every hash is below it.
+var maxTarget = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// CumulativeWork is the fork choice of proof of work, the branch that took
+// the most work to build wins.
+var CumulativeWork = core.HeaviestChain{WeightFunc: Work}
+
+// PoW lets anyone mine a block by searching for a nonce that puts the hash
+// of the header below the target its difficulty sets.
+type PoW struct {
+	// TargetBlockTime is the time the network should take per block.
+	TargetBlockTime time.Duration
+	// RetargetInterval is the number of blocks between two difficulty
+	// adjustments. Defaults to 10.
+	RetargetInterval uint32
+}
+
+// Work returns the expected number of hashes it takes to find a valid nonce
+// for the header, which equals its difficulty.
+func Work(h *core.Header) *big.Int {
+	if h.Difficulty == 0 {
+		return big.NewInt(1)
+	}
+
+	return new(big.Int).SetUint64(h.Difficulty)
+}
+
+// Target returns the value the hash of the header has to be below.
+func Target(h *core.Header) *big.Int {
+	return new(big.Int).Div(maxTarget, Work(h))
+}
+
+// HasValidWork reports whether the hash of the header is below its target.
+func HasValidWork(h *core.Header) bool {
+	hash := core.BlockHasher{}.Hash(h)
+	return new(big.Int).SetBytes(hash[:]).Cmp(Target(h)) < 0
+}
+
+func (e PoW) retargetInterval() uint32 {
+	if e.RetargetInterval == 0 {
+		return defaultRetargetInterval
+	}
+
+	return e.RetargetInterval
+}
+
+// NextDifficulty returns the difficulty of the block that follows the given
+// header. Every RetargetInterval blocks the difficulty is adjusted by how far
+// the timestamps of the last blocks are off the target block time, by at
+// most a factor of four.
+func (e PoW) NextDifficulty(chain ChainReader, parent *core.Header) (uint64, error) {
+	difficulty := parent.Difficulty
+	if difficulty == 0 {
+		difficulty = 1
+	}
+
+	height := parent.Height + 1
+	interval := e.retargetInterval()
+	if height%interval != 0 {
+		return difficulty, nil
+	}
+
+	var first uint32
+	if parent.Height > interval {
+		first = parent.Height - interval
+	}
+	blocks := int64(parent.Height - first)
+	if blocks == 0 {
+		return difficulty, nil
+	}
+
+	ancestor, err := ancestor(chain, parent, first)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		expected = blocks * int64(e.TargetBlockTime)
+		actual   = parent.Timestamp - ancestor.Timestamp
+	)
+	if actual < expected/4 {
+		actual = expected / 4
+	}
+	if actual > expected*4 {
+		actual = expected * 4
+	}
+	if actual <= 0 {
+		actual = 1
+	}
+
+	next := new(big.Int).SetUint64(difficulty)
+	next.Mul(next, big.NewInt(expected))
+	next.Div(next, big.NewInt(actual))
+
+	if !next.IsUint64() {
+		return ^uint64(0), nil
+	}
+	if next.Sign() == 0 {
+		return 1, nil
+	}
+
+	return next.Uint64(), nil
+}
+
+// ancestor returns the header at the given height on the branch of the given
+// header, which does not have to be the canonical chain.
+func ancestor(chain ChainReader, h *core.Header, height uint32) (*core.Header, error) {
+	for h.Height > height {
+		parent, err := chain.GetBlockByHash(h.PrevBlockHash)
+		if err != nil {
+			return nil, err
+		}
+		h = parent.Header
+	}
+
+	return h, nil
+}
+
+// Prepare sets the difficulty of the header.
+func (e PoW) Prepare(chain ChainReader, header *core.Header, parent *core.Header) error {
+	difficulty, err := e.NextDifficulty(chain, parent)
+	if err != nil {
+		return err
+	}
+	header.Difficulty = difficulty
+
+	return nil
+}
+
+// Seal searches for a nonce that meets the target and signs the block.
+func (e PoW) Seal(_ ChainReader, b *core.Block, privKey crypto.PrivateKey, stop <-chan struct{}) error {
+	for !HasValidWork(b.Header) {
+		b.Nonce++
+		if b.Nonce%sealBatch != 0 {
+			continue
+		}
+
+		select {
+		case <-stop:
+			return ErrSealAborted
+		default:
+		}
+	}
+
+	// The signature covers the nonce, so the block is signed once found.
+	return b.Sign(privKey)
+}
+
+// VerifyHeader checks that the header has the difficulty that follows its
+// parent.
+func (e PoW) VerifyHeader(chain ChainReader, header *core.Header, parent *core.Header) error {
+	difficulty, err := e.NextDifficulty(chain, parent)
+	if err != nil {
+		return err
+	}
+
+	if header.Difficulty != difficulty {
+		return fmt.Errorf("block (%s) has difficulty (%d) expected (%d)", core.BlockHasher{}.Hash(header), header.Difficulty, difficulty)
+	}
+
+	return nil
+}
+
+// VerifySeal checks the proof of work of the block.
+func (e PoW) VerifySeal(_ ChainReader, b *core.Block, _ *core.Header) error {
+	if !HasValidWork(b.Header) {
+		return fmt.Errorf("%w: block (%s)", ErrInvalidProofOfWork, b.Hash(core.BlockHasher{}))
+	}
+
+	return nil
+}
+
+func (e PoW) Finalize(chain ChainReader, state *core.AccountState, b *core.Block, fees uint64) error {
+	return core.RewardValidator(chain, state, b, fees)
+}
+
+func (e PoW) ForkChoice() core.ForkChoice {
+	return CumulativeWork
+}
@@ -0,0 +1,137 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// powGenesis starts the chain an hour ago, so the blocks of the tests can be
+// minutes apart without ending up in the future.
+func powGenesis(difficulty uint64) core.Genesis {
+	return core.Genesis{
+		Timestamp:  time.Now().Add(-time.Hour).UnixNano(),
+		Difficulty: difficulty,
+	}
+}
+
+// mineBlock returns the next block timestamped the given duration after its
+// parent, with a nonce that meets its target.
+func mineBlock(t *testing.T, bc *core.Blockchain, pow PoW, parent *core.Header, after time.Duration) *core.Block {
+	b, err := core.NewBlockFromPrevHeader(parent, []*core.Transaction{}, time.Unix(0, parent.Timestamp).Add(after))
+	assert.Nil(t, err)
+	assert.Nil(t, pow.Prepare(bc, b.Header, parent))
+
+	privKey := crypto.GeneratePrivateKey()
+	b.Validator = privKey.PublicKey()
+	assert.Nil(t, bc.SetStateRoot(b))
+	assert.Nil(t, pow.Seal(bc, b, privKey, nil))
+
+	return b
+}
+
+func TestProofOfWork(t *testing.T) {
+	pow := PoW{TargetBlockTime: time.Second}
+	bc := newChain(t, powGenesis(64), pow)
+	genesis, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+
+	b := mineBlock(t, bc, pow, genesis, time.Second)
+	assert.Equal(t, uint64(64), b.Difficulty)
+	assert.True(t, HasValidWork(b.Header))
+
+	// A nonce that does not meet the target is rejected.
+	invalid := *b.Header
+	for HasValidWork(&invalid) {
+		invalid.Nonce++
+	}
+	block, err := core.NewBlock(&invalid, b.Transactions)
+	assert.Nil(t, err)
+	block.Validator = b.Validator
+	block.Signature = b.Signature
+	assert.NotNil(t, bc.AddBlock(block))
+
+	// So is a block that claims a lower difficulty.
+	easy := mineBlock(t, bc, pow, genesis, time.Second)
+	easy.Difficulty = 1
+	privKey := crypto.GeneratePrivateKey()
+	easy.Validator = privKey.PublicKey()
+	assert.Nil(t, bc.SetStateRoot(easy))
+	assert.Nil(t, easy.Sign(privKey))
+	assert.NotNil(t, bc.AddBlock(easy))
+
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestDifficultyRetarget(t *testing.T) {
+	pow := PoW{
+		TargetBlockTime:  time.Second,
+		RetargetInterval: 4,
+	}
+	bc := newChain(t, powGenesis(16), pow)
+
+	// Blocks come twice as fast as they should, so the difficulty doubles.
+	for i := 0; i < 4; i++ {
+		header, err := bc.GetHeader(bc.Height())
+		assert.Nil(t, err)
+		assert.Nil(t, bc.AddBlock(mineBlock(t, bc, pow, header, 500*time.Millisecond)))
+	}
+
+	header, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(32), header.Difficulty)
+
+	// The adjustment is at most a factor of four.
+	for i := 0; i < 4; i++ {
+		header, err := bc.GetHeader(bc.Height())
+		assert.Nil(t, err)
+		assert.Nil(t, bc.AddBlock(mineBlock(t, bc, pow, header, time.Minute)))
+	}
+
+	header, err = bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(8), header.Difficulty)
+}
+
+func TestCumulativeWorkForkChoice(t *testing.T) {
+	var (
+		genesis = powGenesis(4)
+		pow     = PoW{TargetBlockTime: time.Second, RetargetInterval: 2}
+		bc      = newChain(t, genesis, pow)
+		fork    = newChain(t, genesis, pow)
+	)
+
+	// The slow branch has more blocks but its difficulty dropped.
+	for i := 0; i < 3; i++ {
+		header, err := bc.GetHeader(bc.Height())
+		assert.Nil(t, err)
+		assert.Nil(t, bc.AddBlock(mineBlock(t, bc, pow, header, time.Minute)))
+	}
+	header, err := bc.GetHeader(3)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), header.Difficulty)
+
+	// The fast branch is shorter but took more work.
+	blocks := []*core.Block{}
+	for i := 0; i < 2; i++ {
+		header, err := fork.GetHeader(fork.Height())
+		assert.Nil(t, err)
+		b := mineBlock(t, fork, pow, header, 100*time.Millisecond)
+		assert.Nil(t, fork.AddBlock(b))
+		blocks = append(blocks, b)
+	}
+	assert.Equal(t, uint64(16), blocks[1].Difficulty)
+
+	for _, b := range blocks {
+		assert.Nil(t, bc.AddBlock(b))
+	}
+	assert.Equal(t, uint32(2), bc.Height())
+
+	head, err := bc.GetBlock(2)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[1].Hash(core.BlockHasher{}), head.Hash(core.BlockHasher{}))
+}
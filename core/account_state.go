@@ -3,6 +3,8 @@ package core
 import (
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"sync"
 
 	"github.com/gabrielluizsf/go-web3/types"
@@ -11,11 +13,15 @@ import (
 var (
 	ErrAccountNotFound     = errors.New("account not found")
 	ErrInsufficientBalance = errors.New("insufficient account balance")
+	ErrBalanceOverflow     = errors.New("account balance overflows")
 )
 
 type Account struct {
 	Address types.Address
 	Balance uint64
+	// Nonce is the number of transactions sent from the account, it is
+	// the nonce the next transaction of the account has to use.
+	Nonce uint64
 }
 
 func (a *Account) String() string {
@@ -25,6 +31,7 @@ func (a *Account) String() string {
 type AccountState struct {
 	mu       sync.RWMutex
 	accounts map[types.Address]*Account
+	journal  *journal
 }
 
 func NewAccountState() *AccountState {
@@ -37,6 +44,8 @@ func (s *AccountState) CreateAccount(address types.Address) *Account {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.recordWithoutLock(address)
+
 	acc := &Account{Address: address}
 	s.accounts[address] = acc
 	return acc
@@ -70,6 +79,87 @@ func (s *AccountState) GetBalance(address types.Address) (uint64, error) {
 	return account.Balance, nil
 }
 
+// GetNonce returns the nonce the next transaction sent from the given
+// address has to use. Unknown accounts start at zero.
+func (s *AccountState) GetNonce(address types.Address) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, ok := s.accounts[address]
+	if !ok {
+		return 0
+	}
+
+	return account.Nonce
+}
+
+// IncrementNonce marks the current nonce of the account as used, creating
+// the account when it does not exist yet.
+func (s *AccountState) IncrementNonce(address types.Address) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recordWithoutLock(address)
+
+	account, ok := s.accounts[address]
+	if !ok {
+		account = &Account{Address: address}
+		s.accounts[address] = account
+	}
+
+	account.Nonce++
+}
+
+// Debit takes the amount from the balance of the account.
+func (s *AccountState) Debit(address types.Address, amount uint64) error {
+	if amount == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.getAccountWithoutLock(address)
+	if err != nil {
+		return err
+	}
+
+	if account.Balance < amount {
+		return ErrInsufficientBalance
+	}
+
+	s.recordWithoutLock(address)
+	account.Balance -= amount
+
+	return nil
+}
+
+// Credit adds the amount to the balance of the account, creating the account
+// when it does not exist yet.
+func (s *AccountState) Credit(address types.Address, amount uint64) error {
+	if amount == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[address]
+	if ok && account.Balance > math.MaxUint64-amount {
+		return ErrBalanceOverflow
+	}
+
+	s.recordWithoutLock(address)
+
+	if !ok {
+		account = &Account{Address: address}
+		s.accounts[address] = account
+	}
+	account.Balance += amount
+
+	return nil
+}
+
 func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -79,23 +169,58 @@ func (s *AccountState) Transfer(from, to types.Address, amount uint64) error {
 		return err
 	}
 
-	if fromAccount.Address.String() != "996fb92427ae41e4649b934ca495991b7852b855" {
-		if fromAccount.Balance < amount {
-			return ErrInsufficientBalance
-		}
+	if fromAccount.Balance < amount {
+		return ErrInsufficientBalance
 	}
 
-	if fromAccount.Balance != 0 {
-		fromAccount.Balance -= amount
+	if from == to {
+		return nil
 	}
 
-	if s.accounts[to] == nil {
-		s.accounts[to] = &Account{
-			Address: to,
-		}
+	toAccount, ok := s.accounts[to]
+	if ok && toAccount.Balance > math.MaxUint64-amount {
+		return ErrBalanceOverflow
 	}
 
-	s.accounts[to].Balance += amount
+	s.recordWithoutLock(from)
+	s.recordWithoutLock(to)
+
+	if !ok {
+		toAccount = &Account{Address: to}
+		s.accounts[to] = toAccount
+	}
+
+	fromAccount.Balance -= amount
+	toAccount.Balance += amount
 
 	return nil
 }
+
+// TotalSupply returns the sum of the balances of all accounts.
+func (s *AccountState) TotalSupply() *big.Int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	supply := new(big.Int)
+	for _, account := range s.accounts {
+		supply.Add(supply, new(big.Int).SetUint64(account.Balance))
+	}
+
+	return supply
+}
+
+// recordWithoutLock adds the current version of the account to the journal
+// before it gets changed.
+func (s *AccountState) recordWithoutLock(address types.Address) {
+	if s.journal == nil {
+		return
+	}
+
+	change := accountChange{state: s, address: address}
+	if account, ok := s.accounts[address]; ok {
+		prev := *account
+		change.prev = &prev
+	}
+
+	s.journal.append(change)
+}
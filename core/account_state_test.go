@@ -1,6 +1,7 @@
 package core
 
 import (
+	"math"
 	"testing"
 
 	"github.com/gabrielluizsf/go-web3/crypto"
@@ -52,3 +53,32 @@ func TestTransferSuccessEmpyToAccount(t *testing.T) {
 	assert.Nil(t, state.Transfer(addressBob, addressAlice, amount))
 	assert.Equal(t, accountAlice.Balance, amount)
 }
+
+func TestTransferFailZeroBalance(t *testing.T) {
+	state := NewAccountState()
+
+	addressBob := crypto.GeneratePrivateKey().PublicKey().Address()
+	addressAlice := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	state.CreateAccount(addressBob)
+
+	assert.Equal(t, ErrInsufficientBalance, state.Transfer(addressBob, addressAlice, 100))
+	_, err := state.GetAccount(addressAlice)
+	assert.Equal(t, ErrAccountNotFound, err)
+}
+
+func TestTransferFailOverflow(t *testing.T) {
+	state := NewAccountState()
+
+	addressBob := crypto.GeneratePrivateKey().PublicKey().Address()
+	addressAlice := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	state.CreateAccount(addressBob).Balance = 10
+	state.CreateAccount(addressAlice).Balance = math.MaxUint64
+
+	assert.Equal(t, ErrBalanceOverflow, state.Transfer(addressBob, addressAlice, 1))
+
+	balance, err := state.GetBalance(addressBob)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), balance)
+}
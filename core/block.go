@@ -2,8 +2,7 @@ package core
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/gob"
+	"encoding/binary"
 	"fmt"
 	"time"
 
@@ -12,27 +11,52 @@ import (
 )
 
 type Header struct {
-	Version       uint32
-	DataHash      types.Hash
+	Version uint32
+	// ChainID identifies the network the block belongs to.
+	ChainID  uint64
+	DataHash types.Hash
+	// StateRoot is the root of the state trie after executing the block.
+	StateRoot     types.Hash
 	PrevBlockHash types.Hash
 	Height        uint32
 	Timestamp     int64
+	// Difficulty and Nonce are only used by proof of work, the hash of the
+	// header has to be below the target the difficulty sets.
+	Difficulty uint64
+	Nonce      uint64
 }
 
+// Bytes encodes the header field by field in a fixed layout, so the same
+// header always results in the same bytes on every node.
 func (h *Header) Bytes() []byte {
 	buf := &bytes.Buffer{}
-	enc := gob.NewEncoder(buf)
-	enc.Encode(h)
+	binary.Write(buf, binary.LittleEndian, h.Version)
+	binary.Write(buf, binary.LittleEndian, h.ChainID)
+	buf.Write(h.DataHash[:])
+	buf.Write(h.StateRoot[:])
+	buf.Write(h.PrevBlockHash[:])
+	binary.Write(buf, binary.LittleEndian, h.Height)
+	binary.Write(buf, binary.LittleEndian, h.Timestamp)
+	binary.Write(buf, binary.LittleEndian, h.Difficulty)
+	binary.Write(buf, binary.LittleEndian, h.Nonce)
 
 	return buf.Bytes()
 }
 
+// SigningHash returns the digest the validator signs.
+func (h *Header) SigningHash() types.Hash {
+	return signingHash(blockSigningDomain, h.ChainID, h.Bytes())
+}
+
 type Block struct {
 	*Header
 
 	Transactions []*Transaction
 	Validator    crypto.PublicKey
 	Signature    *crypto.Signature
+	// Commit proves that the validators agreed on the block, it is only
+	// set on chains with instant finality.
+	Commit *CommitCertificate
 
 	// Cached version of the header hash
 	hash types.Hash
@@ -45,7 +69,9 @@ func NewBlock(h *Header, transactions []*Transaction) (*Block, error) {
 	}, nil
 }
 
-func NewBlockFromPrevHeader(prevHeader *Header, transactions []*Transaction) (*Block, error) {
+// NewBlockFromPrevHeader returns a block on top of the given header, stamped
+// with the given time. Callers pass the time of the clock of their chain.
+func NewBlockFromPrevHeader(prevHeader *Header, transactions []*Transaction, timestamp time.Time) (*Block, error) {
 	dataHash, err := CalculateDataHash(transactions)
 	if err != nil {
 		return nil, err
@@ -53,10 +79,16 @@ func NewBlockFromPrevHeader(prevHeader *Header, transactions []*Transaction) (*B
 
 	header := &Header{
 		Version:       1,
+		ChainID:       prevHeader.ChainID,
 		Height:        prevHeader.Height + 1,
 		DataHash:      dataHash,
 		PrevBlockHash: BlockHasher{}.Hash(prevHeader),
-		Timestamp:     time.Now().UnixNano(),
+		Timestamp:     timestamp.UnixNano(),
+	}
+	// The block has to be later than its parent, even when the parent was
+	// made by a node whose clock is ahead.
+	if header.Timestamp <= prevHeader.Timestamp {
+		header.Timestamp = prevHeader.Timestamp + 1
 	}
 
 	return NewBlock(header, transactions)
@@ -69,7 +101,8 @@ func (b *Block) AddTransaction(transaction *Transaction) {
 }
 
 func (b *Block) Sign(privKey crypto.PrivateKey) error {
-	sig, err := privKey.Sign(b.Header.Bytes())
+	hash := b.Header.SigningHash()
+	sig, err := privKey.Sign(hash.ToSlice())
 	if err != nil {
 		return err
 	}
@@ -85,11 +118,15 @@ func (b *Block) Verify() error {
 		return fmt.Errorf("block has no signature")
 	}
 
-	if !b.Signature.Verify(b.Validator, b.Header.Bytes()) {
+	hash := b.Header.SigningHash()
+	if !b.Signature.Verify(b.Validator, hash.ToSlice()) {
 		return fmt.Errorf("block has invalid signature")
 	}
 
 	for _, transaction := range b.Transactions {
+		if transaction.ChainID != b.ChainID {
+			return fmt.Errorf("transaction (%s) has chain id (%d) but the block has (%d)", transaction.Hash(TransactionHasher{}), transaction.ChainID, b.ChainID)
+		}
 		if err := transaction.Verify(); err != nil {
 			return err
 		}
@@ -123,16 +160,40 @@ func (b *Block) Hash(hasher Hasher[*Header]) types.Hash {
 	return b.hash
 }
 
-func CalculateDataHash(transactions []*Transaction) (hash types.Hash, err error) {
-	buf := &bytes.Buffer{}
-
-	for _, transaction := range transactions {
-		if err = transaction.Encode(NewGobTransactionEncoder(buf)); err != nil {
-			return
+// TransactionProof returns a proof that the transaction with the given hash
+// is part of the block, it can be checked with VerifyTransactionProof.
+func (b *Block) TransactionProof(hash types.Hash) (*MerkleProof, error) {
+	hashes := transactionHashes(b.Transactions)
+	for i, transactionHash := range hashes {
+		if transactionHash == hash {
+			return NewMerkleProof(hashes, i)
 		}
 	}
 
-	hash = sha256.Sum256(buf.Bytes())
+	return nil, fmt.Errorf("transaction (%s) is not part of block (%s)", hash, b.Hash(BlockHasher{}))
+}
+
+// VerifyTransactionProof reports whether the proof shows that the transaction
+// with the given hash is committed to by the data hash of the header.
+func VerifyTransactionProof(h *Header, transactionHash types.Hash, proof *MerkleProof) bool {
+	if proof == nil {
+		return false
+	}
+
+	return proof.Verify(h.DataHash, transactionHash)
+}
+
+// CalculateDataHash returns the Merkle root over the hashes of the given
+// transactions.
+func CalculateDataHash(transactions []*Transaction) (hash types.Hash, err error) {
+	return MerkleRoot(transactionHashes(transactions)), nil
+}
+
+func transactionHashes(transactions []*Transaction) []types.Hash {
+	hashes := make([]types.Hash, len(transactions))
+	for i, transaction := range transactions {
+		hashes[i] = transaction.Hash(TransactionHasher{})
+	}
 
-	return
+	return hashes
 }
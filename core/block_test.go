@@ -33,6 +33,42 @@ func TestVerifyBlock(t *testing.T) {
 	assert.NotNil(t, b.Verify())
 }
 
+func TestVerifyBlockChainID(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	b := randomBlock(t, 0, types.Hash{})
+	assert.Nil(t, b.Verify())
+
+	// A signature for one chain can not be replayed on another.
+	b.ChainID = 1
+	assert.NotNil(t, b.Verify())
+
+	// The transactions have to be for the same chain as the block.
+	assert.Nil(t, b.Sign(privKey))
+	assert.NotNil(t, b.Verify())
+
+	transaction := &Transaction{
+		Data:    []byte("foo"),
+		ChainID: 1,
+	}
+	assert.Nil(t, transaction.Sign(crypto.GeneratePrivateKey()))
+	b.Transactions = nil
+	b.AddTransaction(transaction)
+	assert.Nil(t, b.Sign(privKey))
+	assert.Nil(t, b.Verify())
+}
+
+func TestSigningDomains(t *testing.T) {
+	payload := []byte("foo")
+	assert.NotEqual(t,
+		signingHash(blockSigningDomain, 1, payload),
+		signingHash(transactionSigningDomain, 1, payload),
+	)
+	assert.NotEqual(t,
+		signingHash(blockSigningDomain, 1, payload),
+		signingHash(blockSigningDomain, 2, payload),
+	)
+}
+
 func TestDecodeEncodeBlock(t *testing.T) {
 	b := randomBlock(t, 1, types.Hash{})
 	buf := &bytes.Buffer{}
@@ -52,6 +88,28 @@ func TestDecodeEncodeBlock(t *testing.T) {
 	assert.Equal(t, b.Signature, bDecode.Signature)
 }
 
+func TestBlockTransactionProof(t *testing.T) {
+	b := randomBlock(t, 0, types.Hash{})
+	for i := 0; i < 4; i++ {
+		b.AddTransaction(randomTransactionWithSignature(t))
+	}
+
+	for _, transaction := range b.Transactions {
+		hash := transaction.Hash(TransactionHasher{})
+		proof, err := b.TransactionProof(hash)
+		assert.Nil(t, err)
+		assert.True(t, VerifyTransactionProof(b.Header, hash, proof))
+	}
+
+	_, err := b.TransactionProof(types.Hash{})
+	assert.NotNil(t, err)
+
+	proof, err := b.TransactionProof(b.Transactions[0].Hash(TransactionHasher{}))
+	assert.Nil(t, err)
+	assert.False(t, VerifyTransactionProof(b.Header, b.Transactions[1].Hash(TransactionHasher{}), proof))
+	assert.False(t, VerifyTransactionProof(b.Header, b.Transactions[0].Hash(TransactionHasher{}), nil))
+}
+
 func randomBlock(t *testing.T, height uint32, prevBlockHash types.Hash) *Block {
 	privKey := crypto.GeneratePrivateKey()
 	transaction := randomTransactionWithSignature(t)
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+// defaultMaxReorgDepth is the number of blocks below the head that can be
+// unwound during a reorganization when no depth is configured.
+const defaultMaxReorgDepth = 128
+
+// blockNode is a block in the tree of all known blocks. Every branch of the
+// tree leads back to the genesis block.
+type blockNode struct {
+	block  *Block
+	hash   types.Hash
+	parent *blockNode
+	// weight is the total weight of the branch up to and including this
+	// block as decided by the fork choice.
+	weight *big.Int
+	// undo holds the changes made by executing the block. It is only set
+	// for recent blocks of the canonical chain, those are the blocks that
+	// can be unwound during a reorganization.
+	undo []journalEntry
+	// invalid is set when the block failed execution, no branch that
+	// includes it can become canonical.
+	invalid bool
+	// validators is the validator set after the block was executed, the
+	// set its children are sealed under. It is nil when the block was
+	// never executed.
+	validators []crypto.PublicKey
+}
+
+// recordValidators keeps a copy of the current validator set on the node
+// that was just executed. The caller must hold the stateLock.
+func (bc *Blockchain) recordValidators(node *blockNode) {
+	node.validators = append([]crypto.PublicKey{}, bc.validators...)
+}
+
+// branchReader returns the view of the chain for a block on top of the
+// block with the given hash. Reports false when that block was never
+// executed, its validator set is not known then. The caller must hold the
+// insertLock.
+func (bc *Blockchain) branchReader(hash types.Hash) (ChainReader, bool) {
+	bc.lock.RLock()
+	node, ok := bc.tree[hash]
+	bc.lock.RUnlock()
+
+	if !ok || node.validators == nil {
+		return nil, false
+	}
+
+	return branchReader{Blockchain: bc, validators: node.validators}, true
+}
+
+// newBlockNode adds the block to the tree. The parent of the block must
+// already be in the tree, except for the genesis block. The caller must
+// hold the lock.
+func (bc *Blockchain) newBlockNode(b *Block) (*blockNode, error) {
+	node := &blockNode{
+		block: b,
+		hash:  b.Hash(BlockHasher{}),
+	}
+
+	weight := bc.forkChoice.Weight(b.Header)
+
+	if len(bc.tree) == 0 {
+		if b.Height != 0 {
+			return nil, fmt.Errorf("first block (%s) has height (%d) expected a genesis block", node.hash, b.Height)
+		}
+		node.weight = weight
+		bc.tree[node.hash] = node
+
+		return node, nil
+	}
+
+	parent, ok := bc.tree[b.PrevBlockHash]
+	if !ok {
+		return nil, fmt.Errorf("the previous block (%s) of block (%s) is unknown", b.PrevBlockHash, node.hash)
+	}
+	if b.Height != parent.block.Height+1 {
+		return nil, fmt.Errorf("block (%s) has height (%d) but its parent has height (%d)", node.hash, b.Height, parent.block.Height)
+	}
+
+	node.parent = parent
+	node.weight = new(big.Int).Add(parent.weight, weight)
+	node.invalid = parent.invalid
+	bc.tree[node.hash] = node
+
+	return node, nil
+}
+
+// commonAncestor returns the newest block that is part of both branches.
+func commonAncestor(a, b *blockNode) *blockNode {
+	for a.block.Height > b.block.Height {
+		a = a.parent
+	}
+	for b.block.Height > a.block.Height {
+		b = b.parent
+	}
+	for a != b {
+		a = a.parent
+		b = b.parent
+	}
+
+	return a
+}
+
+// branch returns the blocks after the given ancestor up to and including
+// the given tip, oldest first.
+func branch(ancestor, tip *blockNode) []*blockNode {
+	nodes := []*blockNode{}
+	for node := tip; node != ancestor; node = node.parent {
+		nodes = append(nodes, node)
+	}
+
+	for i, j := 0, len(nodes)-1; i < j; i, j = i+1, j-1 {
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+
+	return nodes
+}
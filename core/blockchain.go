@@ -2,7 +2,9 @@ package core
 
 import (
 	"fmt"
+	"math"
 	"sync"
+	"time"
 
 	"github.com/gabrielluizsf/go-web3/crypto"
 	"github.com/gabrielluizsf/go-web3/types"
@@ -12,59 +14,229 @@ import (
 type Blockchain struct {
 	logger log.Logger
 	store  Storage
+	// chainID is taken from the genesis block, every block and transaction
+	// has to carry the same.
+	chainID uint64
 	// TODO: double check this!
-	lock             sync.RWMutex
+	lock sync.RWMutex
+	// insertLock makes sure blocks are added one at a time.
+	insertLock sync.Mutex
+	// headers and blocks hold the canonical chain.
 	headers          []*Header
 	blocks           []*Block
 	TransactionStore map[types.Hash]*Transaction
-	blockStore       map[types.Hash]*Block
+	// tree holds every known block, including the ones on side branches.
+	tree             map[types.Hash]*blockNode
+	head             *blockNode
+	forkChoice       ForkChoice
+	maxReorgDepth    uint32
+	reorgSubscribers []func(*ReorgEvent)
+	// transactionBlock maps the hash of a transaction to the block that
+	// includes it.
+	transactionBlock map[types.Hash]*Block
+	receipts         map[types.Hash]*Receipt
 
 	accountState *AccountState
 
 	stateLock       sync.RWMutex
 	collectionState map[types.Hash]*CollectionTransaction
 	mintState       map[types.Hash]*MintTransaction
+	stakingState    *StakingState
 	validator       Validator
 	// TODO: make this an interface.
 	contractState *State
+	validators    []crypto.PublicKey
+	// trie authenticates all of the state above, its root is committed
+	// in the header of every block.
+	trie *SparseMerkleTree
+	// trieSynced is false while the trie has to be rebuilt from all of the
+	// state, because the state was replaced without the journal seeing it.
+	trieSynced bool
+	// journal records the changes of the block that is being executed.
+	journal *journal
+
+	snapshots        *SnapshotStore
+	snapshotInterval uint32
+	blockReward      uint64
+	engine           Engine
+	genesis          Genesis
+	clock            Clock
+	maxClockDrift    time.Duration
+	medianTimeBlocks int
+}
+
+type BlockchainOpts struct {
+	// Store is where blocks are persisted. When the store already holds
+	// blocks the chain is rebuilt from it instead of starting from the
+	// given genesis block. Defaults to a MemoryStore.
+	Store Storage
+	// Snapshots is where state snapshots are written to and restored from.
+	// Snapshots are disabled when nil.
+	Snapshots *SnapshotStore
+	// SnapshotInterval is the number of blocks between two snapshots.
+	SnapshotInterval uint32
+	// ForkChoice decides which branch is the canonical chain. Defaults to
+	// LongestChain.
+	ForkChoice ForkChoice
+	// MaxReorgDepth is the number of blocks below the head that can be
+	// replaced by another branch. Defaults to 128.
+	MaxReorgDepth uint32
+	// BlockReward is minted to the validator of every block after the
+	// genesis block, on top of the fees of the block.
+	BlockReward uint64
+	// Engine is the consensus engine that verifies and rewards blocks.
+	// Defaults to the NopEngine.
+	Engine Engine
+	// Genesis is the specification the genesis block was derived from, it
+	// is applied once right before the genesis block is executed.
+	Genesis Genesis
+	// Clock is the local time blocks are checked against. Defaults to the
+	// SystemClock.
+	Clock Clock
+	// MaxClockDrift is how far the timestamp of a block may be ahead of the
+	// Clock. Defaults to 15 seconds.
+	MaxClockDrift time.Duration
+	// MedianTimeBlocks is the number of blocks the timestamp of a new
+	// block has to be later than the median of. Defaults to 11.
+	MedianTimeBlocks int
 }
 
 func NewBlockchain(l log.Logger, genesis *Block) (*Blockchain, error) {
-	// We should create all states inside the scope of the newblockchain.
+	return NewBlockchainWithOpts(l, genesis, BlockchainOpts{})
+}
 
-	// TODO: read this from disk later on
-	accountState := NewAccountState()
+func NewBlockchainWithOpts(l log.Logger, genesis *Block, opts BlockchainOpts) (*Blockchain, error) {
+	bc := newBlockchain(l, genesis, opts)
 
-	coinbase := crypto.PublicKey{}
-	accountState.CreateAccount(coinbase.Address())
+	loaded, err := bc.loadFromStore(genesis.Hash(BlockHasher{}))
+	if err != nil {
+		return nil, err
+	}
+	if loaded {
+		return bc, nil
+	}
+
+	err = bc.addBlockWithoutValidation(genesis)
+
+	return bc, err
+}
+
+// newBlockchain returns a chain without any blocks that uses the opts with
+// their defaults applied.
+func newBlockchain(l log.Logger, genesis *Block, opts BlockchainOpts) *Blockchain {
+	if opts.Store == nil {
+		opts.Store = NewMemoryStore()
+	}
+	if opts.Engine == nil {
+		opts.Engine = NopEngine{}
+	}
+	if opts.ForkChoice == nil {
+		opts.ForkChoice = opts.Engine.ForkChoice()
+	}
+	if opts.MaxReorgDepth == 0 {
+		opts.MaxReorgDepth = defaultMaxReorgDepth
+	}
+	if opts.Clock == nil {
+		opts.Clock = SystemClock{}
+	}
+	if opts.MaxClockDrift == 0 {
+		opts.MaxClockDrift = defaultMaxClockDrift
+	}
+	if opts.MedianTimeBlocks <= 0 {
+		opts.MedianTimeBlocks = defaultMedianTimeBlocks
+	}
+	opts.Genesis.Consensus = opts.Genesis.Consensus.WithDefaults()
+
+	// We should create all states inside the scope of the newblockchain.
+	// When the store already holds blocks they are rebuilt by replaying.
+	accountState := NewAccountState()
 
 	bc := &Blockchain{
 		contractState:    NewState(),
+		trie:             NewSparseMerkleTree(),
 		headers:          []*Header{},
-		store:            NewMemoryStore(),
+		store:            opts.Store,
+		chainID:          genesis.ChainID,
 		logger:           l,
 		accountState:     accountState,
+		stakingState:     NewStakingState(),
 		collectionState:  make(map[types.Hash]*CollectionTransaction),
 		mintState:        make(map[types.Hash]*MintTransaction),
-		blockStore:       make(map[types.Hash]*Block),
 		TransactionStore: make(map[types.Hash]*Transaction),
+		tree:             make(map[types.Hash]*blockNode),
+		forkChoice:       opts.ForkChoice,
+		maxReorgDepth:    opts.MaxReorgDepth,
+		blockReward:      opts.BlockReward,
+		engine:           opts.Engine,
+		genesis:          opts.Genesis,
+		clock:            opts.Clock,
+		maxClockDrift:    opts.MaxClockDrift,
+		medianTimeBlocks: opts.MedianTimeBlocks,
+		transactionBlock: make(map[types.Hash]*Block),
+		receipts:         make(map[types.Hash]*Receipt),
+		journal:          newJournal(),
+		snapshots:        opts.Snapshots,
+		snapshotInterval: opts.SnapshotInterval,
 	}
 	bc.validator = NewBlockValidator(bc)
-	err := bc.addBlockWithoutValidation(genesis)
+	bc.accountState.journal = bc.journal
+	bc.stakingState.journal = bc.journal
+	bc.contractState.journal = bc.journal
 
-	return bc, err
+	return bc
+}
+
+// Close closes the store of the chain once the block that is being added,
+// if any, is stored.
+func (bc *Blockchain) Close() error {
+	bc.insertLock.Lock()
+	defer bc.insertLock.Unlock()
+
+	return bc.store.Close()
 }
 
 func (bc *Blockchain) SetValidator(v Validator) {
 	bc.validator = v
 }
 
+// AddBlock adds the block to the block tree. The block becomes the new head
+// when it extends the canonical chain or when the fork choice prefers its
+// branch, in which case the chain is reorganized and the subscribers are
+// notified.
 func (bc *Blockchain) AddBlock(b *Block) error {
+	event, err := bc.addBlock(b)
+	if event != nil {
+		bc.notifyReorg(event)
+	}
+
+	return err
+}
+
+func (bc *Blockchain) addBlock(b *Block) (*ReorgEvent, error) {
+	bc.insertLock.Lock()
+	defer bc.insertLock.Unlock()
+
 	if err := bc.validator.ValidateBlock(b); err != nil {
-		return err
+		return nil, err
 	}
 
-	return bc.addBlockWithoutValidation(b)
+	bc.lock.Lock()
+	node, err := bc.newBlockNode(b)
+	bc.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := bc.connectBlock(node)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bc.commitBlock(node); err != nil {
+		return nil, err
+	}
+
+	return event, nil
 }
 
 func (bc *Blockchain) handleNativeTransfer(transaction *Transaction) error {
@@ -82,14 +254,14 @@ func (bc *Blockchain) handleNativeNFT(transaction *Transaction) error {
 
 	switch t := transaction.TransactionInner.(type) {
 	case CollectionTransaction:
-		bc.collectionState[hash] = &t
+		bc.putCollection(hash, &t)
 		bc.logger.Log("msg", "created new NFT collection", "hash", hash)
 	case MintTransaction:
 		_, ok := bc.collectionState[t.Collection]
 		if !ok {
 			return fmt.Errorf("collection (%s) does not exist on the blockchain", t.Collection)
 		}
-		bc.mintState[hash] = &t
+		bc.putMint(hash, &t)
 
 		bc.logger.Log("msg", "created new NFT mint", "NFT", t.NFT, "collection", t.Collection)
 	default:
@@ -99,37 +271,62 @@ func (bc *Blockchain) handleNativeNFT(transaction *Transaction) error {
 	return nil
 }
 
+func (bc *Blockchain) putCollection(hash types.Hash, collection *CollectionTransaction) {
+	bc.journal.append(collectionChange{bc: bc, hash: hash, prev: bc.collectionState[hash]})
+	bc.collectionState[hash] = collection
+}
+
+func (bc *Blockchain) putMint(hash types.Hash, mint *MintTransaction) {
+	bc.journal.append(mintChange{bc: bc, hash: hash, prev: bc.mintState[hash]})
+	bc.mintState[hash] = mint
+}
+
+// GetBlockByHash returns any known block, including blocks on side branches.
 func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
 	bc.lock.Lock()
 	defer bc.lock.Unlock()
 
-	block, ok := bc.blockStore[hash]
+	node, ok := bc.tree[hash]
 	if !ok {
 		return nil, fmt.Errorf("block with hash (%s) not found", hash)
 	}
 
-	return block, nil
+	return node.block, nil
+}
+
+// HasBlockHash reports whether the block with the given hash is known, it
+// does not have to be part of the canonical chain.
+func (bc *Blockchain) HasBlockHash(hash types.Hash) bool {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	_, ok := bc.tree[hash]
+	return ok
 }
 
 func (bc *Blockchain) GetBlock(height uint32) (*Block, error) {
-	if height > bc.Height() {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	// A reorg can shorten the chain, so the height is checked under the
+	// same lock the blocks are read with.
+	if int(height) >= len(bc.blocks) {
 		return nil, fmt.Errorf("given height (%d) too high", height)
 	}
 
-	bc.lock.Lock()
-	defer bc.lock.Unlock()
-
 	return bc.blocks[height], nil
 }
 
 func (bc *Blockchain) GetHeader(height uint32) (*Header, error) {
-	if height > bc.Height() {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	// A reorg can shorten the chain, so the height is checked under the
+	// same lock the headers are read with.
+	if int(height) >= len(bc.headers) {
 		return nil, fmt.Errorf("given height (%d) too high", height)
 	}
 
-	bc.lock.Lock()
-	defer bc.lock.Unlock()
-
 	return bc.headers[height], nil
 }
 
@@ -145,6 +342,25 @@ func (bc *Blockchain) GetTransactionByHash(hash types.Hash) (*Transaction, error
 	return Transaction, nil
 }
 
+// GetTransactionProof returns the block that includes the transaction with
+// the given hash together with a Merkle proof of its inclusion.
+func (bc *Blockchain) GetTransactionProof(hash types.Hash) (*Block, *MerkleProof, error) {
+	bc.lock.RLock()
+	block, ok := bc.transactionBlock[hash]
+	bc.lock.RUnlock()
+
+	if !ok {
+		return nil, nil, fmt.Errorf("could not find transaction with hash (%s)", hash)
+	}
+
+	proof, err := block.TransactionProof(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return block, proof, nil
+}
+
 func (bc *Blockchain) HasBlock(height uint32) bool {
 	return height <= bc.Height()
 }
@@ -158,69 +374,291 @@ func (bc *Blockchain) Height() uint32 {
 	return uint32(len(bc.headers) - 1)
 }
 
-func (bc *Blockchain) handleTransaction(transaction *Transaction) error {
+// handleTransaction runs the transaction as part of the block at the given
+// height and returns the gas its code used.
+func (bc *Blockchain) handleTransaction(transaction *Transaction, height uint32) (uint64, error) {
+	var gasUsed uint64
+
 	// If we have data inside execute that data on the VM.
 	if len(transaction.Data) > 0 {
 		bc.logger.Log("msg", "executing code", "len", len(transaction.Data), "hash", transaction.Hash(&TransactionHasher{}))
 
-		vm := NewVM(transaction.Data, bc.contractState)
-		if err := vm.Run(); err != nil {
-			return err
+		vm := NewVM(transaction.Data, bc.contractState, transaction.GasLimit)
+		err := vm.Run()
+		gasUsed = vm.GasUsed()
+		if err != nil {
+			return gasUsed, err
 		}
 	}
 
 	// If the TransactionInner of the transaction is not nil we need to handle
-	// the native NFT implemtation.
-	if transaction.TransactionInner != nil {
+	// the native NFT implemtation, staking or slashing.
+	switch t := transaction.TransactionInner.(type) {
+	case nil:
+	case StakeTransaction, DelegateTransaction, UnstakeTransaction:
+		if err := bc.handleStaking(transaction, height); err != nil {
+			return gasUsed, err
+		}
+	case DoubleSignEvidence:
+		if err := bc.handleEvidence(&t, height); err != nil {
+			return gasUsed, err
+		}
+	default:
 		if err := bc.handleNativeNFT(transaction); err != nil {
-			return err
+			return gasUsed, err
 		}
 	}
 
 	// Handle the native transaction here
 	if transaction.Value > 0 {
 		if err := bc.handleNativeTransfer(transaction); err != nil {
-			return err
+			return gasUsed, err
 		}
 	}
 
-	return nil
+	return gasUsed, nil
 }
 
 func (bc *Blockchain) addBlockWithoutValidation(b *Block) error {
-	bc.stateLock.Lock()
-	for i := 0; i < len(b.Transactions); i++ {
-		if err := bc.handleTransaction(b.Transactions[i]); err != nil {
-			bc.logger.Log("error", err.Error())
+	bc.lock.Lock()
+	node, err := bc.newBlockNode(b)
+	bc.lock.Unlock()
+	if err != nil {
+		return err
+	}
 
-			b.Transactions[i] = b.Transactions[len(b.Transactions)-1]
-			b.Transactions = b.Transactions[:len(b.Transactions)-1]
+	receipts, err := bc.executeGenesis(b)
+	if err != nil {
+		return err
+	}
+	bc.recordValidators(node)
+	bc.appendBlock(node, receipts)
 
-			continue
+	return bc.commitBlock(node)
+}
+
+// commitBlock persists a block that was added to the tree and snapshots the
+// state when the block became the new head.
+func (bc *Blockchain) commitBlock(node *blockNode) error {
+	b := node.block
+
+	bc.logger.Log(
+		"msg", "new block",
+		"hash", node.hash,
+		"height", b.Height,
+		"transactions", len(b.Transactions),
+	)
+
+	if err := bc.store.Put(b); err != nil {
+		return err
+	}
+
+	if node != bc.currentHead() {
+		return nil
+	}
+
+	return bc.maybeSnapshot(b)
+}
+
+// executeNodeWithoutLock executes the block of the node and lets the
+// validator check the resulting state root. All changes of the block are
+// reverted when the root is rejected, otherwise they are kept as the undo
+// log of the node. The caller must hold the stateLock.
+func (bc *Blockchain) executeNodeWithoutLock(node *blockNode) ([]*Receipt, error) {
+	b := node.block
+
+	start := bc.journal.snapshot()
+	receipts, err := bc.executeBlockWithoutLock(b)
+	if err != nil {
+		bc.journal.revertTo(start)
+		return nil, err
+	}
+
+	if err := bc.validator.ValidateStateRoot(b, bc.stateRoot()); err != nil {
+		bc.journal.revertTo(start)
+		return nil, err
+	}
+
+	node.undo = bc.journal.take(start)
+
+	return receipts, nil
+}
+
+// executeBlockWithoutLock runs the transactions of the block in order. The
+// changes of every transaction are applied as one unit, a transaction that
+// fails leaves no trace in the state and gets a failed receipt instead. The
+// block itself is never modified.
+//
+// A transaction with the wrong nonce or a fee the sender can not pay makes
+// the whole block invalid, the changes made so far are left for the caller
+// to revert. Once all transactions ran the staking changes of the block take
+// effect and the consensus engine pays the rewards.
+func (bc *Blockchain) executeBlockWithoutLock(b *Block) ([]*Receipt, error) {
+	var (
+		receipts = make([]*Receipt, len(b.Transactions))
+		fees     uint64
+	)
+
+	for i, transaction := range b.Transactions {
+		receipt, err := bc.applyTransaction(transaction, b.Height)
+		if err != nil {
+			return nil, err
+		}
+		receipts[i] = receipt
+
+		if fees > math.MaxUint64-receipt.Fee {
+			return nil, fmt.Errorf("fees of block (%s) overflow", b.Hash(BlockHasher{}))
+		}
+		fees += receipt.Fee
+	}
+
+	if err := bc.endBlock(b); err != nil {
+		return nil, err
+	}
+
+	if err := bc.engine.Finalize(bc, bc.accountState, b, fees); err != nil {
+		return nil, err
+	}
+
+	return receipts, nil
+}
+
+func (bc *Blockchain) applyTransaction(transaction *Transaction, height uint32) (*Receipt, error) {
+	var (
+		hash = transaction.Hash(TransactionHasher{})
+		from = transaction.From.Address()
+	)
+
+	if nonce := bc.accountState.GetNonce(from); transaction.Nonce != nonce {
+		return nil, fmt.Errorf("%w: transaction (%s) has nonce (%d) expected (%d)", ErrInvalidNonce, hash, transaction.Nonce, nonce)
+	}
+
+	fee, err := transaction.TotalFee()
+	if err != nil {
+		return nil, fmt.Errorf("transaction (%s) has an invalid fee: %w", hash, err)
+	}
+
+	// The fee and the price of all gas are charged up front, the fee is not
+	// refunded when the transaction fails.
+	if err := bc.accountState.Debit(from, fee); err != nil {
+		return nil, fmt.Errorf("%w: transaction (%s) with fee (%d): %s", ErrInsufficientFee, hash, fee, err)
+	}
+
+	id := bc.journal.snapshot()
+	gasUsed, err := bc.handleTransaction(transaction, height)
+	if err != nil {
+		bc.journal.revertTo(id)
+	}
+
+	// The gas that was not used is refunded, a transaction that ran out
+	// of gas used all of it.
+	if refund := (transaction.GasLimit - gasUsed) * transaction.GasPrice; refund > 0 {
+		if err := bc.accountState.Credit(from, refund); err != nil {
+			return nil, err
 		}
+		fee -= refund
 	}
-	bc.stateLock.Unlock()
 
-	// fmt.Println("========ACCOUNT STATE==============")
-	// fmt.Printf("%+v\n", bc.accountState.accounts)
-	// fmt.Println("========ACCOUNT STATE==============")
+	// The nonce is used up even when the transaction fails, otherwise it
+	// could be included again.
+	bc.accountState.IncrementNonce(from)
+
+	if err != nil {
+		bc.logger.Log("msg", "transaction failed", "hash", hash, "error", err.Error())
+
+		return &Receipt{
+			TransactionHash: hash,
+			Status:          ReceiptStatusFailed,
+			Error:           err.Error(),
+			Fee:             fee,
+			GasUsed:         gasUsed,
+		}, nil
+	}
+
+	return &Receipt{
+		TransactionHash: hash,
+		Status:          ReceiptStatusSuccess,
+		Fee:             fee,
+		GasUsed:         gasUsed,
+	}, nil
+}
+
+// BlockReward returns the amount minted to the validator of every block
+// after the genesis block.
+func (bc *Blockchain) BlockReward() uint64 {
+	return bc.blockReward
+}
 
+// Engine returns the consensus engine of the chain.
+func (bc *Blockchain) Engine() Engine {
+	return bc.engine
+}
+
+// ChainID returns the identifier of the network the chain belongs to.
+func (bc *Blockchain) ChainID() uint64 {
+	return bc.chainID
+}
+
+// GetBalance returns the balance of the account with the given address.
+func (bc *Blockchain) GetBalance(address types.Address) (uint64, error) {
+	return bc.accountState.GetBalance(address)
+}
+
+// GetNonce returns the nonce the next transaction sent from the given
+// address has to use.
+func (bc *Blockchain) GetNonce(address types.Address) uint64 {
+	return bc.accountState.GetNonce(address)
+}
+
+func (bc *Blockchain) currentHead() *blockNode {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.head
+}
+
+// appendBlock makes the block of the node the new head of the canonical
+// chain.
+func (bc *Blockchain) appendBlock(node *blockNode, receipts []*Receipt) {
 	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	b := node.block
+	bc.head = node
 	bc.headers = append(bc.headers, b.Header)
 	bc.blocks = append(bc.blocks, b)
-	bc.blockStore[b.Hash(BlockHasher{})] = b
+
+	// Only the most recent blocks can be unwound, forget the changes of
+	// the block that just fell out of that window.
+	if depth := int(bc.maxReorgDepth); len(bc.blocks) > depth {
+		old := bc.blocks[len(bc.blocks)-1-depth]
+		bc.tree[old.Hash(BlockHasher{})].undo = nil
+	}
 
 	for _, transaction := range b.Transactions {
-		bc.TransactionStore[transaction.Hash(TransactionHasher{})] = transaction
+		hash := transaction.Hash(TransactionHasher{})
+		bc.TransactionStore[hash] = transaction
+		bc.transactionBlock[hash] = b
 	}
-	bc.lock.Unlock()
 
-	bc.logger.Log(
-		"msg", "new block",
-		"hash", b.Hash(BlockHasher{}),
-		"height", b.Height,
-		"transactions", len(b.Transactions),
-	)
+	for _, receipt := range receipts {
+		bc.receipts[receipt.TransactionHash] = receipt
+	}
+}
 
-	return bc.store.Put(b)
+// removeHead removes the head block from the canonical chain.
+func (bc *Blockchain) removeHead(node *blockNode) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.headers = bc.headers[:len(bc.headers)-1]
+	bc.blocks = bc.blocks[:len(bc.blocks)-1]
+	bc.head = node.parent
+
+	for _, transaction := range node.block.Transactions {
+		hash := transaction.Hash(TransactionHasher{})
+		delete(bc.TransactionStore, hash)
+		delete(bc.transactionBlock, hash)
+		delete(bc.receipts, hash)
+	}
 }
@@ -3,6 +3,7 @@ package core
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/gabrielluizsf/go-web3/crypto"
 	"github.com/gabrielluizsf/go-web3/types"
@@ -12,11 +13,6 @@ import (
 
 func TestSendNativeTransferTamper(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
-	signer := crypto.GeneratePrivateKey()
-
-	block := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
-	assert.Nil(t, block.Sign(signer))
-
 	privKeyBob := crypto.GeneratePrivateKey()
 	privKeyAlice := crypto.GeneratePrivateKey()
 	amount := uint64(100)
@@ -34,7 +30,7 @@ func TestSendNativeTransferTamper(t *testing.T) {
 	hackerPrivKey := crypto.GeneratePrivateKey()
 	transaction.To = hackerPrivKey.PublicKey()
 
-	block.AddTransaction(transaction)
+	block := nextBlock(t, bc, transaction)
 	assert.NotNil(t, bc.AddBlock(block)) // this should fail
 
 	_, err := bc.accountState.GetAccount(hackerPrivKey.PublicKey().Address())
@@ -43,11 +39,6 @@ func TestSendNativeTransferTamper(t *testing.T) {
 
 func TestSendNativeTransferInsuffientBalance(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
-	signer := crypto.GeneratePrivateKey()
-
-	block := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
-	assert.Nil(t, block.Sign(signer))
-
 	privKeyBob := crypto.GeneratePrivateKey()
 	privKeyAlice := crypto.GeneratePrivateKey()
 	amount := uint64(100)
@@ -65,24 +56,65 @@ func TestSendNativeTransferInsuffientBalance(t *testing.T) {
 	fmt.Printf("alice => %s\n", privKeyAlice.PublicKey().Address())
 	fmt.Printf("bob => %s\n", privKeyBob.PublicKey().Address())
 
-	block.AddTransaction(transaction)
+	block := nextBlock(t, bc, transaction)
 	assert.Nil(t, bc.AddBlock(block))
+	assert.Equal(t, 2, len(block.Transactions))
 
 	_, err := bc.accountState.GetAccount(privKeyAlice.PublicKey().Address())
 	assert.NotNil(t, err)
 
+	balance, err := bc.accountState.GetBalance(privKeyBob.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(99), balance)
+
+	// The failed transaction stays in the block with a failed receipt.
 	hash := transaction.Hash(TransactionHasher{})
 	_, err = bc.GetTransactionByHash(hash)
-	assert.NotNil(t, err)
+	assert.Nil(t, err)
+
+	receipt, err := bc.GetReceipt(hash)
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptStatusFailed, receipt.Status)
+	assert.Equal(t, ErrInsufficientBalance.Error(), receipt.Error)
 }
 
-func TestSendNativeTransferSuccess(t *testing.T) {
+func TestFailedTransactionIsAtomic(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 
-	signer := crypto.GeneratePrivateKey()
+	privKeyBob := crypto.GeneratePrivateKey()
+	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
+	accountBob.Balance = 99
+
+	// Stores the value 5 under the key "FOO" before the transfer fails.
+	transaction := NewTransaction([]byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f})
+	transaction.To = crypto.GeneratePrivateKey().PublicKey()
+	transaction.Value = 100
+	transaction.GasLimit = 100
+	assert.Nil(t, transaction.Sign(privKeyBob))
 
-	block := randomBlock(t, uint32(1), getPrevBlockHash(t, bc, uint32(1)))
-	assert.Nil(t, block.Sign(signer))
+	succeeding := randomTransactionWithSignature(t)
+
+	block := nextBlock(t, bc, transaction, succeeding)
+	assert.Nil(t, bc.AddBlock(block))
+
+	_, err := bc.contractState.Get([]byte("FOO"))
+	assert.NotNil(t, err)
+
+	receipt, err := bc.GetReceipt(transaction.Hash(TransactionHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptStatusFailed, receipt.Status)
+
+	receipt, err = bc.GetReceipt(succeeding.Hash(TransactionHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptStatusSuccess, receipt.Status)
+
+	// The order of the transactions is untouched.
+	assert.Equal(t, transaction, block.Transactions[1])
+	assert.Equal(t, succeeding, block.Transactions[2])
+}
+
+func TestSendNativeTransferSuccess(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
 
 	privKeyBob := crypto.GeneratePrivateKey()
 	privKeyAlice := crypto.GeneratePrivateKey()
@@ -96,7 +128,7 @@ func TestSendNativeTransferSuccess(t *testing.T) {
 	transaction.To = privKeyAlice.PublicKey()
 	transaction.Value = amount
 	transaction.Sign(privKeyBob)
-	block.AddTransaction(transaction)
+	block := nextBlock(t, bc, transaction)
 
 	assert.Nil(t, bc.AddBlock(block))
 
@@ -105,12 +137,218 @@ func TestSendNativeTransferSuccess(t *testing.T) {
 	assert.Equal(t, amount, accountAlice.Balance)
 }
 
+func TestTransactionReplayRejected(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	privKeyAlice := crypto.GeneratePrivateKey()
+	addressBob := privKeyBob.PublicKey().Address()
+
+	accountBob := bc.accountState.CreateAccount(addressBob)
+	accountBob.Balance = 100
+
+	transaction := NewTransaction([]byte{})
+	transaction.To = privKeyAlice.PublicKey()
+	transaction.Value = 10
+	assert.Nil(t, transaction.Sign(privKeyBob))
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, transaction)))
+	assert.Equal(t, uint64(1), bc.GetNonce(addressBob))
+
+	// Including the same signed transaction again is rejected.
+	height := bc.Height() + 1
+	block := randomBlock(t, height, getPrevBlockHash(t, bc, height))
+	block.AddTransaction(transaction)
+	assert.ErrorIs(t, bc.SetStateRoot(block), ErrInvalidNonce)
+	assert.Nil(t, block.Sign(crypto.GeneratePrivateKey()))
+	assert.ErrorIs(t, bc.AddBlock(block), ErrInvalidNonce)
+
+	balance, err := bc.accountState.GetBalance(addressBob)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(90), balance)
+
+	// A failed transaction uses up its nonce as well.
+	failed := NewTransaction([]byte{})
+	failed.To = privKeyAlice.PublicKey()
+	failed.Value = 1000
+	failed.Nonce = 1
+	assert.Nil(t, failed.Sign(privKeyBob))
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, failed)))
+	assert.Equal(t, uint64(2), bc.GetNonce(addressBob))
+}
+
+func TestTransactionFeesAndBlockReward(t *testing.T) {
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}), BlockchainOpts{BlockReward: 5})
+	assert.Nil(t, err)
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	privKeyAlice := crypto.GeneratePrivateKey()
+	addressBob := privKeyBob.PublicKey().Address()
+	addressAlice := privKeyAlice.PublicKey().Address()
+
+	accountBob := bc.accountState.CreateAccount(addressBob)
+	accountBob.Balance = 100
+
+	transfer := NewTransaction(nil)
+	transfer.To = privKeyAlice.PublicKey()
+	transfer.Value = 50
+	transfer.Fee = 10
+	assert.Nil(t, transfer.Sign(privKeyBob))
+
+	// The fee is paid even though the transfer fails.
+	failed := NewTransaction(nil)
+	failed.To = privKeyAlice.PublicKey()
+	failed.Value = 1000
+	failed.Fee = 10
+	failed.Nonce = 1
+	assert.Nil(t, failed.Sign(privKeyBob))
+
+	block := nextBlock(t, bc, transfer, failed)
+	assert.Nil(t, bc.AddBlock(block))
+
+	balance, err := bc.GetBalance(addressBob)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(30), balance)
+
+	balance, err = bc.GetBalance(addressAlice)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(50), balance)
+
+	balance, err = bc.GetBalance(block.Validator.Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(25), balance)
+
+	receipt, err := bc.GetReceipt(failed.Hash(TransactionHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptStatusFailed, receipt.Status)
+	assert.Equal(t, uint64(10), receipt.Fee)
+
+	// A fee the sender can not pay makes the block invalid.
+	expensive := NewTransaction(nil)
+	expensive.Fee = 31
+	expensive.Nonce = 2
+	assert.Nil(t, expensive.Sign(privKeyBob))
+
+	height := bc.Height() + 1
+	block = randomBlock(t, height, getPrevBlockHash(t, bc, height))
+	block.AddTransaction(expensive)
+	assert.ErrorIs(t, bc.SetStateRoot(block), ErrInsufficientFee)
+
+	// Negative fees of the native NFT part are rejected.
+	collection := NewTransaction(nil)
+	collection.TransactionInner = CollectionTransaction{Fee: -1}
+	assert.Nil(t, collection.Sign(privKeyBob))
+	assert.ErrorIs(t, collection.Verify(), ErrNegativeFee)
+}
+
+func TestTransactionGas(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	addressBob := privKeyBob.PublicKey().Address()
+	accountBob := bc.accountState.CreateAccount(addressBob)
+	accountBob.Balance = 1000
+
+	// Stores the value 5 under the key "FOO", which uses 29 gas.
+	data := []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
+
+	store := NewTransaction(data)
+	store.Fee = 10
+	store.GasLimit = 100
+	store.GasPrice = 2
+	assert.Nil(t, store.Sign(privKeyBob))
+
+	// Runs out of gas right before the store, all gas is charged.
+	outOfGas := NewTransaction(data)
+	outOfGas.GasLimit = 28
+	outOfGas.GasPrice = 2
+	outOfGas.Nonce = 1
+	assert.Nil(t, outOfGas.Sign(privKeyBob))
+
+	block := nextBlock(t, bc, store, outOfGas)
+	assert.Nil(t, bc.AddBlock(block))
+
+	receipt, err := bc.GetReceipt(store.Hash(TransactionHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptStatusSuccess, receipt.Status)
+	assert.Equal(t, uint64(29), receipt.GasUsed)
+	assert.Equal(t, uint64(10+29*2), receipt.Fee)
+
+	receipt, err = bc.GetReceipt(outOfGas.Hash(TransactionHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptStatusFailed, receipt.Status)
+	assert.Equal(t, ErrOutOfGas.Error(), receipt.Error)
+	assert.Equal(t, uint64(28), receipt.GasUsed)
+	assert.Equal(t, uint64(28*2), receipt.Fee)
+
+	balance, err := bc.GetBalance(addressBob)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000-68-56), balance)
+
+	balance, err = bc.GetBalance(block.Validator.Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(68+56), balance)
+
+	// The sender has to be able to pay for the whole gas limit.
+	expensive := NewTransaction(data)
+	expensive.GasLimit = 1000
+	expensive.GasPrice = 1
+	expensive.Nonce = 2
+	assert.Nil(t, expensive.Sign(privKeyBob))
+
+	height := bc.Height() + 1
+	block = randomBlock(t, height, getPrevBlockHash(t, bc, height))
+	block.AddTransaction(expensive)
+	assert.ErrorIs(t, bc.SetStateRoot(block), ErrInsufficientFee)
+}
+
+func TestGenesisAlloc(t *testing.T) {
+	privKeyBob := crypto.GeneratePrivateKey()
+	addressBob := privKeyBob.PublicKey().Address()
+	store := NewMemoryStore()
+
+	opts := BlockchainOpts{
+		Store:       store,
+		BlockReward: 5,
+		Genesis: Genesis{
+			Alloc: map[types.Address]uint64{addressBob: 1000},
+		},
+	}
+	genesis := randomBlock(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, opts)
+	assert.Nil(t, err)
+
+	balance, err := bc.GetBalance(addressBob)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), balance)
+
+	transaction := NewTransaction(nil)
+	transaction.To = crypto.GeneratePrivateKey().PublicKey()
+	transaction.Value = 400
+	transaction.Fee = 3
+	assert.Nil(t, transaction.Sign(privKeyBob))
+
+	for _, transactions := range [][]*Transaction{{transaction}, nil} {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc, transactions...)))
+	}
+
+	// Transfers and fees move coins around, only rewards add new ones.
+	assert.Equal(t, uint64(1010), bc.TotalSupply().Uint64())
+
+	// The allocation is applied once, when the genesis block is replayed.
+	replayed, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.StateRoot(), replayed.StateRoot())
+	assert.Equal(t, uint64(1010), replayed.TotalSupply().Uint64())
+}
+
 func TestAddBlock(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 
 	lenBlocks := 1000
 	for i := 0; i < lenBlocks; i++ {
-		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		block := nextBlock(t, bc)
 		assert.Nil(t, bc.AddBlock(block))
 	}
 
@@ -119,6 +357,57 @@ func TestAddBlock(t *testing.T) {
 	assert.NotNil(t, bc.AddBlock(randomBlock(t, 89, types.Hash{})))
 }
 
+func TestAddBlockInvalidStateRoot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	privKeyBob := crypto.GeneratePrivateKey()
+	accountBob := bc.accountState.CreateAccount(privKeyBob.PublicKey().Address())
+	accountBob.Balance = 100
+	root := bc.StateRoot()
+
+	transaction := NewTransaction(nil)
+	transaction.To = crypto.GeneratePrivateKey().PublicKey()
+	transaction.Value = 50
+	assert.Nil(t, transaction.Sign(privKeyBob))
+
+	block := nextBlock(t, bc, transaction)
+	block.StateRoot = types.Hash{}
+	block.hash = types.Hash{}
+	assert.Nil(t, block.Sign(crypto.GeneratePrivateKey()))
+
+	assert.NotNil(t, bc.AddBlock(block))
+	assert.Equal(t, uint32(0), bc.Height())
+	assert.Equal(t, root, bc.StateRoot())
+
+	balance, err := bc.accountState.GetBalance(privKeyBob.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), balance)
+}
+
+func TestGetTransactionProof(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	transactions := []*Transaction{}
+	for i := 0; i < 3; i++ {
+		transactions = append(transactions, randomTransactionWithSignature(t))
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, transactions...)))
+
+	for _, transaction := range transactions {
+		hash := transaction.Hash(TransactionHasher{})
+		block, proof, err := bc.GetTransactionProof(hash)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(1), block.Height)
+
+		header, err := bc.GetHeader(1)
+		assert.Nil(t, err)
+		assert.True(t, VerifyTransactionProof(header, hash, proof))
+	}
+
+	_, _, err := bc.GetTransactionProof(types.Hash{})
+	assert.NotNil(t, err)
+}
+
 func TestNewBlockchain(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 	assert.NotNil(t, bc.validator)
@@ -137,7 +426,7 @@ func TestGetBlock(t *testing.T) {
 	lenBlocks := 100
 
 	for i := 0; i < lenBlocks; i++ {
-		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		block := nextBlock(t, bc)
 		assert.Nil(t, bc.AddBlock(block))
 
 		fetchedBlock, err := bc.GetBlock(block.Height)
@@ -151,7 +440,7 @@ func TestGetHeader(t *testing.T) {
 	lenBlocks := 1000
 
 	for i := 0; i < lenBlocks; i++ {
-		block := randomBlock(t, uint32(i+1), getPrevBlockHash(t, bc, uint32(i+1)))
+		block := nextBlock(t, bc)
 		assert.Nil(t, bc.AddBlock(block))
 		header, err := bc.GetHeader(block.Height)
 		assert.Nil(t, err)
@@ -162,7 +451,7 @@ func TestGetHeader(t *testing.T) {
 func TestAddBlockToHigh(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
 
-	assert.Nil(t, bc.AddBlock(randomBlock(t, 1, getPrevBlockHash(t, bc, uint32(1)))))
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
 	assert.NotNil(t, bc.AddBlock(randomBlock(t, 3, types.Hash{})))
 }
 
@@ -173,6 +462,48 @@ func newBlockchainWithGenesis(t *testing.T) *Blockchain {
 	return bc
 }
 
+func TestAddBlockWrongChainID(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	b := nextBlock(t, bc)
+	b.ChainID = 7
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	assert.NotNil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(0), bc.Height())
+}
+
+// nextBlock returns a block on top of the chain that holds a random
+// transaction followed by the given ones, signed by a random key at the time
+// of the clock of the chain.
+func nextBlock(t *testing.T, bc *Blockchain, transactions ...*Transaction) *Block {
+	transactions = append([]*Transaction{randomTransactionWithSignature(t)}, transactions...)
+	return proposeBlock(t, bc, crypto.GeneratePrivateKey(), bc.Now(), transactions...)
+}
+
+// proposeBlock returns the block on top of the chain with the given
+// timestamp and transactions, signed by the given key. It commits to the
+// state after executing the transactions. The tests of other packages use
+// util.ProposeBlock, which imports core and so can not be used here.
+func proposeBlock(t *testing.T, bc *Blockchain, privKey crypto.PrivateKey, timestamp time.Time, transactions ...*Transaction) *Block {
+	header, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+
+	b, err := NewBlockFromPrevHeader(header, transactions, timestamp)
+	assert.Nil(t, err)
+	// The timestamp is kept even when it is not after the parent, so tests
+	// can build blocks that break the timestamp rules.
+	b.Timestamp = timestamp.UnixNano()
+
+	// Fees and rewards go to the validator, so it has to be known before
+	// the state root is calculated.
+	b.Validator = privKey.PublicKey()
+	assert.Nil(t, bc.SetStateRoot(b))
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}
+
 func getPrevBlockHash(t *testing.T, bc *Blockchain, height uint32) types.Hash {
 	prevHeader, err := bc.GetHeader(height - 1)
 	assert.Nil(t, err)
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+const (
+	defaultMaxClockDrift = 15 * time.Second
+	// defaultMedianTimeBlocks is the number of blocks the median time past
+	// is taken over.
+	defaultMedianTimeBlocks = 11
+)
+
+var (
+	ErrTimestampNotIncreasing = errors.New("block timestamp is not after its parent")
+	ErrTimestampBeforeMedian  = errors.New("block timestamp is not after the median time past")
+	ErrTimestampInFuture      = errors.New("block timestamp is too far in the future")
+)
+
+// Clock tells the local time blocks are checked against, it can be replaced
+// so tests do not depend on the wall clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock of the machine.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Now returns the time of the clock of the chain.
+func (bc *Blockchain) Now() time.Time {
+	return bc.clock.Now()
+}
+
+// MedianTimePast returns the median timestamp of the block with the given
+// hash and the blocks before it, taken over up to MedianTimeBlocks blocks of
+// its branch.
+func (bc *Blockchain) MedianTimePast(hash types.Hash) (int64, error) {
+	timestamps := make([]int64, 0, bc.medianTimeBlocks)
+
+	for len(timestamps) < bc.medianTimeBlocks {
+		b, err := bc.GetBlockByHash(hash)
+		if err != nil {
+			return 0, err
+		}
+		timestamps = append(timestamps, b.Timestamp)
+
+		if b.Height == 0 {
+			break
+		}
+		hash = b.PrevBlockHash
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	return timestamps[len(timestamps)/2], nil
+}
+
+// validateTimestamp checks that the block is later than its parent and the
+// median time past of its parent, and not further ahead of the local clock
+// than the allowed drift.
+func (bc *Blockchain) validateTimestamp(b *Block, parent *Block) error {
+	hash := b.Hash(BlockHasher{})
+
+	if b.Timestamp <= parent.Timestamp {
+		return fmt.Errorf("%w: block (%s) has timestamp (%d) parent has (%d)", ErrTimestampNotIncreasing, hash, b.Timestamp, parent.Timestamp)
+	}
+
+	// A chain that always enforced the rule above can not violate this one,
+	// it guards branches that contain blocks from before the rule.
+	median, err := bc.MedianTimePast(b.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	if b.Timestamp <= median {
+		return fmt.Errorf("%w: block (%s) has timestamp (%d) median is (%d)", ErrTimestampBeforeMedian, hash, b.Timestamp, median)
+	}
+
+	if limit := bc.clock.Now().Add(bc.maxClockDrift).UnixNano(); b.Timestamp > limit {
+		return fmt.Errorf("%w: block (%s) has timestamp (%d) limit is (%d)", ErrTimestampInFuture, hash, b.Timestamp, limit)
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+func TestBlockTimestamp(t *testing.T) {
+	var (
+		start = time.Unix(1700000000, 0)
+		clock = &manualClock{now: start}
+	)
+
+	genesis := Genesis{Timestamp: start.UnixNano()}
+	block, err := genesis.Block()
+	assert.Nil(t, err)
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), block, BlockchainOpts{
+		Genesis:          genesis,
+		Clock:            clock,
+		MaxClockDrift:    5 * time.Second,
+		MedianTimeBlocks: 3,
+	})
+	assert.Nil(t, err)
+
+	// Blocks can not go back in time or stand still.
+	assert.ErrorIs(t, bc.AddBlock(proposeBlock(t, bc, crypto.GeneratePrivateKey(), start)), ErrTimestampNotIncreasing)
+	assert.ErrorIs(t, bc.AddBlock(proposeBlock(t, bc, crypto.GeneratePrivateKey(), start.Add(-time.Second))), ErrTimestampNotIncreasing)
+
+	// Nor can they be further ahead of the local clock than the drift.
+	future := proposeBlock(t, bc, crypto.GeneratePrivateKey(), start.Add(6*time.Second))
+	assert.ErrorIs(t, bc.AddBlock(future), ErrTimestampInFuture)
+	assert.Equal(t, uint32(0), bc.Height())
+
+	// Once the clock caught up the same block is fine.
+	clock.now = start.Add(time.Second)
+	assert.Nil(t, bc.AddBlock(future))
+
+	for i := 7; i <= 10; i++ {
+		clock.now = start.Add(time.Duration(i) * time.Second)
+		assert.Nil(t, bc.AddBlock(proposeBlock(t, bc, crypto.GeneratePrivateKey(), clock.now)))
+	}
+
+	// The median is taken over the last three blocks at 8, 9 and 10 seconds.
+	median, err := bc.MedianTimePast(bc.currentHead().hash)
+	assert.Nil(t, err)
+	assert.Equal(t, start.Add(9*time.Second).UnixNano(), median)
+
+	// The genesis block is its own median.
+	median, err = bc.MedianTimePast(block.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, start.UnixNano(), median)
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+var ErrInvalidCommit = errors.New("invalid commit certificate")
+
+type VoteType byte
+
+const (
+	VotePrevote VoteType = iota + 1
+	VotePrecommit
+)
+
+func (t VoteType) String() string {
+	switch t {
+	case VotePrevote:
+		return "prevote"
+	case VotePrecommit:
+		return "precommit"
+	default:
+		return fmt.Sprintf("VoteType(%d)", byte(t))
+	}
+}
+
+// Vote is the signed vote of a validator for a block in a round. A vote with
+// a zero BlockHash is a vote for no block at all.
+type Vote struct {
+	Type      VoteType
+	ChainID   uint64
+	Height    uint32
+	Round     uint32
+	BlockHash types.Hash
+	Validator crypto.PublicKey
+	Signature *crypto.Signature
+}
+
+// Bytes encodes the vote without its signature in a fixed layout.
+func (v *Vote) Bytes() []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(byte(v.Type))
+	binary.Write(buf, binary.LittleEndian, v.Height)
+	binary.Write(buf, binary.LittleEndian, v.Round)
+	buf.Write(v.BlockHash[:])
+	buf.Write(v.Validator)
+
+	return buf.Bytes()
+}
+
+// SigningHash returns the digest the validator signs.
+func (v *Vote) SigningHash() types.Hash {
+	return signingHash(voteSigningDomain, v.ChainID, v.Bytes())
+}
+
+func (v *Vote) Sign(privKey crypto.PrivateKey) error {
+	// The validator is part of the signed bytes, so it has to be set first.
+	v.Validator = privKey.PublicKey()
+
+	hash := v.SigningHash()
+	sig, err := privKey.Sign(hash.ToSlice())
+	if err != nil {
+		return err
+	}
+	v.Signature = sig
+
+	return nil
+}
+
+func (v *Vote) Verify() error {
+	if v.Signature == nil {
+		return fmt.Errorf("%s has no signature", v.Type)
+	}
+
+	hash := v.SigningHash()
+	if !v.Signature.Verify(v.Validator, hash.ToSlice()) {
+		return fmt.Errorf("%s of (%s) has an invalid signature", v.Type, v.Validator.Address())
+	}
+
+	return nil
+}
+
+// Quorum returns the number of votes that is needed out of n validators,
+// more than two thirds of them.
+func Quorum(n int) int {
+	return 2*n/3 + 1
+}
+
+// CommitCertificate is the set of precommits that committed a block. It is
+// valid when more than two thirds of the validators precommitted the block
+// in the same round.
+type CommitCertificate struct {
+	Height     uint32
+	Round      uint32
+	BlockHash  types.Hash
+	Precommits []*Vote
+}
+
+// Verify checks the certificate against the given chain and validator set.
+func (c *CommitCertificate) Verify(chainID uint64, validators []crypto.PublicKey) error {
+	if c.BlockHash.IsZero() {
+		return fmt.Errorf("%w: commit for no block", ErrInvalidCommit)
+	}
+
+	members := make(map[types.Address]bool, len(validators))
+	for _, validator := range validators {
+		members[validator.Address()] = true
+	}
+
+	signers := make(map[types.Address]bool, len(c.Precommits))
+	for _, vote := range c.Precommits {
+		if vote.Type != VotePrecommit || vote.ChainID != chainID || vote.Height != c.Height || vote.Round != c.Round || vote.BlockHash != c.BlockHash {
+			return fmt.Errorf("%w: vote of (%s) does not match the certificate", ErrInvalidCommit, vote.Validator.Address())
+		}
+
+		address := vote.Validator.Address()
+		if !members[address] {
+			return fmt.Errorf("%w: (%s) is not a validator", ErrInvalidCommit, address)
+		}
+		if signers[address] {
+			return fmt.Errorf("%w: (%s) voted twice", ErrInvalidCommit, address)
+		}
+
+		if err := vote.Verify(); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidCommit, err)
+		}
+		signers[address] = true
+	}
+
+	if len(signers) < Quorum(len(validators)) {
+		return fmt.Errorf("%w: (%d) of (%d) validators precommitted", ErrInvalidCommit, len(signers), len(validators))
+	}
+
+	return nil
+}
+
+// Proposal is the block the proposer of a round proposes. A validator that
+// locked on a block in an earlier round proposes that block again, so the
+// block itself does not have to be signed by the proposer of the round.
+type Proposal struct {
+	ChainID uint64
+	Round   uint32
+	// POLRound is the earlier round in which more than two thirds of the
+	// validators prevoted for the block, the proof of lock that lets
+	// validators locked on another block unlock. It is -1 without one.
+	POLRound  int32
+	Block     *Block
+	Validator crypto.PublicKey
+	Signature *crypto.Signature
+}
+
+// Bytes encodes the proposal without its signature in a fixed layout, the
+// block is committed to by its hash.
+func (p *Proposal) Bytes() []byte {
+	hash := p.Block.Hash(BlockHasher{})
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, p.Block.Height)
+	binary.Write(buf, binary.LittleEndian, p.Round)
+	binary.Write(buf, binary.LittleEndian, p.POLRound)
+	buf.Write(hash[:])
+	buf.Write(p.Validator)
+
+	return buf.Bytes()
+}
+
+// SigningHash returns the digest the proposer signs.
+func (p *Proposal) SigningHash() types.Hash {
+	return signingHash(proposalSigningDomain, p.ChainID, p.Bytes())
+}
+
+func (p *Proposal) Sign(privKey crypto.PrivateKey) error {
+	p.Validator = privKey.PublicKey()
+
+	hash := p.SigningHash()
+	sig, err := privKey.Sign(hash.ToSlice())
+	if err != nil {
+		return err
+	}
+	p.Signature = sig
+
+	return nil
+}
+
+func (p *Proposal) Verify() error {
+	if p.Block == nil {
+		return fmt.Errorf("proposal has no block")
+	}
+	if p.Signature == nil {
+		return fmt.Errorf("proposal has no signature")
+	}
+
+	hash := p.SigningHash()
+	if !p.Signature.Verify(p.Validator, hash.ToSlice()) {
+		return fmt.Errorf("proposal of (%s) has an invalid signature", p.Validator.Address())
+	}
+
+	return nil
+}
+
+// isValidator reports whether the key is part of the current validator set.
+func (bc *Blockchain) isValidator(key crypto.PublicKey) bool {
+	for _, validator := range bc.Validators() {
+		if bytes.Equal(validator, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateProposal checks that the proposed block can be committed on top of
+// the current head without adding it. The proposal has to come from the
+// proposer of its round and the block is executed to check its state root,
+// all changes are reverted afterwards.
+func (bc *Blockchain) ValidateProposal(p *Proposal) error {
+	bc.insertLock.Lock()
+	defer bc.insertLock.Unlock()
+
+	if err := p.Verify(); err != nil {
+		return err
+	}
+
+	b := p.Block
+	hash := b.Hash(BlockHasher{})
+
+	if p.POLRound < -1 || p.POLRound >= int32(p.Round) {
+		return fmt.Errorf("proposal of block (%s) in round (%d) has proof of lock round (%d)", hash, p.Round, p.POLRound)
+	}
+
+	proposer := bc.Proposer(b.Height, uint64(p.Round))
+	if !bytes.Equal(proposer, p.Validator) {
+		return fmt.Errorf("%w: proposal of block (%s) is signed by (%s) expected (%s)", ErrWrongProposer, hash, p.Validator.Address(), proposer.Address())
+	}
+
+	head := bc.currentHead()
+	if b.PrevBlockHash != head.hash || b.Height != head.block.Height+1 {
+		return fmt.Errorf("proposed block (%s) does not extend the head (%s)", hash, head.hash)
+	}
+
+	if b.ChainID != bc.chainID {
+		return fmt.Errorf("block (%s) has chain id (%d) expected (%d)", hash, b.ChainID, bc.chainID)
+	}
+
+	if err := bc.validateTimestamp(b, head.block); err != nil {
+		return err
+	}
+
+	if err := b.Verify(); err != nil {
+		return err
+	}
+
+	if err := bc.validateLimits(b); err != nil {
+		return err
+	}
+
+	if !bc.isValidator(b.Validator) {
+		return fmt.Errorf("block (%s) is signed by (%s) which is not a validator", hash, b.Validator.Address())
+	}
+
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	start := bc.journal.snapshot()
+	defer bc.journal.revertTo(start)
+
+	if _, err := bc.executeBlockWithoutLock(b); err != nil {
+		return err
+	}
+
+	return bc.validator.ValidateStateRoot(b, bc.stateRoot())
+}
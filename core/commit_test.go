@@ -0,0 +1,117 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func precommit(t *testing.T, privKey crypto.PrivateKey, height uint32, hash types.Hash) *Vote {
+	vote := &Vote{
+		Type:      VotePrecommit,
+		Height:    height,
+		BlockHash: hash,
+	}
+	assert.Nil(t, vote.Sign(privKey))
+
+	return vote
+}
+
+func TestCommitCertificate(t *testing.T) {
+	privKeys := []crypto.PrivateKey{
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+	}
+	validators := []crypto.PublicKey{}
+	for _, privKey := range privKeys {
+		validators = append(validators, privKey.PublicKey())
+	}
+
+	hash := types.Hash{1}
+	commit := &CommitCertificate{Height: 1, BlockHash: hash}
+
+	// Two of four validators are not enough.
+	for _, privKey := range privKeys[:2] {
+		commit.Precommits = append(commit.Precommits, precommit(t, privKey, 1, hash))
+	}
+	assert.ErrorIs(t, commit.Verify(0, validators), ErrInvalidCommit)
+
+	// The same validator can not be counted twice.
+	commit.Precommits = append(commit.Precommits, commit.Precommits[0])
+	assert.ErrorIs(t, commit.Verify(0, validators), ErrInvalidCommit)
+
+	commit.Precommits[2] = precommit(t, privKeys[2], 1, hash)
+	assert.Nil(t, commit.Verify(0, validators))
+
+	// Votes from another chain or for another block do not count.
+	assert.NotNil(t, commit.Verify(1, validators))
+	commit.Precommits[2] = precommit(t, privKeys[2], 1, types.Hash{2})
+	assert.ErrorIs(t, commit.Verify(0, validators), ErrInvalidCommit)
+
+	// Neither do votes from outside of the validator set.
+	commit.Precommits[2] = precommit(t, crypto.GeneratePrivateKey(), 1, hash)
+	assert.ErrorIs(t, commit.Verify(0, validators), ErrInvalidCommit)
+}
+
+func TestValidateProposal(t *testing.T) {
+	privKeys := []crypto.PrivateKey{
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+	}
+	genesis := Genesis{
+		Timestamp: time.Now().UnixNano(),
+		Consensus: ConsensusParams{MaxTransactions: 1},
+	}
+	for _, privKey := range privKeys {
+		genesis.Validators = append(genesis.Validators, privKey.PublicKey())
+	}
+
+	block, err := genesis.Block()
+	assert.Nil(t, err)
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), block, BlockchainOpts{Genesis: genesis})
+	assert.Nil(t, err)
+
+	// A block over the limits of the chain can never be committed, so it is
+	// not worth a vote.
+	header, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+	large, err := NewBlockFromPrevHeader(header, []*Transaction{randomTransactionWithSignature(t), randomTransactionWithSignature(t)}, bc.Now())
+	assert.Nil(t, err)
+	large.Validator = privKeys[1].PublicKey()
+	assert.Nil(t, large.Sign(privKeys[1]))
+	proposal := &Proposal{Round: 0, POLRound: -1, Block: large}
+	assert.Nil(t, proposal.Sign(privKeys[1]))
+	assert.ErrorIs(t, bc.ValidateProposal(proposal), ErrTooManyTransactions)
+
+	b := proposeBlock(t, bc, privKeys[1], bc.Now())
+	proposal = &Proposal{Round: 0, POLRound: -1, Block: b}
+	assert.Nil(t, proposal.Sign(privKeys[1]))
+	assert.Nil(t, bc.ValidateProposal(proposal))
+
+	// The proof of lock has to be from an earlier round.
+	proposal.POLRound = 0
+	assert.Nil(t, proposal.Sign(privKeys[1]))
+	assert.NotNil(t, bc.ValidateProposal(proposal))
+	proposal.POLRound = -1
+
+	// Only the proposer of the round may propose.
+	proposal.Round = 1
+	assert.Nil(t, proposal.Sign(privKeys[1]))
+	assert.ErrorIs(t, bc.ValidateProposal(proposal), ErrWrongProposer)
+
+	// The proposer of the next round may propose the block again.
+	assert.Nil(t, proposal.Sign(privKeys[2]))
+	assert.Nil(t, bc.ValidateProposal(proposal))
+
+	// Validating does not change the chain.
+	assert.Equal(t, uint32(0), bc.Height())
+	assert.Equal(t, block.StateRoot, bc.StateRoot())
+	assertStateRoot(t, bc)
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+// ChainReader is the view of the chain a consensus engine works with.
+type ChainReader interface {
+	ChainID() uint64
+	// Validators returns the current validator set.
+	Validators() []crypto.PublicKey
+	// Proposer returns the validator that may propose the block at the
+	// given height and round, nil when there are no validators.
+	Proposer(height uint32, round uint64) crypto.PublicKey
+	// GetBlockByHash returns any known block, including side branches.
+	GetBlockByHash(hash types.Hash) (*Block, error)
+	// BlockReward returns the reward of every block after the genesis.
+	BlockReward() uint64
+	// Now returns the local time blocks are checked against.
+	Now() time.Time
+}
+
+// branchReader is the chain as seen from the tip of a branch. The validator
+// set is the one after the tip was executed, which differs from the set of
+// the head when the branch is not canonical. It takes no locks of its own.
+type branchReader struct {
+	*Blockchain
+	validators []crypto.PublicKey
+}
+
+func (r branchReader) Validators() []crypto.PublicKey {
+	validators := make([]crypto.PublicKey, len(r.validators))
+	copy(validators, r.validators)
+	return validators
+}
+
+func (r branchReader) Proposer(height uint32, round uint64) crypto.PublicKey {
+	return proposer(r.validators, height, round)
+}
+
+// Engine is a consensus engine. It decides who may produce a block and
+// what makes a block valid besides the rules every chain shares.
+type Engine interface {
+	// Prepare sets the consensus fields of a new header on top of its
+	// parent, before the transactions of the block are executed.
+	Prepare(chain ChainReader, header *Header, parent *Header) error
+	// Seal makes the block valid under the rules of the engine and signs
+	// it with the key. It may block until the block is sealed or stop is
+	// closed.
+	Seal(chain ChainReader, b *Block, privKey crypto.PrivateKey, stop <-chan struct{}) error
+	// VerifyHeader checks the consensus fields of the header against its
+	// parent.
+	VerifyHeader(chain ChainReader, header *Header, parent *Header) error
+	// VerifySeal checks that the block was sealed by someone who may seal
+	// it.
+	VerifySeal(chain ChainReader, b *Block, parent *Header) error
+	// Finalize pays the rewards of the block once all of its transactions
+	// ran, fees is the sum of the fees they paid.
+	Finalize(chain ChainReader, state *AccountState, b *Block, fees uint64) error
+	// ForkChoice decides which branch is the canonical chain.
+	ForkChoice() ForkChoice
+}
+
+// NopEngine accepts every block that passes the checks all chains share.
+// Anyone may produce a block and the validator of the block is paid.
+type NopEngine struct{}
+
+func (NopEngine) Prepare(ChainReader, *Header, *Header) error {
+	return nil
+}
+
+func (NopEngine) Seal(_ ChainReader, b *Block, privKey crypto.PrivateKey, _ <-chan struct{}) error {
+	return b.Sign(privKey)
+}
+
+func (NopEngine) VerifyHeader(ChainReader, *Header, *Header) error {
+	return nil
+}
+
+func (NopEngine) VerifySeal(ChainReader, *Block, *Header) error {
+	return nil
+}
+
+func (NopEngine) Finalize(chain ChainReader, state *AccountState, b *Block, fees uint64) error {
+	return RewardValidator(chain, state, b, fees)
+}
+
+func (NopEngine) ForkChoice() ForkChoice {
+	return LongestChain{}
+}
+
+// RewardValidator pays the fees and the block reward to the validator of the
+// block. The genesis block has no reward.
+func RewardValidator(chain ChainReader, state *AccountState, b *Block, fees uint64) error {
+	reward := fees
+	if b.Height > 0 {
+		if fees > math.MaxUint64-chain.BlockReward() {
+			return fmt.Errorf("fees of block (%s) overflow", b.Hash(BlockHasher{}))
+		}
+		reward += chain.BlockReward()
+	}
+
+	if err := state.Credit(b.Validator.Address(), reward); err != nil {
+		return fmt.Errorf("failed to pay validator of block (%s): %w", b.Hash(BlockHasher{}), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+var (
+	ErrInvalidEvidence   = errors.New("invalid double sign evidence")
+	ErrDuplicateEvidence = errors.New("double sign was already punished")
+	ErrEvidenceExpired   = errors.New("double sign evidence is too old")
+	ErrJailed            = errors.New("validator is jailed")
+)
+
+// SignedHeader is the header of a block together with the key and signature
+// of the validator that signed it.
+type SignedHeader struct {
+	Header    *Header
+	Validator crypto.PublicKey
+	Signature *crypto.Signature
+}
+
+// SignedHeader returns the header of the block with its signature.
+func (b *Block) SignedHeader() SignedHeader {
+	return SignedHeader{
+		Header:    b.Header,
+		Validator: b.Validator,
+		Signature: b.Signature,
+	}
+}
+
+func (h SignedHeader) Verify() error {
+	if h.Header == nil || h.Signature == nil {
+		return fmt.Errorf("header has no signature")
+	}
+
+	hash := h.Header.SigningHash()
+	if !h.Signature.Verify(h.Validator, hash.ToSlice()) {
+		return fmt.Errorf("header has invalid signature")
+	}
+
+	return nil
+}
+
+// DoubleSignEvidence proves that a validator signed two different blocks at
+// the same height. It is submitted as the TransactionInner of a transaction,
+// which slashes the stake of the validator and jails it.
+type DoubleSignEvidence struct {
+	A SignedHeader
+	B SignedHeader
+}
+
+// NewDoubleSignEvidence returns the evidence when the two blocks conflict.
+func NewDoubleSignEvidence(a, b *Block) (*DoubleSignEvidence, error) {
+	evidence := &DoubleSignEvidence{
+		A: a.SignedHeader(),
+		B: b.SignedHeader(),
+	}
+	if err := evidence.Verify(); err != nil {
+		return nil, err
+	}
+
+	return evidence, nil
+}
+
+// Offender returns the key of the validator that signed both headers.
+func (e *DoubleSignEvidence) Offender() crypto.PublicKey {
+	return e.A.Validator
+}
+
+// Height returns the height both headers were signed at.
+func (e *DoubleSignEvidence) Height() uint32 {
+	return e.A.Header.Height
+}
+
+// Hash identifies the evidence, it does not depend on the order of the two
+// headers.
+func (e *DoubleSignEvidence) Hash() types.Hash {
+	a, b := BlockHasher{}.Hash(e.A.Header), BlockHasher{}.Hash(e.B.Header)
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+
+	buf := new(bytes.Buffer)
+	buf.Write(a[:])
+	buf.Write(b[:])
+	buf.Write(e.A.Validator)
+
+	return sha256.Sum256(buf.Bytes())
+}
+
+// Verify checks that both headers are for the same height of the same
+// chain, differ and are signed by the same key.
+func (e *DoubleSignEvidence) Verify() error {
+	if e.A.Header == nil || e.B.Header == nil {
+		return fmt.Errorf("%w: missing header", ErrInvalidEvidence)
+	}
+
+	if e.A.Header.ChainID != e.B.Header.ChainID || e.A.Header.Height != e.B.Header.Height {
+		return fmt.Errorf("%w: headers are not for the same height", ErrInvalidEvidence)
+	}
+
+	hasher := BlockHasher{}
+	if hasher.Hash(e.A.Header) == hasher.Hash(e.B.Header) {
+		return fmt.Errorf("%w: headers are the same", ErrInvalidEvidence)
+	}
+
+	if !bytes.Equal(e.A.Validator, e.B.Validator) {
+		return fmt.Errorf("%w: headers are signed by different validators", ErrInvalidEvidence)
+	}
+
+	if err := e.A.Verify(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidEvidence, err)
+	}
+	if err := e.B.Verify(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidEvidence, err)
+	}
+
+	return nil
+}
+
+// offense is a double sign of a validator at a height, every offense is only
+// punished once.
+type offense struct {
+	validator types.Address
+	height    uint32
+}
+
+// Offense is a punished double sign.
+type Offense struct {
+	Validator types.Address
+	Height    uint32
+}
+
+// Jail is a validator that can not stake until the Release height.
+type Jail struct {
+	Validator types.Address
+	Release   uint32
+}
+
+// handleEvidence punishes the validator the evidence of the transaction
+// proves to have double signed. A part of the stake bonded to it is burned,
+// it loses its candidacy and is removed from the validator set right away,
+// unless it is the last validator.
+func (bc *Blockchain) handleEvidence(evidence *DoubleSignEvidence, height uint32) error {
+	if err := evidence.Verify(); err != nil {
+		return err
+	}
+
+	if evidence.A.Header.ChainID != bc.chainID {
+		return fmt.Errorf("%w: evidence has chain id (%d) expected (%d)", ErrInvalidEvidence, evidence.A.Header.ChainID, bc.chainID)
+	}
+
+	var (
+		params  = bc.genesis.Staking
+		key     = evidence.Offender()
+		address = key.Address()
+		offense = offense{validator: address, height: evidence.Height()}
+	)
+
+	if evidence.Height() > height {
+		return fmt.Errorf("%w: evidence is for future height (%d)", ErrInvalidEvidence, evidence.Height())
+	}
+	if params.UnbondingPeriod > 0 && height-evidence.Height() > params.UnbondingPeriod {
+		return fmt.Errorf("%w: evidence is for height (%d)", ErrEvidenceExpired, evidence.Height())
+	}
+	if _, ok := bc.stakingState.offenses[offense]; ok {
+		return fmt.Errorf("%w: (%s) at height (%d)", ErrDuplicateEvidence, address, evidence.Height())
+	}
+
+	index := -1
+	for i, validator := range bc.validators {
+		if bytes.Equal(validator, key) {
+			index = i
+		}
+	}
+	if index < 0 {
+		stake, err := bc.stakingState.Stake(address)
+		if err != nil {
+			return err
+		}
+		if stake == 0 {
+			return fmt.Errorf("%w: (%s) is not a validator", ErrInvalidEvidence, address)
+		}
+	}
+
+	bc.stakingState.putOffense(offense)
+	slashed, err := bc.stakingState.slash(address, params.SlashPercent)
+	if err != nil {
+		return err
+	}
+
+	release := uint32(math.MaxUint32)
+	if params.JailPeriod > 0 && height <= math.MaxUint32-params.JailPeriod {
+		release = height + params.JailPeriod
+	}
+	bc.stakingState.putJail(address, release)
+	bc.stakingState.deleteCandidate(address)
+
+	if index >= 0 && len(bc.validators) > 1 {
+		validators := make([]crypto.PublicKey, 0, len(bc.validators)-1)
+		validators = append(validators, bc.validators[:index]...)
+		validators = append(validators, bc.validators[index+1:]...)
+		bc.setValidators(validators)
+	}
+
+	bc.logger.Log("msg", "slashed validator for double signing", "validator", address, "height", evidence.Height(), "slashed", slashed, "release", release)
+
+	return nil
+}
+
+// Jailed reports whether the validator is jailed after the last added block.
+func (bc *Blockchain) Jailed(validator types.Address) bool {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	_, ok := bc.stakingState.jailed[validator]
+	return ok
+}
+
+// HasEvidence reports whether the double sign the evidence proves was
+// already punished.
+func (bc *Blockchain) HasEvidence(evidence *DoubleSignEvidence) bool {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	_, ok := bc.stakingState.offenses[offense{validator: evidence.Offender().Address(), height: evidence.Height()}]
+	return ok
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// doubleSign returns two different blocks at the given height signed by the
+// given key.
+func doubleSign(t *testing.T, privKey crypto.PrivateKey, height uint32) (*Block, *Block) {
+	a := randomBlock(t, height, types.Hash{1})
+	b := randomBlock(t, height, types.Hash{2})
+	assert.Nil(t, a.Sign(privKey))
+	assert.Nil(t, b.Sign(privKey))
+
+	return a, b
+}
+
+func TestDoubleSignEvidence(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	a, b := doubleSign(t, privKey, 1)
+
+	evidence, err := NewDoubleSignEvidence(a, b)
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.PublicKey(), evidence.Offender())
+
+	// The order of the headers does not matter.
+	swapped, err := NewDoubleSignEvidence(b, a)
+	assert.Nil(t, err)
+	assert.Equal(t, evidence.Hash(), swapped.Hash())
+
+	_, err = NewDoubleSignEvidence(a, a)
+	assert.ErrorIs(t, err, ErrInvalidEvidence)
+
+	other := randomBlock(t, 1, types.Hash{3})
+	_, err = NewDoubleSignEvidence(a, other)
+	assert.ErrorIs(t, err, ErrInvalidEvidence)
+
+	higher := randomBlock(t, 2, types.Hash{3})
+	assert.Nil(t, higher.Sign(privKey))
+	_, err = NewDoubleSignEvidence(a, higher)
+	assert.ErrorIs(t, err, ErrInvalidEvidence)
+
+	// A signature that was moved to another header does not count.
+	b.Signature = a.Signature
+	_, err = NewDoubleSignEvidence(a, b)
+	assert.ErrorIs(t, err, ErrInvalidEvidence)
+}
+
+func TestSlashDoubleSign(t *testing.T) {
+	var (
+		offender = crypto.GeneratePrivateKey()
+		honest   = crypto.GeneratePrivateKey()
+		bob      = crypto.GeneratePrivateKey()
+		store    = NewMemoryStore()
+	)
+
+	opts := BlockchainOpts{
+		Store: store,
+		Genesis: Genesis{
+			Alloc: map[types.Address]uint64{
+				offender.PublicKey().Address(): 1000,
+				bob.PublicKey().Address():      1000,
+			},
+			Validators: []crypto.PublicKey{offender.PublicKey(), honest.PublicKey()},
+			Staking: StakingParams{
+				SlashPercent: 50,
+				JailPeriod:   3,
+			},
+		},
+	}
+	genesis := randomBlock(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, opts)
+	assert.Nil(t, err)
+
+	stake := stakingTransaction(t, offender, 0, StakeTransaction{Amount: 100})
+	delegate := stakingTransaction(t, bob, 0, DelegateTransaction{Validator: offender.PublicKey(), Amount: 40})
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, stake, delegate)))
+
+	a, b := doubleSign(t, offender, 1)
+	evidence, err := NewDoubleSignEvidence(a, b)
+	assert.Nil(t, err)
+	report := stakingTransaction(t, bob, 1, *evidence)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, report)))
+	assertReceipt(t, bc, report, ReceiptStatusSuccess)
+
+	// Half of the stake of the offender and its delegators is burned.
+	staked, err := bc.GetStake(offender.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(70), staked)
+	assert.Equal(t, uint64(20), bc.GetBond(bob.PublicKey().Address(), offender.PublicKey().Address()))
+	assert.Equal(t, uint64(1930), bc.TotalSupply().Uint64())
+
+	// The offender is out of the proposer schedule right away.
+	assert.True(t, bc.Jailed(offender.PublicKey().Address()))
+	assert.True(t, bc.HasEvidence(evidence))
+	assert.Equal(t, []crypto.PublicKey{honest.PublicKey()}, bc.Validators())
+	assert.Equal(t, honest.PublicKey(), bc.Proposer(3, 0))
+
+	// The same double sign is only punished once and the offender can not
+	// stake while jailed.
+	swapped, err := NewDoubleSignEvidence(b, a)
+	assert.Nil(t, err)
+	again := stakingTransaction(t, bob, 2, *swapped)
+	restake := stakingTransaction(t, offender, 1, StakeTransaction{Amount: 100})
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, again, restake)))
+	assertReceipt(t, bc, again, ReceiptStatusFailed)
+	assertReceipt(t, bc, restake, ReceiptStatusFailed)
+	assert.Equal(t, uint64(1930), bc.TotalSupply().Uint64())
+
+	// Evidence against someone who is not a validator is rejected.
+	x, y := doubleSign(t, crypto.GeneratePrivateKey(), 1)
+	outsider, err := NewDoubleSignEvidence(x, y)
+	assert.Nil(t, err)
+	invalid := stakingTransaction(t, bob, 3, *outsider)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, invalid)))
+	assertReceipt(t, bc, invalid, ReceiptStatusFailed)
+
+	// The jail time ends with block 5.
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	assert.False(t, bc.Jailed(offender.PublicKey().Address()))
+	restake = stakingTransaction(t, offender, 2, StakeTransaction{Amount: 100})
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, restake)))
+	assertReceipt(t, bc, restake, ReceiptStatusSuccess)
+	staked, err = bc.GetStake(offender.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(170), staked)
+	assertStateRoot(t, bc)
+
+	replayed, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.StateRoot(), replayed.StateRoot())
+	assert.True(t, replayed.HasEvidence(evidence))
+}
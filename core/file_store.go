@@ -0,0 +1,477 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+const (
+	segmentExt = ".seg"
+	// length(4) + crc(4) + height(4) + hash(32)
+	recordHeaderSize = 44
+
+	defaultSegmentSize int64 = 64 << 20
+)
+
+var (
+	ErrCorruptSegment = errors.New("corrupt block segment")
+	ErrStoreClosed    = errors.New("block store is closed")
+)
+
+// SyncPolicy controls how often the FileStore fsyncs the active segment.
+type SyncPolicy byte
+
+const (
+	// SyncAlways fsyncs after every Put. This is the safest option and
+	// the default.
+	SyncAlways SyncPolicy = iota
+	// SyncBatch fsyncs after every FileStoreOpts.SyncEvery puts.
+	SyncBatch
+	// SyncNever leaves flushing to the operating system.
+	SyncNever
+)
+
+type FileStoreOpts struct {
+	// SegmentSize is the size in bytes after which a new segment file is
+	// started. Defaults to 64MB.
+	SegmentSize int64
+	Sync        SyncPolicy
+	// SyncEvery is only used with SyncBatch.
+	SyncEvery int
+}
+
+type blockLocation struct {
+	segment uint32
+	offset  int64
+	size    uint32
+	height  uint32
+}
+
+// FileStore is a Storage that appends blocks to segment files on disk.
+//
+// Every record in a segment looks like this:
+//
+//	[length uint32][crc32 uint32][height uint32][hash 32 bytes][gob encoded block]
+//
+// The crc covers height, hash and the encoded block. The height/hash index
+// is rebuilt from the record headers when the store is opened, any torn
+// record at the tail of the last segment (a crash in the middle of a write)
+// is truncated away.
+type FileStore struct {
+	FileStoreOpts
+
+	lock     sync.RWMutex
+	dir      string
+	index    map[types.Hash]blockLocation
+	order    []types.Hash
+	heights  map[uint32][]types.Hash
+	readers  map[uint32]*os.File
+	active   *os.File
+	activeID uint32
+	size     int64
+	unsynced int
+}
+
+func NewFileStore(dir string, opts FileStoreOpts) (*FileStore, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if opts.Sync == SyncBatch && opts.SyncEvery <= 0 {
+		opts.SyncEvery = 100
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &FileStore{
+		FileStoreOpts: opts,
+		dir:           dir,
+		index:         make(map[types.Hash]blockLocation),
+		order:         []types.Hash{},
+		heights:       make(map[uint32][]types.Hash),
+		readers:       make(map[uint32]*os.File),
+	}
+
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) load() error {
+	ids, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		last := i == len(ids)-1
+
+		good, err := s.scanSegment(id)
+		if err == nil {
+			continue
+		}
+		if !last || !errors.Is(err, ErrCorruptSegment) {
+			return fmt.Errorf("segment %d: %w", id, err)
+		}
+
+		// A torn write can only happen at the tail of the last segment.
+		if err := s.truncateSegment(id, good); err != nil {
+			return err
+		}
+	}
+
+	if len(ids) == 0 {
+		return s.openActive(0)
+	}
+
+	return s.openActive(ids[len(ids)-1])
+}
+
+// scanSegment adds every valid record of the given segment to the index and
+// returns the offset directly after the last valid record.
+func (s *FileStore) scanSegment(id uint32) (int64, error) {
+	f, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		offset int64
+		header = make([]byte, recordHeaderSize)
+	)
+
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, ErrCorruptSegment
+		}
+
+		size := binary.LittleEndian.Uint32(header[0:4])
+		checksum := binary.LittleEndian.Uint32(header[4:8])
+		height := binary.LittleEndian.Uint32(header[8:12])
+		hash := types.HashFromBytes(header[12:44])
+
+		// A torn length could ask for far more than the segment holds,
+		// it is not trusted with an allocation.
+		if int64(size) > info.Size()-offset-recordHeaderSize {
+			return offset, ErrCorruptSegment
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(f, payload); err != nil {
+			return offset, ErrCorruptSegment
+		}
+
+		crc := crc32.NewIEEE()
+		crc.Write(header[8:])
+		crc.Write(payload)
+		if crc.Sum32() != checksum {
+			return offset, ErrCorruptSegment
+		}
+
+		s.addToIndex(hash, blockLocation{
+			segment: id,
+			offset:  offset,
+			size:    size,
+			height:  height,
+		})
+
+		offset += recordHeaderSize + int64(size)
+	}
+}
+
+func (s *FileStore) truncateSegment(id uint32, size int64) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+func (s *FileStore) openActive(id uint32) error {
+	f, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := syncDir(s.dir); err != nil {
+		f.Close()
+		return err
+	}
+
+	s.active = f
+	s.activeID = id
+	s.size = info.Size()
+
+	return nil
+}
+
+func (s *FileStore) segments() ([]uint32, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []uint32{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+func (s *FileStore) segmentPath(id uint32) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%08d%s", id, segmentExt))
+}
+
+func (s *FileStore) addToIndex(hash types.Hash, loc blockLocation) {
+	s.index[hash] = loc
+	s.order = append(s.order, hash)
+	s.heights[loc.height] = append(s.heights[loc.height], hash)
+}
+
+func (s *FileStore) Put(b *Block) error {
+	hash := b.Hash(BlockHasher{})
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.index[hash]; ok {
+		return nil
+	}
+	if s.active == nil {
+		return ErrStoreClosed
+	}
+
+	buf := &bytes.Buffer{}
+	if err := b.Encode(NewGobBlockEncoder(buf)); err != nil {
+		return err
+	}
+	payload := buf.Bytes()
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[8:12], b.Height)
+	copy(record[12:44], hash.ToSlice())
+	copy(record[44:], payload)
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(record[8:]))
+
+	if s.size > 0 && s.size+int64(len(record)) > s.SegmentSize {
+		if err := s.roll(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.active.Write(record); err != nil {
+		return s.rewind(err)
+	}
+
+	if err := s.maybeSync(); err != nil {
+		return s.rewind(err)
+	}
+
+	s.addToIndex(hash, blockLocation{
+		segment: s.activeID,
+		offset:  s.size,
+		size:    uint32(len(payload)),
+		height:  b.Height,
+	})
+	s.size += int64(len(record))
+
+	return nil
+}
+
+// rewind truncates the active segment back to the end of the last record
+// after a failed write, a partial record would shift the offsets of all the
+// records that follow it.
+func (s *FileStore) rewind(err error) error {
+	if terr := s.active.Truncate(s.size); terr != nil {
+		return fmt.Errorf("%w, truncating the segment failed: %s", err, terr)
+	}
+
+	return err
+}
+
+func (s *FileStore) roll() error {
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+	s.unsynced = 0
+
+	return s.openActive(s.activeID + 1)
+}
+
+func (s *FileStore) maybeSync() error {
+	s.unsynced++
+
+	switch s.Sync {
+	case SyncAlways:
+	case SyncBatch:
+		if s.unsynced < s.SyncEvery {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	s.unsynced = 0
+	return s.active.Sync()
+}
+
+func (s *FileStore) Get(hash types.Hash) (*Block, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	loc, ok := s.index[hash]
+	if !ok {
+		return nil, fmt.Errorf("block with hash (%s) not found in store", hash)
+	}
+
+	return s.read(loc)
+}
+
+func (s *FileStore) read(loc blockLocation) (*Block, error) {
+	f, ok := s.readers[loc.segment]
+	if !ok {
+		var err error
+		f, err = os.Open(s.segmentPath(loc.segment))
+		if err != nil {
+			return nil, err
+		}
+		s.readers[loc.segment] = f
+	}
+
+	payload := make([]byte, loc.size)
+	if _, err := f.ReadAt(payload, loc.offset+recordHeaderSize); err != nil {
+		return nil, err
+	}
+
+	b := new(Block)
+	if err := b.Decode(NewGobBlockDecoder(bytes.NewReader(payload))); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (s *FileStore) Has(hash types.Hash) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.index[hash]
+	return ok
+}
+
+// HashesAtHeight returns the hashes of all stored blocks with the given
+// height in the order they were put.
+func (s *FileStore) HashesAtHeight(height uint32) []types.Hash {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	hashes := make([]types.Hash, len(s.heights[height]))
+	copy(hashes, s.heights[height])
+
+	return hashes
+}
+
+func (s *FileStore) Iterate(fn func(*Block) error) error {
+	s.lock.RLock()
+	order := make([]types.Hash, len(s.order))
+	copy(order, s.order)
+	s.lock.RUnlock()
+
+	for _, hash := range order {
+		b, err := s.Get(hash)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for id, f := range s.readers {
+		f.Close()
+		delete(s.readers, id)
+	}
+
+	if s.active == nil {
+		return nil
+	}
+
+	err := s.active.Sync()
+	if cerr := s.active.Close(); err == nil {
+		err = cerr
+	}
+	s.active = nil
+
+	return err
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
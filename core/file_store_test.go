@@ -0,0 +1,183 @@
+package core
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStorePutGet(t *testing.T) {
+	s, err := NewFileStore(t.TempDir(), FileStoreOpts{})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	b := randomBlock(t, 0, types.Hash{})
+	assert.False(t, s.Has(b.Hash(BlockHasher{})))
+	assert.Nil(t, s.Put(b))
+	assert.True(t, s.Has(b.Hash(BlockHasher{})))
+
+	fetched, err := s.Get(b.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, b.Header, fetched.Header)
+	assert.Equal(t, b.Signature, fetched.Signature)
+	assert.Equal(t, []types.Hash{b.Hash(BlockHasher{})}, s.HashesAtHeight(0))
+
+	_, err = s.Get(types.Hash{})
+	assert.NotNil(t, err)
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir, FileStoreOpts{SegmentSize: 1024})
+	assert.Nil(t, err)
+
+	hashes := []types.Hash{}
+	for i := 0; i < 20; i++ {
+		b := randomBlock(t, uint32(i), types.Hash{})
+		assert.Nil(t, s.Put(b))
+		hashes = append(hashes, b.Hash(BlockHasher{}))
+	}
+	assert.Nil(t, s.Close())
+
+	segments, err := s.segments()
+	assert.Nil(t, err)
+	assert.Greater(t, len(segments), 1)
+
+	s, err = NewFileStore(dir, FileStoreOpts{SegmentSize: 1024})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	iterated := []types.Hash{}
+	assert.Nil(t, s.Iterate(func(b *Block) error {
+		iterated = append(iterated, b.Hash(BlockHasher{}))
+		return nil
+	}))
+	assert.Equal(t, hashes, iterated)
+}
+
+func TestFileStoreTornWriteRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir, FileStoreOpts{})
+	assert.Nil(t, err)
+
+	first := randomBlock(t, 0, types.Hash{})
+	second := randomBlock(t, 1, first.Hash(BlockHasher{}))
+	assert.Nil(t, s.Put(first))
+	assert.Nil(t, s.Put(second))
+	assert.Nil(t, s.Close())
+
+	// Simulate a crash in the middle of writing the second record.
+	path := s.segmentPath(0)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Truncate(path, info.Size()-10))
+
+	s, err = NewFileStore(dir, FileStoreOpts{})
+	assert.Nil(t, err)
+
+	assert.True(t, s.Has(first.Hash(BlockHasher{})))
+	assert.False(t, s.Has(second.Hash(BlockHasher{})))
+
+	// The store should be writable again after the recovery.
+	assert.Nil(t, s.Put(second))
+	assert.Nil(t, s.Close())
+
+	s, err = NewFileStore(dir, FileStoreOpts{})
+	assert.Nil(t, err)
+	defer s.Close()
+	assert.True(t, s.Has(second.Hash(BlockHasher{})))
+}
+
+func TestFileStoreTornLength(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir, FileStoreOpts{})
+	assert.Nil(t, err)
+
+	b := randomBlock(t, 0, types.Hash{})
+	assert.Nil(t, s.Put(b))
+	assert.Nil(t, s.Close())
+
+	// A record header whose length is far beyond the end of the segment.
+	header := make([]byte, recordHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], math.MaxUint32)
+	f, err := os.OpenFile(s.segmentPath(0), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write(header)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	s, err = NewFileStore(dir, FileStoreOpts{})
+	assert.Nil(t, err)
+	defer s.Close()
+	assert.True(t, s.Has(b.Hash(BlockHasher{})))
+
+	// The torn record is truncated away.
+	info, err := os.Stat(s.segmentPath(0))
+	assert.Nil(t, err)
+	assert.Equal(t, s.size, info.Size())
+	assert.Equal(t, int64(recordHeaderSize+s.index[b.Hash(BlockHasher{})].size), info.Size())
+}
+
+func TestFileStoreCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStore(dir, FileStoreOpts{Sync: SyncBatch})
+	assert.Nil(t, err)
+
+	b := randomBlock(t, 0, types.Hash{})
+	assert.Nil(t, s.Put(b))
+	assert.Nil(t, s.Close())
+
+	data, err := os.ReadFile(s.segmentPath(0))
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(s.segmentPath(0), data, 0644))
+
+	s, err = NewFileStore(dir, FileStoreOpts{})
+	assert.Nil(t, err)
+	defer s.Close()
+	assert.False(t, s.Has(b.Hash(BlockHasher{})))
+}
+
+func TestBlockchainLoadFromFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir, FileStoreOpts{})
+	assert.Nil(t, err)
+
+	genesis := randomBlock(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		block := nextBlock(t, bc)
+		assert.Nil(t, bc.AddBlock(block))
+	}
+	lastHeader, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	assert.Nil(t, bc.Close())
+
+	store, err = NewFileStore(dir, FileStoreOpts{})
+	assert.Nil(t, err)
+
+	bc, err = NewBlockchainWithOpts(log.NewNopLogger(), genesis, BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+	defer bc.Close()
+
+	assert.Equal(t, uint32(10), bc.Height())
+	header, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, lastHeader, header)
+
+	block, err := bc.GetBlockByHash(genesis.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), block.Height)
+
+	for _, transaction := range block.Transactions {
+		_, err := bc.GetTransactionByHash(transaction.Hash(TransactionHasher{}))
+		assert.Nil(t, err)
+	}
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"math/big"
+)
+
+// ForkChoice decides which branch of the block tree is the canonical chain.
+// Every block adds its weight to the branch it is part of and the branch with
+// the highest total weight wins. On a tie the branch that was seen first is
+// kept.
+type ForkChoice interface {
+	Weight(*Header) *big.Int
+}
+
+// LongestChain gives every block the same weight, so the branch with the
+// most blocks wins.
+type LongestChain struct{}
+
+func (LongestChain) Weight(*Header) *big.Int {
+	return big.NewInt(1)
+}
+
+// HeaviestChain weighs every block with the given function, for example by
+// the amount of work that went into it.
+type HeaviestChain struct {
+	WeightFunc func(*Header) *big.Int
+}
+
+func (c HeaviestChain) Weight(h *Header) *big.Int {
+	return c.WeightFunc(h)
+}
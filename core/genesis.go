@@ -0,0 +1,220 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrGenesisMismatch = errors.New("stored genesis block does not match the genesis specification")
+
+// Genesis describes the state of the chain before the first block. Every
+// node that uses the same specification derives the same genesis block.
+type Genesis struct {
+	ChainID uint64
+	// Timestamp is the timestamp of the genesis block.
+	Timestamp int64
+	// Alloc lists the initial balance of every account that is funded at
+	// genesis. Besides the block reward it is the only way coins come into
+	// existence.
+	Alloc map[types.Address]uint64
+	// Validators is the initial set of validators, in order.
+	Validators []crypto.PublicKey
+	// Storage is the initial contract storage. The VM can only load values
+	// that are serialized the way it stores them.
+	Storage map[string][]byte
+	// Difficulty is the proof of work difficulty of the first blocks.
+	Difficulty uint64
+	// Staking controls how the validator set follows the stake once the
+	// chain is running.
+	Staking StakingParams
+	// Consensus limits the size of blocks.
+	Consensus ConsensusParams
+}
+
+// genesisFile is the encoding of a Genesis on disk. Addresses, keys and
+// storage are hex encoded.
+type genesisFile struct {
+	ChainID    uint64            `json:"chainId" yaml:"chainId"`
+	Timestamp  int64             `json:"timestamp" yaml:"timestamp"`
+	Alloc      map[string]uint64 `json:"alloc" yaml:"alloc"`
+	Validators []string          `json:"validators" yaml:"validators"`
+	Storage    map[string]string `json:"storage" yaml:"storage"`
+	Difficulty uint64            `json:"difficulty" yaml:"difficulty"`
+	Staking    StakingParams     `json:"staking" yaml:"staking"`
+	Consensus  ConsensusParams   `json:"consensus" yaml:"consensus"`
+}
+
+// LoadGenesis reads a genesis specification from a JSON or, when the file
+// has a .yaml or .yml extension, a YAML file.
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file := genesisFile{}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &file)
+	default:
+		err = json.Unmarshal(data, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode genesis file (%s): %w", path, err)
+	}
+
+	return file.genesis()
+}
+
+func (f genesisFile) genesis() (*Genesis, error) {
+	g := &Genesis{
+		ChainID:    f.ChainID,
+		Timestamp:  f.Timestamp,
+		Alloc:      make(map[types.Address]uint64, len(f.Alloc)),
+		Validators: make([]crypto.PublicKey, len(f.Validators)),
+		Storage:    make(map[string][]byte, len(f.Storage)),
+		Difficulty: f.Difficulty,
+		Staking:    f.Staking,
+		Consensus:  f.Consensus,
+	}
+
+	for address, balance := range f.Alloc {
+		b, err := hex.DecodeString(address)
+		if err != nil || len(b) != types.ADDRESS_MAX_LENGHT {
+			return nil, fmt.Errorf("invalid genesis alloc address (%s)", address)
+		}
+		g.Alloc[types.AddressFromBytes(b)] = balance
+	}
+
+	for i, validator := range f.Validators {
+		b, err := hex.DecodeString(validator)
+		if err != nil {
+			return nil, fmt.Errorf("invalid genesis validator (%s): %w", validator, err)
+		}
+		g.Validators[i] = crypto.PublicKey(b)
+	}
+
+	for key, value := range f.Storage {
+		k, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid genesis storage key (%s): %w", key, err)
+		}
+		v, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid genesis storage value (%s): %w", value, err)
+		}
+		g.Storage[string(k)] = v
+	}
+
+	return g, nil
+}
+
+// Block returns the genesis block. It has no transactions and no signature,
+// so its hash only depends on the specification.
+func (g *Genesis) Block() (*Block, error) {
+	bc := &Blockchain{
+		accountState:    NewAccountState(),
+		contractState:   NewState(),
+		collectionState: make(map[types.Hash]*CollectionTransaction),
+		mintState:       make(map[types.Hash]*MintTransaction),
+		stakingState:    NewStakingState(),
+		trie:            NewSparseMerkleTree(),
+	}
+	if err := g.apply(bc); err != nil {
+		return nil, err
+	}
+
+	header := &Header{
+		Version:    1,
+		ChainID:    g.ChainID,
+		Height:     0,
+		Timestamp:  g.Timestamp,
+		StateRoot:  bc.stateRoot(),
+		Difficulty: g.Difficulty,
+	}
+
+	return NewBlock(header, []*Transaction{})
+}
+
+// apply funds the genesis allocations, fills the contract storage and sets
+// the validators. The caller must hold the stateLock.
+func (g *Genesis) apply(bc *Blockchain) error {
+	for address, balance := range g.Alloc {
+		if err := bc.accountState.Credit(address, balance); err != nil {
+			return fmt.Errorf("failed to allocate (%d) to (%s): %w", balance, address, err)
+		}
+	}
+
+	keys := make([]string, 0, len(g.Storage))
+	for key := range g.Storage {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := bc.contractState.Put([]byte(key), g.Storage[key]); err != nil {
+			return err
+		}
+	}
+
+	validators := make([]crypto.PublicKey, len(g.Validators))
+	copy(validators, g.Validators)
+	bc.setValidators(validators)
+
+	return nil
+}
+
+// executeGenesis applies the genesis specification and executes the
+// genesis block. The genesis block is trusted as is, it is checked against
+// the expected genesis block by hash.
+func (bc *Blockchain) executeGenesis(b *Block) ([]*Receipt, error) {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	start := bc.journal.snapshot()
+	if err := bc.genesis.apply(bc); err != nil {
+		bc.journal.revertTo(start)
+		return nil, err
+	}
+
+	receipts, err := bc.executeBlockWithoutLock(b)
+	if err != nil {
+		bc.journal.revertTo(start)
+		return nil, err
+	}
+	bc.journal.reset()
+
+	return receipts, nil
+}
+
+// TotalSupply returns the sum of the balances of all accounts and the stake
+// that is locked, it equals the genesis allocations plus the block rewards
+// paid so far minus the stake that was slashed.
+func (bc *Blockchain) TotalSupply() *big.Int {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	supply := bc.accountState.TotalSupply()
+	return supply.Add(supply, bc.stakingState.Locked())
+}
+
+// Validators returns the current validator set.
+func (bc *Blockchain) Validators() []crypto.PublicKey {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	validators := make([]crypto.PublicKey, len(bc.validators))
+	copy(validators, bc.validators)
+
+	return validators
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadGenesis(t *testing.T) {
+	var (
+		dir       = t.TempDir()
+		validator = crypto.GeneratePrivateKey().PublicKey()
+		address   = validator.Address()
+		key       = hex.EncodeToString([]byte("FOO"))
+	)
+
+	json := fmt.Sprintf(`{
+	"chainId": 7,
+	"timestamp": 1700000000,
+	"alloc": {"%s": 1000},
+	"validators": ["%s"],
+	"storage": {"%s": "05"},
+	"staking": {"epochLength": 10, "unbondingPeriod": 20, "minStake": 100},
+	"consensus": {"maxBlockBytes": 4096, "maxTransactions": 50}
+}`, address, validator, key)
+
+	yaml := fmt.Sprintf(`chainId: 7
+timestamp: 1700000000
+alloc:
+  "%s": 1000
+validators:
+  - "%s"
+storage:
+  "%s": "05"
+staking:
+  epochLength: 10
+  unbondingPeriod: 20
+  minStake: 100
+consensus:
+  maxBlockBytes: 4096
+  maxTransactions: 50
+`, address, validator, key)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "genesis.json"), []byte(json), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "genesis.yaml"), []byte(yaml), 0644))
+
+	fromJSON, err := LoadGenesis(filepath.Join(dir, "genesis.json"))
+	assert.Nil(t, err)
+	fromYAML, err := LoadGenesis(filepath.Join(dir, "genesis.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, fromJSON, fromYAML)
+
+	assert.Equal(t, uint64(7), fromJSON.ChainID)
+	assert.Equal(t, uint64(1000), fromJSON.Alloc[address])
+	assert.Equal(t, []crypto.PublicKey{validator}, fromJSON.Validators)
+	assert.Equal(t, []byte{0x05}, fromJSON.Storage["FOO"])
+	assert.Equal(t, StakingParams{EpochLength: 10, UnbondingPeriod: 20, MinStake: 100}, fromJSON.Staking)
+	assert.Equal(t, ConsensusParams{MaxBlockBytes: 4096, MaxTransactions: 50}, fromJSON.Consensus)
+
+	// Every node derives the same genesis block.
+	a, err := fromJSON.Block()
+	assert.Nil(t, err)
+	b, err := fromYAML.Block()
+	assert.Nil(t, err)
+	assert.Equal(t, a.Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+	assert.Nil(t, a.Signature)
+
+	fromYAML.Alloc[address] = 1001
+	b, err = fromYAML.Block()
+	assert.Nil(t, err)
+	assert.NotEqual(t, a.Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"alloc": {"zz": 1}}`), 0644))
+	_, err = LoadGenesis(filepath.Join(dir, "broken.json"))
+	assert.NotNil(t, err)
+}
+
+func TestBlockchainFromGenesis(t *testing.T) {
+	validator := crypto.GeneratePrivateKey().PublicKey()
+	genesis := Genesis{
+		ChainID:    1,
+		Timestamp:  1700000000,
+		Alloc:      map[types.Address]uint64{validator.Address(): 500},
+		Validators: []crypto.PublicKey{validator},
+		Storage:    map[string][]byte{"FOO": {0x05}},
+	}
+
+	block, err := genesis.Block()
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), block, BlockchainOpts{Genesis: genesis})
+	assert.Nil(t, err)
+
+	assert.Equal(t, block.StateRoot, bc.StateRoot())
+	assert.Equal(t, []crypto.PublicKey{validator}, bc.Validators())
+
+	balance, err := bc.GetBalance(validator.Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(500), balance)
+
+	value, err := bc.contractState.Get([]byte("FOO"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x05}, value)
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 
+	"github.com/gabrielluizsf/go-web3/crypto"
 	"github.com/gabrielluizsf/go-web3/types"
 )
 
@@ -12,6 +13,27 @@ type Hasher[T any] interface {
 	Hash(T) types.Hash
 }
 
+// Signatures are made over a preimage that starts with a domain tag, so a
+// block signature can never be replayed as a transaction signature or the
+// other way around.
+var (
+	blockSigningDomain       = []byte("go-web3/block")
+	transactionSigningDomain = []byte("go-web3/transaction")
+	voteSigningDomain        = []byte("go-web3/vote")
+	proposalSigningDomain    = []byte("go-web3/proposal")
+)
+
+// signingHash returns the digest that is signed for the given domain, chain
+// and payload.
+func signingHash(domain []byte, chainID uint64, payload []byte) types.Hash {
+	buf := new(bytes.Buffer)
+	buf.Write(domain)
+	binary.Write(buf, binary.LittleEndian, chainID)
+	buf.Write(payload)
+
+	return types.Hash(sha256.Sum256(buf.Bytes()))
+}
+
 type BlockHasher struct{}
 
 func (BlockHasher) Hash(b *Header) types.Hash {
@@ -23,13 +45,68 @@ type TransactionHasher struct{}
 
 // Hash will hash the whole bytes of the transaction no exception.
 func (TransactionHasher) Hash(transaction *Transaction) types.Hash {
+	return types.Hash(sha256.Sum256(transactionBytes(transaction)))
+}
+
+// transactionBytes encodes every field of the transaction but its signature
+// in a fixed layout. The variable length fields are length prefixed, so bytes
+// can not be moved from one into the next without changing the hash.
+func transactionBytes(transaction *Transaction) []byte {
 	buf := new(bytes.Buffer)
 
-	binary.Write(buf, binary.LittleEndian, transaction.Data)
-	binary.Write(buf, binary.LittleEndian, transaction.To)
+	writeBytes(buf, transaction.Data)
+	writeBytes(buf, transaction.To)
 	binary.Write(buf, binary.LittleEndian, transaction.Value)
-	binary.Write(buf, binary.LittleEndian, transaction.From)
+	writeBytes(buf, transaction.From)
 	binary.Write(buf, binary.LittleEndian, transaction.Nonce)
+	binary.Write(buf, binary.LittleEndian, transaction.Fee)
+	binary.Write(buf, binary.LittleEndian, transaction.ChainID)
+	binary.Write(buf, binary.LittleEndian, transaction.GasLimit)
+	binary.Write(buf, binary.LittleEndian, transaction.GasPrice)
+	writeTransactionInner(buf, transaction.TransactionInner)
 
-	return types.Hash(sha256.Sum256(buf.Bytes()))
+	return buf.Bytes()
+}
+
+// writeTransactionInner makes sure the native part of a transaction is
+// covered by its hash, and with that by its signature and the data hash of
+// the block.
+func writeTransactionInner(buf *bytes.Buffer, inner any) {
+	switch t := inner.(type) {
+	case CollectionTransaction:
+		buf.WriteByte(byte(TransactionTypeCollection))
+		writeCollection(buf, &t)
+	case MintTransaction:
+		buf.WriteByte(byte(TransactionTypeMint))
+		writeMint(buf, &t)
+	case StakeTransaction:
+		buf.WriteByte(byte(TransactionTypeStake))
+		binary.Write(buf, binary.LittleEndian, t.Amount)
+	case DelegateTransaction:
+		buf.WriteByte(byte(TransactionTypeDelegate))
+		writeBytes(buf, t.Validator)
+		binary.Write(buf, binary.LittleEndian, t.Amount)
+	case UnstakeTransaction:
+		buf.WriteByte(byte(TransactionTypeUnstake))
+		writeBytes(buf, t.Validator)
+		binary.Write(buf, binary.LittleEndian, t.Amount)
+	case DoubleSignEvidence:
+		buf.WriteByte(byte(TransactionTypeEvidence))
+		writeSignedHeader(buf, t.A)
+		writeSignedHeader(buf, t.B)
+	}
+}
+
+func writeSignedHeader(buf *bytes.Buffer, h SignedHeader) {
+	if h.Header != nil {
+		writeBytes(buf, h.Header.Bytes())
+	} else {
+		writeBytes(buf, nil)
+	}
+	writeBytes(buf, h.Validator)
+	if h.Signature != nil {
+		writeSignature(buf, *h.Signature)
+	} else {
+		writeSignature(buf, crypto.Signature{})
+	}
 }
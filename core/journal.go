@@ -0,0 +1,289 @@
+package core
+
+import (
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+// journalEntry is a single state change that knows how to undo itself.
+type journalEntry interface {
+	revert()
+	// refs returns the entries of the state the change touches.
+	refs() []stateRef
+}
+
+// journal records every state change made while executing blocks, so the
+// changes of a single transaction or a whole block can be rolled back. The
+// states hold a journal they append to; a nil journal records nothing.
+type journal struct {
+	entries []journalEntry
+	// touched holds the entries of the state that were changed or reverted
+	// since the state trie was last brought up to date.
+	touched map[stateRef]struct{}
+}
+
+func newJournal() *journal {
+	return &journal{
+		entries: []journalEntry{},
+		touched: make(map[stateRef]struct{}),
+	}
+}
+
+func (j *journal) append(entry journalEntry) {
+	if j == nil {
+		return
+	}
+	j.entries = append(j.entries, entry)
+	j.touch(entry)
+}
+
+func (j *journal) touch(entry journalEntry) {
+	for _, ref := range entry.refs() {
+		j.touched[ref] = struct{}{}
+	}
+}
+
+// takeTouched returns the touched entries of the state and forgets them.
+func (j *journal) takeTouched() map[stateRef]struct{} {
+	if j == nil {
+		return nil
+	}
+
+	touched := j.touched
+	j.touched = make(map[stateRef]struct{})
+
+	return touched
+}
+
+// snapshot returns an identifier for the current point in the journal that
+// can be passed to revertTo.
+func (j *journal) snapshot() int {
+	return len(j.entries)
+}
+
+// revertTo undoes all changes recorded after the given snapshot, newest
+// first.
+func (j *journal) revertTo(id int) {
+	for i := len(j.entries) - 1; i >= id; i-- {
+		j.entries[i].revert()
+		j.touch(j.entries[i])
+	}
+	j.entries = j.entries[:id]
+}
+
+// reset forgets all recorded changes, making them permanent.
+func (j *journal) reset() {
+	j.entries = []journalEntry{}
+}
+
+// take returns the changes recorded after the given snapshot so they can
+// be undone later with revertEntries, and resets the journal.
+func (j *journal) take(id int) []journalEntry {
+	entries := make([]journalEntry, len(j.entries)-id)
+	copy(entries, j.entries[id:])
+	j.reset()
+
+	return entries
+}
+
+// revertEntries undoes changes returned by take, newest first.
+func (j *journal) revertEntries(entries []journalEntry) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i].revert()
+		j.touch(entries[i])
+	}
+}
+
+type accountChange struct {
+	state   *AccountState
+	address types.Address
+	// prev is nil when the account did not exist before the change.
+	prev *Account
+}
+
+func (c accountChange) revert() {
+	c.state.mu.Lock()
+	defer c.state.mu.Unlock()
+
+	if c.prev == nil {
+		delete(c.state.accounts, c.address)
+		return
+	}
+
+	// Restore in place so pointers handed out by GetAccount stay valid.
+	if account, ok := c.state.accounts[c.address]; ok {
+		*account = *c.prev
+		return
+	}
+
+	prev := *c.prev
+	c.state.accounts[c.address] = &prev
+}
+
+func (c accountChange) refs() []stateRef {
+	return []stateRef{newStateRef(stateNamespaceAccount, c.address[:])}
+}
+
+type storageChange struct {
+	state   *State
+	key     string
+	prev    []byte
+	existed bool
+}
+
+func (c storageChange) revert() {
+	if !c.existed {
+		delete(c.state.data, c.key)
+		return
+	}
+
+	c.state.data[c.key] = c.prev
+}
+
+func (c storageChange) refs() []stateRef {
+	return []stateRef{newStateRef(stateNamespaceContract, []byte(c.key))}
+}
+
+type collectionChange struct {
+	bc   *Blockchain
+	hash types.Hash
+	// prev is nil when the collection did not exist before the change.
+	prev *CollectionTransaction
+}
+
+func (c collectionChange) revert() {
+	if c.prev == nil {
+		delete(c.bc.collectionState, c.hash)
+		return
+	}
+
+	c.bc.collectionState[c.hash] = c.prev
+}
+
+func (c collectionChange) refs() []stateRef {
+	return []stateRef{newStateRef(stateNamespaceCollection, c.hash[:])}
+}
+
+type mintChange struct {
+	bc   *Blockchain
+	hash types.Hash
+	// prev is nil when the mint did not exist before the change.
+	prev *MintTransaction
+}
+
+func (c mintChange) revert() {
+	if c.prev == nil {
+		delete(c.bc.mintState, c.hash)
+		return
+	}
+
+	c.bc.mintState[c.hash] = c.prev
+}
+
+func (c mintChange) refs() []stateRef {
+	return []stateRef{newStateRef(stateNamespaceMint, c.hash[:])}
+}
+
+type bondChange struct {
+	state   *StakingState
+	key     bondKey
+	prev    uint64
+	existed bool
+}
+
+func (c bondChange) revert() {
+	if !c.existed {
+		delete(c.state.bonds, c.key)
+		return
+	}
+
+	c.state.bonds[c.key] = c.prev
+}
+
+func (c bondChange) refs() []stateRef {
+	return []stateRef{newStateRef(stateNamespaceBond, bondID(c.key))}
+}
+
+type candidateChange struct {
+	state   *StakingState
+	address types.Address
+	prev    crypto.PublicKey
+	existed bool
+}
+
+func (c candidateChange) revert() {
+	if !c.existed {
+		delete(c.state.candidates, c.address)
+		return
+	}
+
+	c.state.candidates[c.address] = c.prev
+}
+
+func (c candidateChange) refs() []stateRef {
+	return []stateRef{newStateRef(stateNamespaceCandidate, c.address[:])}
+}
+
+type unbondingChange struct {
+	state *StakingState
+	prev  []Unbonding
+	// next is the number of unbondings after the change.
+	next int
+}
+
+func (c unbondingChange) revert() {
+	c.state.unbondings = c.prev
+}
+
+func (c unbondingChange) refs() []stateRef {
+	return indexRefs(stateNamespaceUnbonding, max(len(c.prev), c.next))
+}
+
+type jailChange struct {
+	state     *StakingState
+	validator types.Address
+	prev      uint32
+	existed   bool
+}
+
+func (c jailChange) revert() {
+	if !c.existed {
+		delete(c.state.jailed, c.validator)
+		return
+	}
+
+	c.state.jailed[c.validator] = c.prev
+}
+
+func (c jailChange) refs() []stateRef {
+	return []stateRef{newStateRef(stateNamespaceJail, c.validator[:])}
+}
+
+// offenseChange records a new offense, offenses are never removed.
+type offenseChange struct {
+	state   *StakingState
+	offense offense
+}
+
+func (c offenseChange) revert() {
+	delete(c.state.offenses, c.offense)
+}
+
+func (c offenseChange) refs() []stateRef {
+	return []stateRef{newStateRef(stateNamespaceOffense, offenseID(c.offense))}
+}
+
+type validatorSetChange struct {
+	bc   *Blockchain
+	prev []crypto.PublicKey
+	// next is the number of validators after the change.
+	next int
+}
+
+func (c validatorSetChange) revert() {
+	c.bc.validators = c.prev
+}
+
+func (c validatorSetChange) refs() []stateRef {
+	return indexRefs(stateNamespaceValidator, max(len(c.prev), c.next))
+}
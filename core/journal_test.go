@@ -0,0 +1,57 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournalRevertAccounts(t *testing.T) {
+	j := newJournal()
+	state := NewAccountState()
+	state.journal = j
+
+	addressBob := crypto.GeneratePrivateKey().PublicKey().Address()
+	addressAlice := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	accountBob := state.CreateAccount(addressBob)
+	accountBob.Balance = 100
+	j.reset()
+
+	id := j.snapshot()
+	assert.Nil(t, state.Transfer(addressBob, addressAlice, 40))
+	assert.Equal(t, uint64(60), accountBob.Balance)
+
+	j.revertTo(id)
+	assert.Equal(t, uint64(100), accountBob.Balance)
+
+	_, err := state.GetAccount(addressAlice)
+	assert.Equal(t, ErrAccountNotFound, err)
+}
+
+func TestJournalRevertStorage(t *testing.T) {
+	j := newJournal()
+	state := NewState()
+	state.journal = j
+
+	assert.Nil(t, state.Put([]byte("foo"), []byte("bar")))
+	id := j.snapshot()
+
+	assert.Nil(t, state.Put([]byte("foo"), []byte("baz")))
+	assert.Nil(t, state.Put([]byte("new"), []byte("value")))
+	assert.Nil(t, state.Delete([]byte("foo")))
+
+	j.revertTo(id)
+
+	value, err := state.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+
+	_, err = state.Get([]byte("new"))
+	assert.NotNil(t, err)
+
+	j.revertTo(0)
+	_, err = state.Get([]byte("foo"))
+	assert.NotNil(t, err)
+}
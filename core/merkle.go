@@ -0,0 +1,143 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+const (
+	merkleLeafPrefix byte = 0x00
+	merkleNodePrefix byte = 0x01
+)
+
+// MerkleRoot returns the root of a binary Merkle tree over the given leaves.
+//
+// Leaves and inner nodes are hashed with a different prefix so a leaf can
+// never be passed off as a node. When a level has an odd number of nodes
+// the last one is promoted to the next level as is instead of being
+// duplicated, that way two different lists never share the same root. The
+// root of an empty list is the zero hash.
+func MerkleRoot(leaves []types.Hash) types.Hash {
+	if len(leaves) == 0 {
+		return types.Hash{}
+	}
+
+	level := make([]types.Hash, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+
+	return level[0]
+}
+
+func nextMerkleLevel(level []types.Hash) []types.Hash {
+	next := make([]types.Hash, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleNodeHash(level[i], level[i+1]))
+	}
+
+	return next
+}
+
+// MerkleProof proves that a leaf is part of a Merkle tree with a known root.
+type MerkleProof struct {
+	// Index is the position of the leaf in the list.
+	Index uint32
+	// Leaves is the total number of leaves in the tree.
+	Leaves uint32
+	// Siblings are the hashes needed to rebuild the root, ordered from the
+	// bottom of the tree to the top.
+	Siblings []types.Hash
+}
+
+func NewMerkleProof(leaves []types.Hash, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf index (%d) out of range, the tree has (%d) leaves", index, len(leaves))
+	}
+
+	proof := &MerkleProof{
+		Index:    uint32(index),
+		Leaves:   uint32(len(leaves)),
+		Siblings: []types.Hash{},
+	}
+
+	level := make([]types.Hash, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+
+	for len(level) > 1 {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Siblings = append(proof.Siblings, level[sibling])
+		}
+
+		level = nextMerkleLevel(level)
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// Verify reports whether leaf is part of the tree with the given root.
+func (p *MerkleProof) Verify(root, leaf types.Hash) bool {
+	if p.Leaves == 0 || p.Index >= p.Leaves {
+		return false
+	}
+
+	var (
+		hash     = merkleLeafHash(leaf)
+		index    = p.Index
+		count    = p.Leaves
+		siblings = p.Siblings
+	)
+
+	for count > 1 {
+		switch {
+		case index%2 == 1:
+			if len(siblings) == 0 {
+				return false
+			}
+			hash = merkleNodeHash(siblings[0], hash)
+			siblings = siblings[1:]
+		case index+1 < count:
+			if len(siblings) == 0 {
+				return false
+			}
+			hash = merkleNodeHash(hash, siblings[0])
+			siblings = siblings[1:]
+		}
+
+		index /= 2
+		count = (count + 1) / 2
+	}
+
+	return len(siblings) == 0 && hash == root
+}
+
+func merkleLeafHash(leaf types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+types.HASH_LENGHT)
+	buf = append(buf, merkleLeafPrefix)
+	buf = append(buf, leaf[:]...)
+
+	return sha256.Sum256(buf)
+}
+
+func merkleNodeHash(left, right types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+2*types.HASH_LENGHT)
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+
+	return sha256.Sum256(buf)
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func merkleLeaves(n int) []types.Hash {
+	leaves := make([]types.Hash, n)
+	for i := 0; i < n; i++ {
+		leaves[i] = sha256.Sum256([]byte{byte(i)})
+	}
+	return leaves
+}
+
+func TestMerkleRootEmpty(t *testing.T) {
+	assert.Equal(t, types.Hash{}, MerkleRoot(nil))
+}
+
+func TestMerkleRootSingleLeaf(t *testing.T) {
+	leaves := merkleLeaves(1)
+	assert.Equal(t, merkleLeafHash(leaves[0]), MerkleRoot(leaves))
+}
+
+func TestMerkleRootOddLeavesNotDuplicated(t *testing.T) {
+	leaves := merkleLeaves(3)
+	duplicated := append(merkleLeaves(3), leaves[2])
+
+	assert.NotEqual(t, MerkleRoot(leaves), MerkleRoot(duplicated))
+}
+
+func TestMerkleProofAllIndexes(t *testing.T) {
+	for n := 1; n <= 17; n++ {
+		leaves := merkleLeaves(n)
+		root := MerkleRoot(leaves)
+
+		for i := 0; i < n; i++ {
+			proof, err := NewMerkleProof(leaves, i)
+			assert.Nil(t, err)
+			assert.True(t, proof.Verify(root, leaves[i]), "n=%d i=%d", n, i)
+
+			// The proof must not hold for any other leaf.
+			other := leaves[(i+1)%n]
+			if n > 1 {
+				assert.False(t, proof.Verify(root, other), "n=%d i=%d", n, i)
+			}
+		}
+	}
+}
+
+func TestMerkleProofTampered(t *testing.T) {
+	leaves := merkleLeaves(8)
+	root := MerkleRoot(leaves)
+
+	proof, err := NewMerkleProof(leaves, 5)
+	assert.Nil(t, err)
+
+	proof.Index = 4
+	assert.False(t, proof.Verify(root, leaves[5]))
+	proof.Index = 5
+
+	proof.Siblings[1] = types.Hash{}
+	assert.False(t, proof.Verify(root, leaves[5]))
+
+	proof, err = NewMerkleProof(leaves, 5)
+	assert.Nil(t, err)
+	proof.Siblings = proof.Siblings[:len(proof.Siblings)-1]
+	assert.False(t, proof.Verify(root, leaves[5]))
+
+	_, err = NewMerkleProof(leaves, 8)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	defaultMaxBlockBytes           = 1 << 20
+	defaultMaxTransactions         = 1000
+	defaultMaxTransactionDataBytes = 64 << 10
+	defaultMaxBlockGas             = 10_000_000
+)
+
+var (
+	ErrBlockTooLarge           = errors.New("block is too large")
+	ErrTooManyTransactions     = errors.New("block has too many transactions")
+	ErrTransactionTooLarge     = errors.New("transaction is too large")
+	ErrTransactionDataTooLarge = errors.New("transaction data is too large")
+	ErrBlockGasLimit           = errors.New("block gas limit exceeded")
+)
+
+// ConsensusParams limit the size and the gas of blocks, every node of a
+// chain has to use the same limits. Fields that are zero take their default.
+type ConsensusParams struct {
+	// MaxBlockBytes caps the total size of the transactions of a block.
+	MaxBlockBytes int `json:"maxBlockBytes" yaml:"maxBlockBytes"`
+	// MaxTransactions caps the number of transactions of a block.
+	MaxTransactions int `json:"maxTransactions" yaml:"maxTransactions"`
+	// MaxTransactionDataBytes caps the length of the data of a transaction.
+	MaxTransactionDataBytes int `json:"maxTransactionDataBytes" yaml:"maxTransactionDataBytes"`
+	// MaxBlockGas caps the sum of the gas limits of the transactions of a
+	// block.
+	MaxBlockGas uint64 `json:"maxBlockGas" yaml:"maxBlockGas"`
+}
+
+// WithDefaults returns the params with every unset field set to its default.
+func (p ConsensusParams) WithDefaults() ConsensusParams {
+	if p.MaxBlockBytes <= 0 {
+		p.MaxBlockBytes = defaultMaxBlockBytes
+	}
+	if p.MaxTransactions <= 0 {
+		p.MaxTransactions = defaultMaxTransactions
+	}
+	if p.MaxTransactionDataBytes <= 0 {
+		p.MaxTransactionDataBytes = defaultMaxTransactionDataBytes
+	}
+	if p.MaxBlockGas == 0 {
+		p.MaxBlockGas = defaultMaxBlockGas
+	}
+
+	return p
+}
+
+// ValidateTransaction checks that the transaction fits into a block.
+func (p ConsensusParams) ValidateTransaction(transaction *Transaction) error {
+	if n := len(transaction.Data); n > p.MaxTransactionDataBytes {
+		return fmt.Errorf("%w: transaction (%s) has (%d) bytes of data, the limit is (%d)", ErrTransactionDataTooLarge, transaction.Hash(TransactionHasher{}), n, p.MaxTransactionDataBytes)
+	}
+
+	if size := transaction.Size(); size > p.MaxBlockBytes {
+		return fmt.Errorf("%w: transaction (%s) has (%d) bytes, the limit is (%d)", ErrTransactionTooLarge, transaction.Hash(TransactionHasher{}), size, p.MaxBlockBytes)
+	}
+
+	if transaction.GasLimit > p.MaxBlockGas {
+		return fmt.Errorf("%w: transaction (%s) has gas limit (%d), the limit is (%d)", ErrBlockGasLimit, transaction.Hash(TransactionHasher{}), transaction.GasLimit, p.MaxBlockGas)
+	}
+
+	return nil
+}
+
+// ValidateTransactions checks that the transactions fit into a single block.
+func (p ConsensusParams) ValidateTransactions(transactions []*Transaction) error {
+	if n := len(transactions); n > p.MaxTransactions {
+		return fmt.Errorf("%w: (%d) transactions, the limit is (%d)", ErrTooManyTransactions, n, p.MaxTransactions)
+	}
+
+	var (
+		size int
+		gas  uint64
+	)
+	for _, transaction := range transactions {
+		if err := p.ValidateTransaction(transaction); err != nil {
+			return err
+		}
+		size += transaction.Size()
+		// Every gas limit is below MaxBlockGas, so the sum can not
+		// overflow before it is caught.
+		gas += transaction.GasLimit
+		if gas > p.MaxBlockGas {
+			return fmt.Errorf("%w: transactions have gas limit (%d), the limit is (%d)", ErrBlockGasLimit, gas, p.MaxBlockGas)
+		}
+	}
+
+	if size > p.MaxBlockBytes {
+		return fmt.Errorf("%w: transactions have (%d) bytes, the limit is (%d)", ErrBlockTooLarge, size, p.MaxBlockBytes)
+	}
+
+	return nil
+}
+
+// ConsensusParams returns the block limits of the chain.
+func (bc *Blockchain) ConsensusParams() ConsensusParams {
+	return bc.genesis.Consensus
+}
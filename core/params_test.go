@@ -0,0 +1,93 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsensusParamsDefaults(t *testing.T) {
+	params := ConsensusParams{MaxTransactions: 10}.WithDefaults()
+	assert.Equal(t, 10, params.MaxTransactions)
+	assert.Equal(t, defaultMaxBlockBytes, params.MaxBlockBytes)
+	assert.Equal(t, defaultMaxTransactionDataBytes, params.MaxTransactionDataBytes)
+
+	bc := newBlockchainWithGenesis(t)
+	assert.Equal(t, ConsensusParams{}.WithDefaults(), bc.ConsensusParams())
+}
+
+func TestConsensusParamsValidateTransactions(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	transaction := NewTransaction(make([]byte, 10))
+	assert.Nil(t, transaction.Sign(privKey))
+	size := transaction.Size()
+
+	params := ConsensusParams{
+		MaxBlockBytes:           2 * size,
+		MaxTransactions:         3,
+		MaxTransactionDataBytes: 10,
+	}
+	assert.Nil(t, params.ValidateTransaction(transaction))
+	assert.Nil(t, params.ValidateTransactions([]*Transaction{transaction, transaction}))
+
+	err := params.ValidateTransactions([]*Transaction{transaction, transaction, transaction})
+	assert.True(t, errors.Is(err, ErrBlockTooLarge))
+
+	params.MaxTransactions = 1
+	err = params.ValidateTransactions([]*Transaction{transaction, transaction})
+	assert.True(t, errors.Is(err, ErrTooManyTransactions))
+
+	large := NewTransaction(make([]byte, 11))
+	assert.Nil(t, large.Sign(privKey))
+	assert.True(t, errors.Is(params.ValidateTransaction(large), ErrTransactionDataTooLarge))
+
+	params.MaxTransactionDataBytes = 11
+	params.MaxBlockBytes = large.Size() - 1
+	assert.True(t, errors.Is(params.ValidateTransaction(large), ErrTransactionTooLarge))
+}
+
+func TestConsensusParamsBlockGas(t *testing.T) {
+	params := ConsensusParams{MaxBlockGas: 100}.WithDefaults()
+	privKey := crypto.GeneratePrivateKey()
+
+	transactions := make([]*Transaction, 3)
+	for i := range transactions {
+		transactions[i] = NewTransaction(nil)
+		transactions[i].GasLimit = 50
+		transactions[i].Nonce = uint64(i)
+		assert.Nil(t, transactions[i].Sign(privKey))
+	}
+
+	assert.Nil(t, params.ValidateTransactions(transactions[:2]))
+	assert.True(t, errors.Is(params.ValidateTransactions(transactions), ErrBlockGasLimit))
+
+	transactions[0].GasLimit = 101
+	assert.True(t, errors.Is(params.ValidateTransaction(transactions[0]), ErrBlockGasLimit))
+}
+
+func TestAddBlockTooManyTransactions(t *testing.T) {
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}), BlockchainOpts{
+		Genesis: Genesis{Consensus: ConsensusParams{MaxTransactions: 2}},
+	})
+	assert.Nil(t, err)
+
+	privKey := crypto.GeneratePrivateKey()
+	transactions := make([]*Transaction, 2)
+	for i := range transactions {
+		transactions[i] = NewTransaction(nil)
+		transactions[i].Nonce = uint64(i)
+		assert.Nil(t, transactions[i].Sign(privKey))
+	}
+
+	// The random block already holds a transaction.
+	err = bc.AddBlock(nextBlock(t, bc, transactions...))
+	assert.True(t, errors.Is(err, ErrTooManyTransactions))
+	assert.Equal(t, uint32(0), bc.Height())
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, transactions[0])))
+	assert.Equal(t, uint32(1), bc.Height())
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"errors"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+)
+
+var ErrWrongProposer = errors.New("block is not signed by the expected proposer")
+
+// Proposer returns the validator that may propose the block at the given
+// height and round. The validators take turns by height, when a proposer
+// misses its round the next validator in the set takes over. Returns nil
+// when the chain has no validators, in which case anyone may propose.
+func (bc *Blockchain) Proposer(height uint32, round uint64) crypto.PublicKey {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return proposer(bc.validators, height, round)
+}
+
+func proposer(validators []crypto.PublicKey, height uint32, round uint64) crypto.PublicKey {
+	if len(validators) == 0 {
+		return nil
+	}
+
+	return validators[(uint64(height)+round)%uint64(len(validators))]
+}
@@ -0,0 +1,50 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+type ReceiptStatus byte
+
+const (
+	ReceiptStatusFailed  ReceiptStatus = 0x0
+	ReceiptStatusSuccess ReceiptStatus = 0x1
+)
+
+func (s ReceiptStatus) String() string {
+	if s == ReceiptStatusSuccess {
+		return "success"
+	}
+	return "failed"
+}
+
+// Receipt records the outcome of executing a transaction. Failed
+// transactions stay in their block, none of their changes are applied.
+type Receipt struct {
+	TransactionHash types.Hash
+	Status          ReceiptStatus
+	// Error is the reason a failed transaction was reverted.
+	Error string
+	// Fee is what the sender paid to the validator of the block, including
+	// the gas that was used.
+	Fee uint64
+	// GasUsed is the gas the code of the transaction used.
+	GasUsed uint64
+}
+
+// GetReceipt returns the receipt of an executed transaction. Receipts are
+// kept in memory and rebuilt while replaying, blocks that were restored from
+// a state snapshot have none.
+func (bc *Blockchain) GetReceipt(hash types.Hash) (*Receipt, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	receipt, ok := bc.receipts[hash]
+	if !ok {
+		return nil, fmt.Errorf("could not find receipt for transaction (%s)", hash)
+	}
+
+	return receipt, nil
+}
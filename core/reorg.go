@@ -0,0 +1,212 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+// ReorgEvent describes a switch of the canonical chain to another branch.
+type ReorgEvent struct {
+	// CommonAncestor is the newest block both branches share.
+	CommonAncestor *Block
+	// Removed are the blocks that left the canonical chain, oldest first.
+	Removed []*Block
+	// Added are the blocks that joined the canonical chain, oldest first.
+	Added []*Block
+	// Evicted are the transactions of the removed blocks that are not part
+	// of the new branch, they have to be included in a block again.
+	Evicted []*Transaction
+}
+
+func newReorgEvent(ancestor *blockNode, removed, added []*blockNode) *ReorgEvent {
+	event := &ReorgEvent{
+		CommonAncestor: ancestor.block,
+		Removed:        make([]*Block, len(removed)),
+		Added:          make([]*Block, len(added)),
+		Evicted:        []*Transaction{},
+	}
+
+	included := make(map[types.Hash]bool)
+	for i, node := range added {
+		event.Added[i] = node.block
+		for _, transaction := range node.block.Transactions {
+			included[transaction.Hash(TransactionHasher{})] = true
+		}
+	}
+
+	for i, node := range removed {
+		event.Removed[i] = node.block
+		for _, transaction := range node.block.Transactions {
+			if !included[transaction.Hash(TransactionHasher{})] {
+				event.Evicted = append(event.Evicted, transaction)
+			}
+		}
+	}
+
+	return event
+}
+
+// SubscribeReorg registers fn to be called after every reorganization of
+// the chain. fn is called after the chain is unlocked again, so it is free
+// to query the chain.
+func (bc *Blockchain) SubscribeReorg(fn func(*ReorgEvent)) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.reorgSubscribers = append(bc.reorgSubscribers, fn)
+}
+
+func (bc *Blockchain) notifyReorg(event *ReorgEvent) {
+	bc.lock.RLock()
+	subscribers := make([]func(*ReorgEvent), len(bc.reorgSubscribers))
+	copy(subscribers, bc.reorgSubscribers)
+	bc.lock.RUnlock()
+
+	for _, fn := range subscribers {
+		fn(event)
+	}
+}
+
+// connectBlock makes a block that was just added to the tree part of the
+// canonical chain when the fork choice prefers its branch. The caller must
+// hold the insertLock.
+func (bc *Blockchain) connectBlock(node *blockNode) (*ReorgEvent, error) {
+	if node.invalid {
+		return nil, fmt.Errorf("block (%s) extends an invalid block", node.hash)
+	}
+
+	head := bc.currentHead()
+
+	if node.parent == head {
+		return nil, bc.extendHead(node)
+	}
+
+	// On a tie the branch that was seen first stays canonical.
+	if node.weight.Cmp(head.weight) <= 0 {
+		bc.logger.Log("msg", "added block to side branch", "hash", node.hash, "height", node.block.Height)
+		return nil, nil
+	}
+
+	return bc.reorg(head, node)
+}
+
+// extendHead executes the block on top of the canonical chain.
+func (bc *Blockchain) extendHead(node *blockNode) error {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	if err := bc.applyWithoutLock(node); err != nil {
+		node.invalid = true
+		return err
+	}
+
+	return nil
+}
+
+// reorg unwinds the canonical chain down to the common ancestor of the head
+// and the target and executes the branch of the target on top of it. When
+// any block of the new branch fails the old branch is restored.
+func (bc *Blockchain) reorg(head, target *blockNode) (*ReorgEvent, error) {
+	var (
+		ancestor = commonAncestor(head, target)
+		removed  = branch(ancestor, head)
+		added    = branch(ancestor, target)
+	)
+
+	for _, node := range added {
+		if node.invalid {
+			return nil, fmt.Errorf("branch of block (%s) includes the invalid block (%s)", target.hash, node.hash)
+		}
+	}
+
+	for _, node := range removed {
+		if node.undo == nil {
+			bc.logger.Log(
+				"msg", "not switching to heavier branch, reorg too deep",
+				"hash", target.hash,
+				"ancestor", ancestor.hash,
+				"depth", len(removed),
+			)
+			return nil, nil
+		}
+	}
+
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	for i := len(removed) - 1; i >= 0; i-- {
+		bc.unwindWithoutLock(removed[i])
+	}
+
+	for i, node := range added {
+		err := bc.verifySealWithoutLock(node)
+		if err == nil {
+			err = bc.applyWithoutLock(node)
+		}
+		if err == nil {
+			continue
+		}
+		node.invalid = true
+
+		for j := i - 1; j >= 0; j-- {
+			bc.unwindWithoutLock(added[j])
+		}
+		for _, old := range removed {
+			if err := bc.applyWithoutLock(old); err != nil {
+				return nil, fmt.Errorf("failed to restore block (%s) after a failed reorg: %w", old.hash, err)
+			}
+		}
+
+		return nil, fmt.Errorf("reorg to block (%s) failed at block (%s): %w", target.hash, node.hash, err)
+	}
+
+	bc.logger.Log(
+		"msg", "chain reorganized",
+		"ancestor", ancestor.hash,
+		"removed", len(removed),
+		"added", len(added),
+		"head", target.hash,
+		"height", target.block.Height,
+	)
+
+	return newReorgEvent(ancestor, removed, added), nil
+}
+
+// applyWithoutLock executes the block of the node on top of the head and
+// appends it to the canonical chain. The caller must hold the stateLock.
+func (bc *Blockchain) applyWithoutLock(node *blockNode) error {
+	receipts, err := bc.executeNodeWithoutLock(node)
+	if err != nil {
+		return err
+	}
+
+	bc.recordValidators(node)
+	bc.appendBlock(node, receipts)
+
+	return nil
+}
+
+// verifySealWithoutLock checks the seal of a side branch block against the
+// validator set of its branch, which was not known when the block was
+// added. The parent must have been executed. The caller must hold the
+// stateLock.
+func (bc *Blockchain) verifySealWithoutLock(node *blockNode) error {
+	parent := node.parent
+	if parent.validators == nil {
+		return fmt.Errorf("validator set of block (%s) is unknown", parent.hash)
+	}
+
+	chain := branchReader{Blockchain: bc, validators: parent.validators}
+
+	return bc.engine.VerifySeal(chain, node.block, parent.block.Header)
+}
+
+// unwindWithoutLock reverts the changes of the head block and removes it
+// from the canonical chain. The caller must hold the stateLock.
+func (bc *Blockchain) unwindWithoutLock(node *blockNode) {
+	bc.journal.revertEntries(node.undo)
+	node.undo = nil
+
+	bc.removeHead(node)
+}
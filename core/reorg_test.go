@@ -0,0 +1,210 @@
+package core
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// newForkedChains returns two chains that share the same genesis block, so
+// blocks built on one of them form a competing branch for the other.
+func newForkedChains(t *testing.T, opts BlockchainOpts) (*Blockchain, *Blockchain) {
+	genesis := randomBlock(t, 0, types.Hash{})
+
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, opts)
+	assert.Nil(t, err)
+
+	fork, err := NewBlockchain(log.NewNopLogger(), genesis)
+	assert.Nil(t, err)
+
+	return bc, fork
+}
+
+func genesisOf(t *testing.T, bc *Blockchain) *Block {
+	genesis, err := bc.GetBlock(0)
+	assert.Nil(t, err)
+
+	return genesis
+}
+
+func TestReorgToLongerBranch(t *testing.T) {
+	store := NewMemoryStore()
+	bc, fork := newForkedChains(t, BlockchainOpts{Store: store})
+
+	events := []*ReorgEvent{}
+	bc.SubscribeReorg(func(event *ReorgEvent) {
+		events = append(events, event)
+	})
+
+	// Stores the value 5 under the key "FOO".
+	data := []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
+	transaction := NewTransaction(data)
+	transaction.GasLimit = 100
+	assert.Nil(t, transaction.Sign(crypto.GeneratePrivateKey()))
+
+	a1 := nextBlock(t, bc, transaction)
+	assert.Nil(t, bc.AddBlock(a1))
+
+	b1 := nextBlock(t, fork)
+	assert.Nil(t, fork.AddBlock(b1))
+	b2 := nextBlock(t, fork)
+	assert.Nil(t, fork.AddBlock(b2))
+
+	// Same length, the branch that was seen first stays canonical.
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Equal(t, a1.Hash(BlockHasher{}), bc.currentHead().hash)
+	assert.Equal(t, 0, len(events))
+
+	block, err := bc.GetBlockByHash(b1.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, b1, block)
+
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, uint32(2), bc.Height())
+	assert.Equal(t, fork.StateRoot(), bc.StateRoot())
+	assertStateRoot(t, bc)
+
+	header, err := bc.GetHeader(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b1.Header, header)
+
+	_, err = bc.contractState.Get([]byte("FOO"))
+	assert.NotNil(t, err)
+
+	hash := transaction.Hash(TransactionHasher{})
+	_, err = bc.GetTransactionByHash(hash)
+	assert.NotNil(t, err)
+	_, err = bc.GetReceipt(hash)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, []*Block{a1}, events[0].Removed)
+	assert.Equal(t, []*Block{b1, b2}, events[0].Added)
+	assert.Equal(t, a1.Transactions, events[0].Evicted)
+
+	// Replaying the store takes the same decisions.
+	replayed, err := NewBlockchainWithOpts(log.NewNopLogger(), genesisOf(t, bc), BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+	assert.Equal(t, b2.Hash(BlockHasher{}), replayed.currentHead().hash)
+	assert.Equal(t, bc.StateRoot(), replayed.StateRoot())
+	assert.True(t, replayed.HasBlockHash(a1.Hash(BlockHasher{})))
+}
+
+func TestReorgInvalidBranch(t *testing.T) {
+	bc, fork := newForkedChains(t, BlockchainOpts{})
+
+	a1 := nextBlock(t, bc)
+	assert.Nil(t, bc.AddBlock(a1))
+	root := bc.StateRoot()
+
+	b1 := nextBlock(t, fork)
+	assert.Nil(t, fork.AddBlock(b1))
+	b2 := nextBlock(t, fork)
+	b2.StateRoot = types.Hash{}
+	assert.Nil(t, b2.Sign(crypto.GeneratePrivateKey()))
+
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.NotNil(t, bc.AddBlock(b2))
+
+	// The old branch is restored.
+	assert.Equal(t, a1.Hash(BlockHasher{}), bc.currentHead().hash)
+	assert.Equal(t, root, bc.StateRoot())
+
+	// Blocks built on top of the invalid block are rejected as well.
+	b3 := randomBlock(t, 3, b2.Hash(BlockHasher{}))
+	assert.NotNil(t, bc.AddBlock(b3))
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestReorgTooDeep(t *testing.T) {
+	bc, fork := newForkedChains(t, BlockchainOpts{MaxReorgDepth: 1})
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+	head := bc.currentHead().hash
+
+	for i := 0; i < 3; i++ {
+		b := nextBlock(t, fork)
+		assert.Nil(t, fork.AddBlock(b))
+		assert.Nil(t, bc.AddBlock(b))
+	}
+
+	assert.Equal(t, head, bc.currentHead().hash)
+}
+
+func TestReorgHeaviestChain(t *testing.T) {
+	forkChoice := HeaviestChain{
+		WeightFunc: func(h *Header) *big.Int {
+			return big.NewInt(int64(h.Version))
+		},
+	}
+	bc, fork := newForkedChains(t, BlockchainOpts{ForkChoice: forkChoice})
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+
+	height := fork.Height() + 1
+	heavy := randomBlock(t, height, getPrevBlockHash(t, fork, height))
+	heavy.Version = 5
+	assert.Nil(t, fork.SetStateRoot(heavy))
+	assert.Nil(t, heavy.Sign(crypto.GeneratePrivateKey()))
+
+	assert.Nil(t, bc.AddBlock(heavy))
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.Equal(t, heavy.Hash(BlockHasher{}), bc.currentHead().hash)
+}
+
+func TestReorgConcurrentGetHeader(t *testing.T) {
+	forkChoice := HeaviestChain{
+		WeightFunc: func(h *Header) *big.Int {
+			return big.NewInt(int64(h.Version))
+		},
+	}
+	bc, fork := newForkedChains(t, BlockchainOpts{ForkChoice: forkChoice})
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+
+	height := fork.Height() + 1
+	heavy := randomBlock(t, height, getPrevBlockHash(t, fork, height))
+	heavy.Version = 5
+	assert.Nil(t, fork.SetStateRoot(heavy))
+	assert.Nil(t, heavy.Sign(crypto.GeneratePrivateKey()))
+
+	// The reorg shortens the chain while the readers ask for its old head,
+	// they get either the block or an error.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				bc.GetHeader(2)
+				bc.GetBlock(2)
+			}
+		}()
+	}
+
+	assert.Nil(t, bc.AddBlock(heavy))
+	close(done)
+	wg.Wait()
+
+	_, err := bc.GetHeader(2)
+	assert.NotNil(t, err)
+	_, err = bc.GetBlock(2)
+	assert.NotNil(t, err)
+}
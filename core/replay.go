@@ -0,0 +1,179 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+)
+
+// replayLogInterval is the number of blocks between two progress logs.
+const replayLogInterval = 1000
+
+// VerifyStore checks the signatures, the data hashes and the links between
+// the blocks in the store of the opts without executing them, which is much
+// faster than a replay. It returns the height of the branch the fork choice
+// prefers. Nothing is rebuilt, so a chain to serve from the store still has
+// to be created with NewBlockchainWithOpts.
+func VerifyStore(l log.Logger, genesis *Block, opts BlockchainOpts) (uint32, error) {
+	bc := newBlockchain(l, genesis, opts)
+
+	nodes, best, err := bc.loadBlockTree(genesis.Hash(BlockHasher{}), true)
+	if err != nil {
+		return 0, err
+	}
+	if best == nil {
+		return 0, nil
+	}
+
+	bc.logger.Log("msg", "verified stored blocks", "blocks", len(nodes), "height", best.block.Height, "hash", best.hash)
+
+	return best.block.Height, nil
+}
+
+// loadFromStore rebuilds the chain from the blocks that are already
+// persisted by executing them again. It reports whether any block was found.
+func (bc *Blockchain) loadFromStore(genesisHash types.Hash) (bool, error) {
+	start := time.Now()
+
+	nodes, best, err := bc.loadBlockTree(genesisHash, false)
+	if err != nil {
+		return false, err
+	}
+	if best == nil {
+		return false, nil
+	}
+
+	if err := bc.replayExecute(nodes, best, start); err != nil {
+		return false, err
+	}
+
+	bc.logger.Log(
+		"msg", "replayed blocks from store",
+		"blocks", len(nodes),
+		"height", bc.Height(),
+		"hash", bc.currentHead().hash,
+		"elapsed", time.Since(start),
+	)
+
+	return true, nil
+}
+
+// loadBlockTree adds all stored blocks to the block tree and returns them in
+// the order they were stored together with the tip of the branch the fork
+// choice prefers, which is nil for an empty store. With verify the blocks are
+// checked on their own, otherwise that is left to their execution. The first
+// stored block has to be the expected genesis block.
+//
+// All stored blocks are added to the tree first, so the snapshot to start
+// from can be picked from the branch the fork choice prefers. The blocks
+// after it are then connected in the order they were stored, which takes
+// the same fork choice and reorg decisions as when they were first added.
+func (bc *Blockchain) loadBlockTree(genesisHash types.Hash, verify bool) ([]*blockNode, *blockNode, error) {
+	nodes := []*blockNode{}
+
+	err := bc.store.Iterate(func(b *Block) error {
+		// The genesis block is not signed, it is checked by comparing it
+		// to the expected genesis block instead.
+		if len(nodes) == 0 {
+			if hash := b.Hash(BlockHasher{}); hash != genesisHash {
+				return fmt.Errorf("%w: stored (%s) expected (%s)", ErrGenesisMismatch, hash, genesisHash)
+			}
+		} else if verify {
+			if err := b.Verify(); err != nil {
+				return fmt.Errorf("stored block (%s) failed verification: %w", b.Hash(BlockHasher{}), err)
+			}
+		}
+
+		bc.lock.Lock()
+		node, err := bc.newBlockNode(b)
+		bc.lock.Unlock()
+		if err != nil {
+			return fmt.Errorf("stored block does not fit in the block tree: %w", err)
+		}
+		nodes = append(nodes, node)
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(nodes) == 0 {
+		return nodes, nil, nil
+	}
+
+	// On a tie the branch that was stored first wins.
+	best := nodes[0]
+	for _, node := range nodes {
+		if node.weight.Cmp(best.weight) > 0 {
+			best = node
+		}
+	}
+
+	return nodes, best, nil
+}
+
+// replayExecute restores the newest snapshot of the best branch, or executes
+// the genesis block when there is none, and connects all stored blocks that
+// come after it. The genesis block is trusted as is.
+func (bc *Blockchain) replayExecute(nodes []*blockNode, best *blockNode, start time.Time) error {
+	canonical := branch(nil, best)
+
+	snap, err := bc.loadSnapshot(canonical)
+	if err != nil {
+		return err
+	}
+
+	base := canonical[:1]
+	if snap != nil {
+		base = canonical[:snap.Height+1]
+		for _, node := range base {
+			bc.appendBlock(node, nil)
+		}
+		// Only the set of the snapshot block is known, branches that fork
+		// below it are too deep to become canonical anyway.
+		bc.recordValidators(base[len(base)-1])
+	} else {
+		receipts, err := bc.executeGenesis(base[0].block)
+		if err != nil {
+			return err
+		}
+		bc.recordValidators(base[0])
+		bc.appendBlock(base[0], receipts)
+	}
+
+	restored := make(map[*blockNode]bool, len(base))
+	for _, node := range base {
+		restored[node] = true
+	}
+
+	replayed := 0
+	for _, node := range nodes {
+		if restored[node] {
+			continue
+		}
+
+		extends := node.parent == bc.currentHead()
+		if _, err := bc.connectBlock(node); err != nil {
+			// A block on top of the head was accepted before, failing now
+			// means the store or the execution can not be trusted.
+			if extends {
+				return fmt.Errorf("stored block (%s) failed execution: %w", node.hash, err)
+			}
+			bc.logger.Log("msg", "skipping invalid stored branch", "hash", node.hash, "err", err)
+		}
+
+		replayed++
+		if replayed%replayLogInterval == 0 {
+			bc.logger.Log(
+				"msg", "replaying blocks",
+				"height", node.block.Height,
+				"elapsed", time.Since(start),
+			)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayRebuildsState(t *testing.T) {
+	store := NewMemoryStore()
+	genesis := randomBlock(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+
+	// Stores the value 5 under the key "FOO".
+	data := []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
+	transaction := NewTransaction(data)
+	transaction.GasLimit = 100
+	assert.Nil(t, transaction.Sign(crypto.GeneratePrivateKey()))
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, transaction)))
+
+	replayed, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+	assert.Equal(t, bc.Height(), replayed.Height())
+
+	value, err := replayed.contractState.Get([]byte("FOO"))
+	assert.Nil(t, err)
+	assert.Equal(t, NewU256(5), U256FromBytes(value[1:]))
+}
+
+func TestVerifyStore(t *testing.T) {
+	store := NewMemoryStore()
+	genesis := randomBlock(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+
+	height, err := VerifyStore(log.NewNopLogger(), genesis, BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), height)
+
+	// Tampering with a stored transaction must be detected.
+	last, err := bc.GetBlock(5)
+	assert.Nil(t, err)
+	last.Transactions[0].Data = []byte("bar")
+	last.Transactions[0].hash = types.Hash{}
+
+	_, err = VerifyStore(log.NewNopLogger(), genesis, BlockchainOpts{Store: store})
+	assert.NotNil(t, err)
+}
+
+func TestReplayBrokenLink(t *testing.T) {
+	store := NewMemoryStore()
+	genesis := randomBlock(t, 0, types.Hash{})
+	assert.Nil(t, store.Put(genesis))
+	assert.Nil(t, store.Put(randomBlock(t, 1, types.Hash{})))
+
+	_, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, BlockchainOpts{Store: store})
+	assert.NotNil(t, err)
+}
+
+func TestReplayGenesisMismatch(t *testing.T) {
+	store := NewMemoryStore()
+	_, err := NewBlockchainWithOpts(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}), BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+
+	_, err = NewBlockchainWithOpts(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}), BlockchainOpts{Store: store})
+	assert.ErrorIs(t, err, ErrGenesisMismatch)
+}
@@ -0,0 +1,399 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+// SnapshotVersion is bumped every time the layout of Snapshot changes.
+// Snapshots written with another version are ignored on startup.
+const SnapshotVersion uint32 = 6
+
+const (
+	snapshotExt         = ".snap"
+	defaultSnapshotKeep = 3
+)
+
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+type ContractEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+// Snapshot is a copy of the full chain state after executing the block
+// with the given height and hash.
+type Snapshot struct {
+	Version   uint32
+	Height    uint32
+	BlockHash types.Hash
+	StateRoot types.Hash
+	// Trie is the encoded state trie, see SparseMerkleTree.Encode.
+	Trie        []byte
+	Accounts    []Account
+	Contract    []ContractEntry
+	Collections map[types.Hash]CollectionTransaction
+	Mints       map[types.Hash]MintTransaction
+	Validators  []crypto.PublicKey
+	Candidates  []crypto.PublicKey
+	Bonds       []Bond
+	Unbondings  []Unbonding
+	Jails       []Jail
+	Offenses    []Offense
+}
+
+// SnapshotStore writes snapshots as files in a single directory. Every file
+// is prefixed with the sha256 checksum of the gob encoded snapshot so
+// partially written or corrupted files can be detected.
+type SnapshotStore struct {
+	dir string
+	// keep is the amount of snapshots that are kept on disk, older ones
+	// are removed when a new snapshot is saved.
+	keep int
+}
+
+func NewSnapshotStore(dir string, keep int) (*SnapshotStore, error) {
+	if keep <= 0 {
+		keep = defaultSnapshotKeep
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &SnapshotStore{
+		dir:  dir,
+		keep: keep,
+	}, nil
+}
+
+func (s *SnapshotStore) Save(snap *Snapshot) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(snap); err != nil {
+		return err
+	}
+
+	checksum := sha256.Sum256(buf.Bytes())
+	path := filepath.Join(s.dir, fmt.Sprintf("%010d-%s%s", snap.Height, snap.BlockHash, snapshotExt))
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(checksum[:]); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+
+	return s.prune()
+}
+
+// List returns the paths of all snapshots ordered from newest to oldest.
+func (s *SnapshotStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotExt) {
+			continue
+		}
+		paths = append(paths, filepath.Join(s.dir, entry.Name()))
+	}
+
+	// The height is zero padded so the names sort by height.
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+
+	return paths, nil
+}
+
+func (s *SnapshotStore) Load(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < sha256.Size {
+		return nil, ErrCorruptSnapshot
+	}
+
+	checksum := sha256.Sum256(data[sha256.Size:])
+	if !bytes.Equal(checksum[:], data[:sha256.Size]) {
+		return nil, ErrCorruptSnapshot
+	}
+
+	snap := new(Snapshot)
+	if err := gob.NewDecoder(bytes.NewReader(data[sha256.Size:])).Decode(snap); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCorruptSnapshot, err)
+	}
+
+	if snap.Version != SnapshotVersion {
+		return nil, fmt.Errorf("snapshot version (%d) not supported, expected (%d)", snap.Version, SnapshotVersion)
+	}
+
+	return snap, nil
+}
+
+func (s *SnapshotStore) prune() error {
+	paths, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for i := s.keep; i < len(paths); i++ {
+		if err := os.Remove(paths[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snapshot copies the current state of the chain after executing the given
+// block. The caller must hold the stateLock.
+func (bc *Blockchain) snapshot(b *Block) (*Snapshot, error) {
+	snap := bc.copyState()
+	snap.Height = b.Height
+	snap.BlockHash = b.Hash(BlockHasher{})
+	snap.StateRoot = bc.stateRoot()
+
+	buf := &bytes.Buffer{}
+	if err := bc.trie.Encode(buf); err != nil {
+		return nil, err
+	}
+	snap.Trie = buf.Bytes()
+
+	return snap, nil
+}
+
+// copyState copies the accounts, contract storage, NFT and staking state. The caller
+// must hold the stateLock.
+func (bc *Blockchain) copyState() *Snapshot {
+	snap := &Snapshot{
+		Version:     SnapshotVersion,
+		Accounts:    []Account{},
+		Contract:    []ContractEntry{},
+		Collections: make(map[types.Hash]CollectionTransaction, len(bc.collectionState)),
+		Mints:       make(map[types.Hash]MintTransaction, len(bc.mintState)),
+	}
+
+	bc.accountState.mu.RLock()
+	for _, account := range bc.accountState.accounts {
+		snap.Accounts = append(snap.Accounts, *account)
+	}
+	bc.accountState.mu.RUnlock()
+	sort.Slice(snap.Accounts, func(i, j int) bool {
+		return bytes.Compare(snap.Accounts[i].Address.Slice(), snap.Accounts[j].Address.Slice()) < 0
+	})
+
+	for key, value := range bc.contractState.data {
+		snap.Contract = append(snap.Contract, ContractEntry{Key: []byte(key), Value: value})
+	}
+	sort.Slice(snap.Contract, func(i, j int) bool {
+		return bytes.Compare(snap.Contract[i].Key, snap.Contract[j].Key) < 0
+	})
+
+	for hash, collection := range bc.collectionState {
+		snap.Collections[hash] = *collection
+	}
+	for hash, mint := range bc.mintState {
+		snap.Mints[hash] = *mint
+	}
+
+	snap.Validators = make([]crypto.PublicKey, len(bc.validators))
+	copy(snap.Validators, bc.validators)
+
+	snap.Candidates = bc.stakingState.sortedCandidates()
+	snap.Bonds = bc.stakingState.sortedBonds()
+	snap.Unbondings = make([]Unbonding, len(bc.stakingState.unbondings))
+	copy(snap.Unbondings, bc.stakingState.unbondings)
+	snap.Jails = bc.stakingState.sortedJails()
+	snap.Offenses = bc.stakingState.sortedOffenses()
+
+	return snap
+}
+
+// restoreWithoutLock replaces the current state of the chain with the given
+// snapshot. The caller must hold the stateLock.
+func (bc *Blockchain) restoreWithoutLock(snap *Snapshot) {
+	// Recorded changes refer to the state that is being replaced.
+	bc.journal.reset()
+	bc.trieSynced = false
+
+	accounts := make(map[types.Address]*Account, len(snap.Accounts))
+	for i := range snap.Accounts {
+		account := snap.Accounts[i]
+		accounts[account.Address] = &account
+	}
+	bc.accountState.mu.Lock()
+	bc.accountState.accounts = accounts
+	bc.accountState.mu.Unlock()
+
+	bc.contractState = NewState()
+	bc.contractState.journal = bc.journal
+	for _, entry := range snap.Contract {
+		bc.contractState.data[string(entry.Key)] = entry.Value
+	}
+
+	bc.collectionState = make(map[types.Hash]*CollectionTransaction, len(snap.Collections))
+	for hash, collection := range snap.Collections {
+		bc.collectionState[hash] = &collection
+	}
+
+	bc.mintState = make(map[types.Hash]*MintTransaction, len(snap.Mints))
+	for hash, mint := range snap.Mints {
+		bc.mintState[hash] = &mint
+	}
+
+	bc.validators = make([]crypto.PublicKey, len(snap.Validators))
+	copy(bc.validators, snap.Validators)
+
+	staking := NewStakingState()
+	staking.journal = bc.journal
+	for _, key := range snap.Candidates {
+		staking.candidates[key.Address()] = key
+	}
+	for _, bond := range snap.Bonds {
+		staking.bonds[bondKey{delegator: bond.Delegator, validator: bond.Validator}] = bond.Amount
+	}
+	staking.unbondings = append(staking.unbondings, snap.Unbondings...)
+	for _, jail := range snap.Jails {
+		staking.jailed[jail.Validator] = jail.Release
+	}
+	for _, o := range snap.Offenses {
+		staking.offenses[offense{validator: o.Validator, height: o.Height}] = struct{}{}
+	}
+	bc.stakingState = staking
+}
+
+// maybeSnapshot writes a snapshot after every SnapshotInterval blocks.
+func (bc *Blockchain) maybeSnapshot(b *Block) error {
+	if bc.snapshots == nil || bc.snapshotInterval == 0 || b.Height%bc.snapshotInterval != 0 {
+		return nil
+	}
+
+	bc.stateLock.Lock()
+	snap, err := bc.snapshot(b)
+	bc.stateLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := bc.snapshots.Save(snap); err != nil {
+		return err
+	}
+
+	bc.logger.Log("msg", "saved state snapshot", "height", snap.Height, "hash", snap.BlockHash)
+
+	return nil
+}
+
+// loadSnapshot restores the newest valid snapshot that belongs to a block
+// of the given branch. Corrupt snapshots are skipped in favour of older
+// ones.
+func (bc *Blockchain) loadSnapshot(canonical []*blockNode) (*Snapshot, error) {
+	if bc.snapshots == nil {
+		return nil, nil
+	}
+
+	blocks := make(map[types.Hash]*Block, len(canonical))
+	for _, node := range canonical {
+		blocks[node.hash] = node.block
+	}
+
+	paths, err := bc.snapshots.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		snap, err := bc.snapshots.Load(path)
+		if err != nil {
+			bc.logger.Log("msg", "skipping invalid snapshot", "path", path, "err", err)
+			continue
+		}
+
+		block, ok := blocks[snap.BlockHash]
+		if !ok {
+			bc.logger.Log("msg", "skipping snapshot of block off the canonical chain", "path", path, "hash", snap.BlockHash)
+			continue
+		}
+
+		if err := bc.restoreVerified(snap, block); err != nil {
+			bc.logger.Log("msg", "skipping snapshot", "path", path, "err", err)
+			continue
+		}
+		bc.logger.Log("msg", "restored state snapshot", "height", snap.Height, "hash", snap.BlockHash)
+
+		return snap, nil
+	}
+
+	return nil, nil
+}
+
+// restoreVerified restores the snapshot and makes sure the restored state
+// matches both the persisted trie and the state root of the block the
+// snapshot was taken at.
+func (bc *Blockchain) restoreVerified(snap *Snapshot, b *Block) error {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	trie := NewSparseMerkleTree()
+	if err := trie.Decode(bytes.NewReader(snap.Trie)); err != nil {
+		return fmt.Errorf("%w: %s", ErrCorruptSnapshot, err)
+	}
+	if trie.Root() != snap.StateRoot {
+		return fmt.Errorf("%w: trie root (%s) does not match (%s)", ErrCorruptSnapshot, trie.Root(), snap.StateRoot)
+	}
+
+	backup := bc.copyState()
+	previous := bc.trie
+
+	bc.restoreWithoutLock(snap)
+	bc.trie = trie
+
+	// Like during replay the genesis block is trusted as is.
+	if b.Height == 0 {
+		return nil
+	}
+
+	if err := bc.validator.ValidateStateRoot(b, bc.stateRoot()); err != nil {
+		bc.restoreWithoutLock(backup)
+		bc.trie = previous
+		return err
+	}
+
+	return nil
+}
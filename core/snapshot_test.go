@@ -0,0 +1,113 @@
+package core
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotSaveLoad(t *testing.T) {
+	s, err := NewSnapshotStore(t.TempDir(), 2)
+	assert.Nil(t, err)
+
+	for i := 1; i <= 3; i++ {
+		snap := &Snapshot{
+			Version:   SnapshotVersion,
+			Height:    uint32(i),
+			BlockHash: types.Hash{byte(i)},
+			Accounts:  []Account{{Balance: uint64(i)}},
+		}
+		assert.Nil(t, s.Save(snap))
+	}
+
+	paths, err := s.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(paths))
+
+	snap, err := s.Load(paths[0])
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), snap.Height)
+	assert.Equal(t, uint64(3), snap.Accounts[0].Balance)
+}
+
+func TestSnapshotCorrupt(t *testing.T) {
+	s, err := NewSnapshotStore(t.TempDir(), 0)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Save(&Snapshot{Version: SnapshotVersion, Height: 1}))
+
+	paths, err := s.List()
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(paths[0])
+	assert.Nil(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(paths[0], data, 0644))
+
+	_, err = s.Load(paths[0])
+	assert.ErrorIs(t, err, ErrCorruptSnapshot)
+}
+
+func TestBlockchainRestoreFromSnapshot(t *testing.T) {
+	store := NewMemoryStore()
+	snapshots, err := NewSnapshotStore(t.TempDir(), 0)
+	assert.Nil(t, err)
+
+	opts := BlockchainOpts{
+		Store:            store,
+		Snapshots:        snapshots,
+		SnapshotInterval: 2,
+	}
+
+	genesis := randomBlock(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+
+	paths, err := snapshots.List()
+	assert.Nil(t, err)
+	// Snapshots at height 0, 2 and 4.
+	assert.Equal(t, 3, len(paths))
+
+	logs := &bytes.Buffer{}
+	restored, err := NewBlockchainWithOpts(log.NewLogfmtLogger(logs), genesis, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), restored.Height())
+	assert.Equal(t, bc.StateRoot(), restored.StateRoot())
+	assert.Contains(t, logs.String(), `msg="restored state snapshot" height=4`)
+
+	// A snapshot with a valid checksum whose state does not match the state
+	// root of its block is rejected and the older snapshot is used.
+	newest, err := snapshots.Load(paths[0])
+	assert.Nil(t, err)
+	newest.Contract = append(newest.Contract, ContractEntry{Key: []byte("MARK"), Value: []byte{4}})
+	assert.Nil(t, snapshots.Save(newest))
+
+	logs.Reset()
+	restored, err = NewBlockchainWithOpts(log.NewLogfmtLogger(logs), genesis, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.StateRoot(), restored.StateRoot())
+	assert.Contains(t, logs.String(), `msg="restored state snapshot" height=2`)
+
+	_, err = restored.contractState.Get([]byte("MARK"))
+	assert.NotNil(t, err)
+	assertStateRoot(t, restored)
+
+	// The same goes for a snapshot that got corrupted on disk.
+	data, err := os.ReadFile(paths[0])
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(paths[0], data[:len(data)/2], 0644))
+
+	logs.Reset()
+	restored, err = NewBlockchainWithOpts(log.NewLogfmtLogger(logs), genesis, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(5), restored.Height())
+	assert.Equal(t, bc.StateRoot(), restored.StateRoot())
+	assert.Contains(t, logs.String(), `msg="restored state snapshot" height=2`)
+}
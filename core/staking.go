@@ -0,0 +1,514 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+var (
+	ErrZeroStake         = errors.New("stake amount can not be zero")
+	ErrNotCandidate      = errors.New("validator has no stake of its own")
+	ErrInsufficientStake = errors.New("insufficient bonded stake")
+	ErrStakeOverflow     = errors.New("stake overflows")
+)
+
+// StakeTransaction locks Amount of the balance of the sender as stake of its
+// own, which makes the sender a candidate for the validator set.
+type StakeTransaction struct {
+	Amount uint64
+}
+
+// DelegateTransaction locks Amount of the balance of the sender as stake of
+// the given validator, which has to have stake of its own.
+type DelegateTransaction struct {
+	Validator crypto.PublicKey
+	Amount    uint64
+}
+
+// UnstakeTransaction unbonds Amount of the stake the sender bonded to the
+// given validator, the sender unbonds its own stake by naming itself. The
+// amount is paid back once the unbonding period is over.
+type UnstakeTransaction struct {
+	Validator crypto.PublicKey
+	Amount    uint64
+}
+
+// StakingParams control how the validator set follows the stake.
+type StakingParams struct {
+	// EpochLength is the number of blocks in an epoch. The validator set
+	// is computed from the stake at the last block of every epoch and
+	// takes over with the next block. The validator set never changes when
+	// zero.
+	EpochLength uint32 `json:"epochLength" yaml:"epochLength"`
+	// UnbondingPeriod is the number of blocks unstaked coins stay locked.
+	UnbondingPeriod uint32 `json:"unbondingPeriod" yaml:"unbondingPeriod"`
+	// MinStake is the total stake a candidate needs to become a
+	// validator.
+	MinStake uint64 `json:"minStake" yaml:"minStake"`
+	// MaxValidators caps the size of the validator set, the candidates
+	// with the most stake win. There is no cap when zero.
+	MaxValidators uint32 `json:"maxValidators" yaml:"maxValidators"`
+	// SlashPercent is the percentage of the stake bonded to a validator
+	// that is burned when it double signs.
+	SlashPercent uint32 `json:"slashPercent" yaml:"slashPercent"`
+	// JailPeriod is the number of blocks a validator that double signed
+	// can not stake. It is jailed for good when zero.
+	JailPeriod uint32 `json:"jailPeriod" yaml:"jailPeriod"`
+}
+
+// Bond is stake the delegator bonded to a validator.
+type Bond struct {
+	Delegator types.Address
+	Validator types.Address
+	Amount    uint64
+}
+
+// Unbonding is stake that was unstaked and is paid back to the delegator
+// with the block at the Release height.
+type Unbonding struct {
+	Delegator types.Address
+	Amount    uint64
+	Release   uint32
+}
+
+type bondKey struct {
+	delegator types.Address
+	validator types.Address
+}
+
+// StakingState holds the candidates, the bonded stake and the stake that is
+// unbonding.
+type StakingState struct {
+	// candidates maps the address of every validator with stake of its own
+	// to its key.
+	candidates map[types.Address]crypto.PublicKey
+	bonds      map[bondKey]uint64
+	// unbondings is ordered by the height the unbonding started at.
+	unbondings []Unbonding
+	// jailed maps the address of every jailed validator to the height it
+	// is released at.
+	jailed   map[types.Address]uint32
+	offenses map[offense]struct{}
+	journal  *journal
+}
+
+func NewStakingState() *StakingState {
+	return &StakingState{
+		candidates: make(map[types.Address]crypto.PublicKey),
+		bonds:      make(map[bondKey]uint64),
+		unbondings: []Unbonding{},
+		jailed:     make(map[types.Address]uint32),
+		offenses:   make(map[offense]struct{}),
+	}
+}
+
+func (s *StakingState) bond(delegator, validator types.Address, amount uint64) error {
+	key := bondKey{delegator: delegator, validator: validator}
+	prev, ok := s.bonds[key]
+
+	// The bond is part of the stake of the validator, so checking the
+	// stake covers the bond as well.
+	stake, err := s.Stake(validator)
+	if err != nil {
+		return err
+	}
+	if stake > math.MaxUint64-amount {
+		return fmt.Errorf("%w: (%s) has stake (%d) and can not bond (%d) more", ErrStakeOverflow, validator, stake, amount)
+	}
+
+	s.journal.append(bondChange{state: s, key: key, prev: prev, existed: ok})
+	s.bonds[key] = prev + amount
+
+	return nil
+}
+
+func (s *StakingState) unbond(delegator, validator types.Address, amount uint64) error {
+	key := bondKey{delegator: delegator, validator: validator}
+	prev := s.bonds[key]
+	if prev < amount {
+		return fmt.Errorf("%w: (%s) bonded (%d) to (%s) and can not unbond (%d)", ErrInsufficientStake, delegator, prev, validator, amount)
+	}
+
+	s.journal.append(bondChange{state: s, key: key, prev: prev, existed: true})
+	if prev == amount {
+		delete(s.bonds, key)
+	} else {
+		s.bonds[key] = prev - amount
+	}
+
+	return nil
+}
+
+func (s *StakingState) putCandidate(key crypto.PublicKey) {
+	address := key.Address()
+	prev, ok := s.candidates[address]
+	s.journal.append(candidateChange{state: s, address: address, prev: prev, existed: ok})
+	s.candidates[address] = key
+}
+
+func (s *StakingState) deleteCandidate(address types.Address) {
+	prev, ok := s.candidates[address]
+	if !ok {
+		return
+	}
+	s.journal.append(candidateChange{state: s, address: address, prev: prev, existed: true})
+	delete(s.candidates, address)
+}
+
+// setUnbondings replaces the unbondings, the slice is never changed in place
+// so the journal can hold on to the previous one.
+func (s *StakingState) setUnbondings(unbondings []Unbonding) {
+	s.journal.append(unbondingChange{state: s, prev: s.unbondings, next: len(unbondings)})
+	s.unbondings = unbondings
+}
+
+func (s *StakingState) putJail(validator types.Address, release uint32) {
+	prev, ok := s.jailed[validator]
+	s.journal.append(jailChange{state: s, validator: validator, prev: prev, existed: ok})
+	s.jailed[validator] = release
+}
+
+func (s *StakingState) deleteJail(validator types.Address) {
+	prev, ok := s.jailed[validator]
+	if !ok {
+		return
+	}
+	s.journal.append(jailChange{state: s, validator: validator, prev: prev, existed: true})
+	delete(s.jailed, validator)
+}
+
+func (s *StakingState) putOffense(o offense) {
+	s.journal.append(offenseChange{state: s, offense: o})
+	s.offenses[o] = struct{}{}
+}
+
+// slash burns the given percentage of every bond to the validator and
+// returns the amount burned.
+func (s *StakingState) slash(validator types.Address, percent uint32) (uint64, error) {
+	if percent > 100 {
+		percent = 100
+	}
+
+	var slashed uint64
+	for _, bond := range s.sortedBonds() {
+		if bond.Validator != validator {
+			continue
+		}
+
+		// Split the amount so the multiplication can not overflow.
+		cut := bond.Amount/100*uint64(percent) + bond.Amount%100*uint64(percent)/100
+		if cut == 0 {
+			continue
+		}
+		if err := s.unbond(bond.Delegator, validator, cut); err != nil {
+			return 0, err
+		}
+		slashed += cut
+	}
+
+	return slashed, nil
+}
+
+// sortedJails returns all jailed validators ordered by address.
+func (s *StakingState) sortedJails() []Jail {
+	jails := make([]Jail, 0, len(s.jailed))
+	for validator, release := range s.jailed {
+		jails = append(jails, Jail{Validator: validator, Release: release})
+	}
+	sort.Slice(jails, func(i, j int) bool {
+		return bytes.Compare(jails[i].Validator[:], jails[j].Validator[:]) < 0
+	})
+
+	return jails
+}
+
+// sortedOffenses returns all punished offenses ordered by validator and
+// height.
+func (s *StakingState) sortedOffenses() []Offense {
+	offenses := make([]Offense, 0, len(s.offenses))
+	for o := range s.offenses {
+		offenses = append(offenses, Offense{Validator: o.validator, Height: o.height})
+	}
+	sort.Slice(offenses, func(i, j int) bool {
+		if c := bytes.Compare(offenses[i].Validator[:], offenses[j].Validator[:]); c != 0 {
+			return c < 0
+		}
+		return offenses[i].Height < offenses[j].Height
+	})
+
+	return offenses
+}
+
+// Bonded returns the stake the delegator bonded to the validator.
+func (s *StakingState) Bonded(delegator, validator types.Address) uint64 {
+	return s.bonds[bondKey{delegator: delegator, validator: validator}]
+}
+
+// Stake returns the total stake bonded to the validator.
+func (s *StakingState) Stake(validator types.Address) (uint64, error) {
+	var total uint64
+	for key, amount := range s.bonds {
+		if key.validator != validator {
+			continue
+		}
+		if total > math.MaxUint64-amount {
+			return 0, fmt.Errorf("%w: stake of (%s)", ErrStakeOverflow, validator)
+		}
+		total += amount
+	}
+
+	return total, nil
+}
+
+// Locked returns the sum of all bonded and unbonding stake.
+func (s *StakingState) Locked() *big.Int {
+	locked := new(big.Int)
+	for _, amount := range s.bonds {
+		locked.Add(locked, new(big.Int).SetUint64(amount))
+	}
+	for _, unbonding := range s.unbondings {
+		locked.Add(locked, new(big.Int).SetUint64(unbonding.Amount))
+	}
+
+	return locked
+}
+
+// sortedBonds returns all bonds ordered by delegator and validator.
+func (s *StakingState) sortedBonds() []Bond {
+	bonds := make([]Bond, 0, len(s.bonds))
+	for key, amount := range s.bonds {
+		bonds = append(bonds, Bond{Delegator: key.delegator, Validator: key.validator, Amount: amount})
+	}
+	sort.Slice(bonds, func(i, j int) bool {
+		if c := bytes.Compare(bonds[i].Delegator[:], bonds[j].Delegator[:]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(bonds[i].Validator[:], bonds[j].Validator[:]) < 0
+	})
+
+	return bonds
+}
+
+// sortedCandidates returns the keys of all candidates ordered by address.
+func (s *StakingState) sortedCandidates() []crypto.PublicKey {
+	candidates := make([]crypto.PublicKey, 0, len(s.candidates))
+	for _, key := range s.candidates {
+		candidates = append(candidates, key)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i].Address(), candidates[j].Address()
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+
+	return candidates
+}
+
+// validatorSet returns the candidates with at least minStake ordered by
+// stake, the most stake first, with ties broken by address. At most max
+// validators are returned unless max is zero.
+func (s *StakingState) validatorSet(minStake uint64, max uint32) ([]crypto.PublicKey, error) {
+	type candidate struct {
+		key   crypto.PublicKey
+		stake uint64
+	}
+
+	candidates := []candidate{}
+	for _, key := range s.sortedCandidates() {
+		stake, err := s.Stake(key.Address())
+		if err != nil {
+			return nil, err
+		}
+		if stake == 0 || stake < minStake {
+			continue
+		}
+		candidates = append(candidates, candidate{key: key, stake: stake})
+	}
+	// The candidates are ordered by address already, a stable sort keeps
+	// that order for equal stake.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].stake > candidates[j].stake
+	})
+
+	if max > 0 && len(candidates) > int(max) {
+		candidates = candidates[:max]
+	}
+
+	validators := make([]crypto.PublicKey, len(candidates))
+	for i, c := range candidates {
+		validators[i] = c.key
+	}
+
+	return validators, nil
+}
+
+// handleStaking runs a staking transaction as part of the block at the given
+// height.
+func (bc *Blockchain) handleStaking(transaction *Transaction, height uint32) error {
+	from := transaction.From.Address()
+
+	switch t := transaction.TransactionInner.(type) {
+	case StakeTransaction:
+		if t.Amount == 0 {
+			return ErrZeroStake
+		}
+		if release, ok := bc.stakingState.jailed[from]; ok {
+			return fmt.Errorf("%w: (%s) is jailed until height (%d)", ErrJailed, from, release)
+		}
+		if err := bc.accountState.Debit(from, t.Amount); err != nil {
+			return fmt.Errorf("failed to stake (%d): %w", t.Amount, err)
+		}
+		if err := bc.stakingState.bond(from, from, t.Amount); err != nil {
+			return err
+		}
+		bc.stakingState.putCandidate(transaction.From)
+
+		bc.logger.Log("msg", "staked", "validator", from, "amount", t.Amount)
+	case DelegateTransaction:
+		if t.Amount == 0 {
+			return ErrZeroStake
+		}
+		validator := t.Validator.Address()
+		if _, ok := bc.stakingState.candidates[validator]; !ok {
+			return fmt.Errorf("%w: can not delegate to (%s)", ErrNotCandidate, validator)
+		}
+		if err := bc.accountState.Debit(from, t.Amount); err != nil {
+			return fmt.Errorf("failed to delegate (%d): %w", t.Amount, err)
+		}
+		if err := bc.stakingState.bond(from, validator, t.Amount); err != nil {
+			return err
+		}
+
+		bc.logger.Log("msg", "delegated", "delegator", from, "validator", validator, "amount", t.Amount)
+	case UnstakeTransaction:
+		if t.Amount == 0 {
+			return ErrZeroStake
+		}
+		validator := t.Validator.Address()
+		if err := bc.stakingState.unbond(from, validator, t.Amount); err != nil {
+			return err
+		}
+		// A validator that unbonded all of its own stake is no longer a
+		// candidate, stake delegated to it stays bonded until unstaked.
+		if from == validator && bc.stakingState.Bonded(from, from) == 0 {
+			bc.stakingState.deleteCandidate(from)
+		}
+
+		unbonding := Unbonding{
+			Delegator: from,
+			Amount:    t.Amount,
+			Release:   math.MaxUint32,
+		}
+		if period := bc.genesis.Staking.UnbondingPeriod; height <= math.MaxUint32-period {
+			unbonding.Release = height + period
+		}
+		unbondings := make([]Unbonding, len(bc.stakingState.unbondings), len(bc.stakingState.unbondings)+1)
+		copy(unbondings, bc.stakingState.unbondings)
+		bc.stakingState.setUnbondings(append(unbondings, unbonding))
+
+		bc.logger.Log("msg", "unstaked", "delegator", from, "validator", validator, "amount", t.Amount, "release", unbonding.Release)
+	default:
+		return fmt.Errorf("unsupported transaction type %v", t)
+	}
+
+	return nil
+}
+
+// endBlock pays back the stake that finished unbonding and releases the
+// validators whose jail time is over. At the end of an epoch it replaces
+// the validator set with the candidates that have the most stake. The set
+// is kept as is when no candidate qualifies, so the chain can not end up
+// without validators. The caller must hold the stateLock.
+func (bc *Blockchain) endBlock(b *Block) error {
+	if err := bc.releaseUnbondings(b.Height); err != nil {
+		return err
+	}
+
+	for _, jail := range bc.stakingState.sortedJails() {
+		if jail.Release <= b.Height {
+			bc.stakingState.deleteJail(jail.Validator)
+		}
+	}
+
+	params := bc.genesis.Staking
+	if params.EpochLength == 0 || b.Height == 0 || b.Height%params.EpochLength != 0 {
+		return nil
+	}
+
+	validators, err := bc.stakingState.validatorSet(params.MinStake, params.MaxValidators)
+	if err != nil {
+		return err
+	}
+	if len(validators) == 0 {
+		return nil
+	}
+
+	bc.setValidators(validators)
+	bc.logger.Log("msg", "new validator set", "height", b.Height, "validators", len(validators))
+
+	return nil
+}
+
+func (bc *Blockchain) releaseUnbondings(height uint32) error {
+	var (
+		unbondings = bc.stakingState.unbondings
+		remaining  = []Unbonding{}
+	)
+	for _, unbonding := range unbondings {
+		if unbonding.Release > height {
+			remaining = append(remaining, unbonding)
+			continue
+		}
+		if err := bc.accountState.Credit(unbonding.Delegator, unbonding.Amount); err != nil {
+			return fmt.Errorf("failed to release unbonded stake of (%s): %w", unbonding.Delegator, err)
+		}
+	}
+
+	if len(remaining) != len(unbondings) {
+		bc.stakingState.setUnbondings(remaining)
+	}
+
+	return nil
+}
+
+func (bc *Blockchain) setValidators(validators []crypto.PublicKey) {
+	bc.journal.append(validatorSetChange{bc: bc, prev: bc.validators, next: len(validators)})
+	bc.validators = validators
+}
+
+// GetStake returns the total stake bonded to the validator.
+func (bc *Blockchain) GetStake(validator types.Address) (uint64, error) {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.stakingState.Stake(validator)
+}
+
+// GetBond returns the stake the delegator bonded to the validator.
+func (bc *Blockchain) GetBond(delegator, validator types.Address) uint64 {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	return bc.stakingState.Bonded(delegator, validator)
+}
+
+// GetUnbondings returns the stake of the delegator that is still unbonding.
+func (bc *Blockchain) GetUnbondings(delegator types.Address) []Unbonding {
+	bc.stateLock.RLock()
+	defer bc.stateLock.RUnlock()
+
+	unbondings := []Unbonding{}
+	for _, unbonding := range bc.stakingState.unbondings {
+		if unbonding.Delegator == delegator {
+			unbondings = append(unbondings, unbonding)
+		}
+	}
+
+	return unbondings
+}
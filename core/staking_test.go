@@ -0,0 +1,247 @@
+package core
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func stakingTransaction(t *testing.T, privKey crypto.PrivateKey, nonce uint64, inner any) *Transaction {
+	transaction := NewTransaction(nil)
+	transaction.TransactionInner = inner
+	transaction.Nonce = nonce
+	assert.Nil(t, transaction.Sign(privKey))
+
+	return transaction
+}
+
+func assertReceipt(t *testing.T, bc *Blockchain, transaction *Transaction, status ReceiptStatus) {
+	receipt, err := bc.GetReceipt(transaction.Hash(TransactionHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, status, receipt.Status, receipt.Error)
+}
+
+func TestStakingValidatorSet(t *testing.T) {
+	var (
+		genesisValidator = crypto.GeneratePrivateKey()
+		alice            = crypto.GeneratePrivateKey()
+		bob              = crypto.GeneratePrivateKey()
+		carol            = crypto.GeneratePrivateKey()
+		store            = NewMemoryStore()
+	)
+
+	opts := BlockchainOpts{
+		Store: store,
+		Genesis: Genesis{
+			Alloc: map[types.Address]uint64{
+				alice.PublicKey().Address(): 1000,
+				bob.PublicKey().Address():   1000,
+				carol.PublicKey().Address(): 1000,
+			},
+			Validators: []crypto.PublicKey{genesisValidator.PublicKey()},
+			Staking: StakingParams{
+				EpochLength:     2,
+				UnbondingPeriod: 3,
+				MinStake:        100,
+			},
+		},
+	}
+	genesis := randomBlock(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, opts)
+	assert.Nil(t, err)
+
+	var (
+		stake    = stakingTransaction(t, alice, 0, StakeTransaction{Amount: 100})
+		delegate = stakingTransaction(t, bob, 0, DelegateTransaction{Validator: alice.PublicKey(), Amount: 50})
+		small    = stakingTransaction(t, carol, 0, StakeTransaction{Amount: 60})
+		// Bob has no stake of its own, so nobody can delegate to him.
+		invalid = stakingTransaction(t, carol, 1, DelegateTransaction{Validator: bob.PublicKey(), Amount: 10})
+	)
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, stake, delegate, small, invalid)))
+	assertReceipt(t, bc, invalid, ReceiptStatusFailed)
+
+	staked, err := bc.GetStake(alice.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(150), staked)
+	assert.Equal(t, uint64(50), bc.GetBond(bob.PublicKey().Address(), alice.PublicKey().Address()))
+	balance, err := bc.GetBalance(alice.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(900), balance)
+
+	// The validator set only changes at the end of the epoch, carol does not
+	// have enough stake to make it.
+	assert.Equal(t, []crypto.PublicKey{genesisValidator.PublicKey()}, bc.Validators())
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	assert.Equal(t, []crypto.PublicKey{alice.PublicKey()}, bc.Validators())
+
+	// Bob unbonds, his stake is paid back once the unbonding period is over.
+	unstake := stakingTransaction(t, bob, 1, UnstakeTransaction{Validator: alice.PublicKey(), Amount: 50})
+	tooMuch := stakingTransaction(t, carol, 2, UnstakeTransaction{Validator: carol.PublicKey(), Amount: 61})
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, unstake, tooMuch)))
+	assertReceipt(t, bc, unstake, ReceiptStatusSuccess)
+	assertReceipt(t, bc, tooMuch, ReceiptStatusFailed)
+
+	staked, err = bc.GetStake(alice.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), staked)
+	assert.Equal(t, []Unbonding{{Delegator: bob.PublicKey().Address(), Amount: 50, Release: 6}}, bc.GetUnbondings(bob.PublicKey().Address()))
+
+	for bc.Height() < 5 {
+		assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	}
+	balance, err = bc.GetBalance(bob.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(950), balance)
+
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc)))
+	balance, err = bc.GetBalance(bob.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1000), balance)
+	assert.Empty(t, bc.GetUnbondings(bob.PublicKey().Address()))
+	assertStateRoot(t, bc)
+
+	// Locked stake is still part of the supply.
+	assert.Equal(t, uint64(3000), bc.TotalSupply().Uint64())
+
+	// Replaying the chain ends up with the same stake and validators.
+	replayed, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.StateRoot(), replayed.StateRoot())
+	assert.Equal(t, bc.Validators(), replayed.Validators())
+}
+
+// validatorEngine only accepts blocks sealed by a member of the validator
+// set.
+type validatorEngine struct {
+	NopEngine
+}
+
+func (validatorEngine) VerifySeal(chain ChainReader, b *Block, _ *Header) error {
+	for _, validator := range chain.Validators() {
+		if validator.Address() == b.Validator.Address() {
+			return nil
+		}
+	}
+
+	return ErrWrongProposer
+}
+
+func TestStakingValidatorSetPerBranch(t *testing.T) {
+	var (
+		genesisValidator = crypto.GeneratePrivateKey()
+		alice            = crypto.GeneratePrivateKey()
+	)
+
+	opts := BlockchainOpts{
+		Engine: validatorEngine{},
+		Genesis: Genesis{
+			Alloc: map[types.Address]uint64{
+				alice.PublicKey().Address(): 1000,
+			},
+			Validators: []crypto.PublicKey{genesisValidator.PublicKey()},
+			Staking:    StakingParams{EpochLength: 1},
+		},
+	}
+	genesis := randomBlock(t, 0, types.Hash{})
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, opts)
+	assert.Nil(t, err)
+	fork, err := NewBlockchainWithOpts(log.NewNopLogger(), genesis, opts)
+	assert.Nil(t, err)
+
+	// Alice takes over the validator set of the canonical chain.
+	stake := stakingTransaction(t, alice, 0, StakeTransaction{Amount: 100})
+	assert.Nil(t, bc.AddBlock(proposeBlock(t, bc, genesisValidator, bc.Now(), stake)))
+	assert.Equal(t, []crypto.PublicKey{alice.PublicKey()}, bc.Validators())
+
+	// On the fork the genesis validator stays in charge.
+	b1 := proposeBlock(t, fork, genesisValidator, fork.Now())
+	assert.Nil(t, fork.AddBlock(b1))
+	byAlice := proposeBlock(t, fork, alice, fork.Now())
+	b2 := proposeBlock(t, fork, genesisValidator, fork.Now())
+
+	assert.Nil(t, bc.AddBlock(b1))
+
+	// Alice is a validator of the canonical chain but not of the branch.
+	assert.NotNil(t, bc.AddBlock(byAlice))
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.Equal(t, []crypto.PublicKey{alice.PublicKey()}, bc.Validators())
+
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, uint32(2), bc.Height())
+	assert.Equal(t, b2.Hash(BlockHasher{}), bc.currentHead().hash)
+	assert.Equal(t, []crypto.PublicKey{genesisValidator.PublicKey()}, bc.Validators())
+}
+
+func TestStakingMaxValidators(t *testing.T) {
+	privKeys := []crypto.PrivateKey{
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+		crypto.GeneratePrivateKey(),
+	}
+
+	genesis := Genesis{
+		Alloc:   map[types.Address]uint64{},
+		Staking: StakingParams{EpochLength: 1, MaxValidators: 2},
+	}
+	for _, privKey := range privKeys {
+		genesis.Alloc[privKey.PublicKey().Address()] = 1000
+	}
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}), BlockchainOpts{Genesis: genesis})
+	assert.Nil(t, err)
+
+	transactions := []*Transaction{}
+	for i, privKey := range privKeys {
+		transactions = append(transactions, stakingTransaction(t, privKey, 0, StakeTransaction{Amount: uint64(10 * (i + 1))}))
+	}
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, transactions...)))
+
+	// The candidates with the most stake win.
+	assert.Equal(t, []crypto.PublicKey{privKeys[2].PublicKey(), privKeys[1].PublicKey()}, bc.Validators())
+
+	// A validator that unbonds all of its stake leaves the set.
+	unstake := stakingTransaction(t, privKeys[2], 1, UnstakeTransaction{Validator: privKeys[2].PublicKey(), Amount: 30})
+	assert.Nil(t, bc.AddBlock(nextBlock(t, bc, unstake)))
+	assert.Equal(t, []crypto.PublicKey{privKeys[1].PublicKey(), privKeys[0].PublicKey()}, bc.Validators())
+}
+
+func TestStakingStakeOverflow(t *testing.T) {
+	s := NewStakingState()
+	key := crypto.GeneratePrivateKey().PublicKey()
+	validator := key.Address()
+	s.putCandidate(key)
+	delegator := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	assert.Nil(t, s.bond(validator, validator, math.MaxUint64))
+	// The bond of the delegator is small, the stake it adds to is not.
+	assert.ErrorIs(t, s.bond(delegator, validator, 1), ErrStakeOverflow)
+	assert.Equal(t, uint64(0), s.Bonded(delegator, validator))
+
+	s.bonds[bondKey{delegator: delegator, validator: validator}] = 1
+	_, err := s.Stake(validator)
+	assert.ErrorIs(t, err, ErrStakeOverflow)
+	_, err = s.validatorSet(0, 0)
+	assert.ErrorIs(t, err, ErrStakeOverflow)
+}
+
+func TestStakingUnbondingRelease(t *testing.T) {
+	alice := crypto.GeneratePrivateKey()
+	genesis := Genesis{
+		Alloc:   map[types.Address]uint64{alice.PublicKey().Address(): 1000},
+		Staking: StakingParams{UnbondingPeriod: 10},
+	}
+	bc, err := NewBlockchainWithOpts(log.NewNopLogger(), randomBlock(t, 0, types.Hash{}), BlockchainOpts{Genesis: genesis})
+	assert.Nil(t, err)
+
+	stake := stakingTransaction(t, alice, 0, StakeTransaction{Amount: 100})
+	assert.Nil(t, bc.handleStaking(stake, 1))
+
+	// The release height saturates instead of wrapping around, which would
+	// pay the stake back right away.
+	unstake := stakingTransaction(t, alice, 1, UnstakeTransaction{Validator: alice.PublicKey(), Amount: 100})
+	assert.Nil(t, bc.handleStaking(unstake, math.MaxUint32-5))
+	assert.Equal(t, []Unbonding{{Delegator: alice.PublicKey().Address(), Amount: 100, Release: math.MaxUint32}}, bc.GetUnbondings(alice.PublicKey().Address()))
+}
@@ -5,7 +5,8 @@ import (
 )
 
 type State struct {
-	data map[string][]byte
+	data    map[string][]byte
+	journal *journal
 }
 
 func NewState() *State {
@@ -15,12 +16,14 @@ func NewState() *State {
 }
 
 func (s *State) Put(k, v []byte) error {
+	s.record(string(k))
 	s.data[string(k)] = v
 
 	return nil
 }
 
 func (s *State) Delete(k []byte) error {
+	s.record(string(k))
 	delete(s.data, string(k))
 
 	return nil
@@ -36,3 +39,17 @@ func (s *State) Get(k []byte) ([]byte, error) {
 
 	return value, nil
 }
+
+func (s *State) record(key string) {
+	if s.journal == nil {
+		return
+	}
+
+	prev, existed := s.data[key]
+	s.journal.append(storageChange{
+		state:   s,
+		key:     key,
+		prev:    prev,
+		existed: existed,
+	})
+}
@@ -0,0 +1,322 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+// Every kind of state lives in its own namespace inside the state trie so
+// keys of different kinds can never collide.
+var (
+	stateNamespaceAccount    = []byte("account")
+	stateNamespaceContract   = []byte("contract")
+	stateNamespaceCollection = []byte("collection")
+	stateNamespaceMint       = []byte("mint")
+	stateNamespaceValidator  = []byte("validator")
+	stateNamespaceCandidate  = []byte("candidate")
+	stateNamespaceBond       = []byte("bond")
+	stateNamespaceUnbonding  = []byte("unbonding")
+	stateNamespaceJail       = []byte("jail")
+	stateNamespaceOffense    = []byte("offense")
+)
+
+// stateRef names an entry of the state by its namespace and its key inside
+// of it.
+type stateRef struct {
+	namespace string
+	key       string
+}
+
+func newStateRef(namespace, key []byte) stateRef {
+	return stateRef{namespace: string(namespace), key: string(key)}
+}
+
+// indexRefs returns the refs of the first n entries of a namespace that is
+// keyed by position.
+func indexRefs(namespace []byte, n int) []stateRef {
+	refs := make([]stateRef, n)
+	for i := range refs {
+		refs[i] = newStateRef(namespace, indexKey(i))
+	}
+
+	return refs
+}
+
+func (r stateRef) trieKey() types.Hash {
+	return stateKey([]byte(r.namespace), []byte(r.key))
+}
+
+func stateKey(namespace, key []byte) types.Hash {
+	buf := make([]byte, 0, len(namespace)+1+len(key))
+	buf = append(buf, namespace...)
+	buf = append(buf, ':')
+	buf = append(buf, key...)
+
+	return sha256.Sum256(buf)
+}
+
+func accountLeaf(account *Account) types.Hash {
+	buf := new(bytes.Buffer)
+	buf.Write(account.Address[:])
+	binary.Write(buf, binary.LittleEndian, account.Balance)
+	binary.Write(buf, binary.LittleEndian, account.Nonce)
+
+	return sha256.Sum256(buf.Bytes())
+}
+
+func bondID(key bondKey) []byte {
+	id := make([]byte, 0, 2*types.ADDRESS_MAX_LENGHT)
+	id = append(id, key.delegator[:]...)
+	return append(id, key.validator[:]...)
+}
+
+func offenseID(o offense) []byte {
+	id := make([]byte, 0, types.ADDRESS_MAX_LENGHT+4)
+	id = append(id, o.validator[:]...)
+	return append(id, indexKey(int(o.height))...)
+}
+
+func bondLeaf(amount uint64) types.Hash {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, amount)
+
+	return sha256.Sum256(buf)
+}
+
+func unbondingLeaf(unbonding *Unbonding) types.Hash {
+	buf := new(bytes.Buffer)
+	buf.Write(unbonding.Delegator[:])
+	binary.Write(buf, binary.LittleEndian, unbonding.Amount)
+	binary.Write(buf, binary.LittleEndian, unbonding.Release)
+
+	return sha256.Sum256(buf.Bytes())
+}
+
+func collectionLeaf(collection *CollectionTransaction) types.Hash {
+	buf := new(bytes.Buffer)
+	writeCollection(buf, collection)
+
+	return sha256.Sum256(buf.Bytes())
+}
+
+func mintLeaf(mint *MintTransaction) types.Hash {
+	buf := new(bytes.Buffer)
+	writeMint(buf, mint)
+
+	return sha256.Sum256(buf.Bytes())
+}
+
+func writeCollection(buf *bytes.Buffer, collection *CollectionTransaction) {
+	binary.Write(buf, binary.LittleEndian, collection.Fee)
+	writeBytes(buf, collection.MetaData)
+}
+
+func writeMint(buf *bytes.Buffer, mint *MintTransaction) {
+	binary.Write(buf, binary.LittleEndian, mint.Fee)
+	buf.Write(mint.NFT[:])
+	buf.Write(mint.Collection[:])
+	writeBytes(buf, mint.MetaData)
+	writeBytes(buf, mint.CollectionOwner)
+	writeSignature(buf, mint.Signature)
+}
+
+// writeBytes writes b prefixed with its length so that consecutive
+// variable length fields can not be shifted into each other.
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(b)))
+	buf.Write(b)
+}
+
+func writeSignature(buf *bytes.Buffer, sig crypto.Signature) {
+	var r, s []byte
+	if sig.R != nil {
+		r = sig.R.Bytes()
+	}
+	if sig.S != nil {
+		s = sig.S.Bytes()
+	}
+	writeBytes(buf, r)
+	writeBytes(buf, s)
+}
+
+// stateLeaves returns the key and value hash of every entry in the state.
+// The caller must hold the stateLock.
+func (bc *Blockchain) stateLeaves() map[types.Hash]types.Hash {
+	leaves := make(map[types.Hash]types.Hash)
+
+	bc.accountState.mu.RLock()
+	for address, account := range bc.accountState.accounts {
+		leaves[stateKey(stateNamespaceAccount, address[:])] = accountLeaf(account)
+	}
+	bc.accountState.mu.RUnlock()
+
+	for key, value := range bc.contractState.data {
+		leaves[stateKey(stateNamespaceContract, []byte(key))] = sha256.Sum256(value)
+	}
+
+	for hash, collection := range bc.collectionState {
+		leaves[stateKey(stateNamespaceCollection, hash[:])] = collectionLeaf(collection)
+	}
+
+	for hash, mint := range bc.mintState {
+		leaves[stateKey(stateNamespaceMint, hash[:])] = mintLeaf(mint)
+	}
+
+	// Validators are keyed by their position, the order of the set matters.
+	for i, validator := range bc.validators {
+		leaves[stateKey(stateNamespaceValidator, indexKey(i))] = sha256.Sum256(validator)
+	}
+
+	for address, key := range bc.stakingState.candidates {
+		leaves[stateKey(stateNamespaceCandidate, address[:])] = sha256.Sum256(key)
+	}
+
+	for key, amount := range bc.stakingState.bonds {
+		leaves[stateKey(stateNamespaceBond, bondID(key))] = bondLeaf(amount)
+	}
+
+	for i := range bc.stakingState.unbondings {
+		leaves[stateKey(stateNamespaceUnbonding, indexKey(i))] = unbondingLeaf(&bc.stakingState.unbondings[i])
+	}
+
+	for validator, release := range bc.stakingState.jailed {
+		leaves[stateKey(stateNamespaceJail, validator[:])] = sha256.Sum256(indexKey(int(release)))
+	}
+
+	for o := range bc.stakingState.offenses {
+		id := offenseID(o)
+		leaves[stateKey(stateNamespaceOffense, id)] = sha256.Sum256(id)
+	}
+
+	return leaves
+}
+
+// stateLeaf returns the value hash of a single entry of the state, the same
+// one stateLeaves has for it, and whether the entry exists. The caller must
+// hold the stateLock.
+func (bc *Blockchain) stateLeaf(ref stateRef) (types.Hash, bool) {
+	key := []byte(ref.key)
+
+	switch ref.namespace {
+	case string(stateNamespaceAccount):
+		bc.accountState.mu.RLock()
+		defer bc.accountState.mu.RUnlock()
+		if account, ok := bc.accountState.accounts[types.AddressFromBytes(key)]; ok {
+			return accountLeaf(account), true
+		}
+	case string(stateNamespaceContract):
+		if value, ok := bc.contractState.data[ref.key]; ok {
+			return sha256.Sum256(value), true
+		}
+	case string(stateNamespaceCollection):
+		if collection, ok := bc.collectionState[types.HashFromBytes(key)]; ok {
+			return collectionLeaf(collection), true
+		}
+	case string(stateNamespaceMint):
+		if mint, ok := bc.mintState[types.HashFromBytes(key)]; ok {
+			return mintLeaf(mint), true
+		}
+	case string(stateNamespaceValidator):
+		if i := int(binary.LittleEndian.Uint32(key)); i < len(bc.validators) {
+			return sha256.Sum256(bc.validators[i]), true
+		}
+	case string(stateNamespaceCandidate):
+		if candidate, ok := bc.stakingState.candidates[types.AddressFromBytes(key)]; ok {
+			return sha256.Sum256(candidate), true
+		}
+	case string(stateNamespaceBond):
+		bk := bondKey{
+			delegator: types.AddressFromBytes(key[:types.ADDRESS_MAX_LENGHT]),
+			validator: types.AddressFromBytes(key[types.ADDRESS_MAX_LENGHT:]),
+		}
+		if amount, ok := bc.stakingState.bonds[bk]; ok {
+			return bondLeaf(amount), true
+		}
+	case string(stateNamespaceUnbonding):
+		if i := int(binary.LittleEndian.Uint32(key)); i < len(bc.stakingState.unbondings) {
+			return unbondingLeaf(&bc.stakingState.unbondings[i]), true
+		}
+	case string(stateNamespaceJail):
+		if release, ok := bc.stakingState.jailed[types.AddressFromBytes(key)]; ok {
+			return sha256.Sum256(indexKey(int(release))), true
+		}
+	case string(stateNamespaceOffense):
+		o := offense{
+			validator: types.AddressFromBytes(key[:types.ADDRESS_MAX_LENGHT]),
+			height:    binary.LittleEndian.Uint32(key[types.ADDRESS_MAX_LENGHT:]),
+		}
+		if _, ok := bc.stakingState.offenses[o]; ok {
+			return sha256.Sum256(key), true
+		}
+	}
+
+	return types.Hash{}, false
+}
+
+func indexKey(i int) []byte {
+	index := make([]byte, 4)
+	binary.LittleEndian.PutUint32(index, uint32(i))
+
+	return index
+}
+
+// stateRoot brings the state trie up to date and returns its root. Only the
+// entries the journal saw change since the last call are looked at, unless
+// the state was replaced as a whole. The caller must hold the stateLock.
+func (bc *Blockchain) stateRoot() types.Hash {
+	touched := bc.journal.takeTouched()
+
+	if !bc.trieSynced {
+		bc.trie.Sync(bc.stateLeaves())
+		bc.trieSynced = true
+		return bc.trie.Root()
+	}
+
+	for ref := range touched {
+		if value, ok := bc.stateLeaf(ref); ok {
+			bc.trie.Update(ref.trieKey(), value)
+		} else {
+			bc.trie.Delete(ref.trieKey())
+		}
+	}
+
+	return bc.trie.Root()
+}
+
+// StateRoot returns the root of the state trie after the last added block.
+func (bc *Blockchain) StateRoot() types.Hash {
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	return bc.stateRoot()
+}
+
+// SetStateRoot executes the given block on top of the current state without
+// committing it and stores the resulting state root in the header of the
+// block, so the block has to be signed afterwards.
+func (bc *Blockchain) SetStateRoot(b *Block) error {
+	if b.Height != bc.Height()+1 {
+		return fmt.Errorf("block with height (%d) is not on top of the chain => current height (%d)", b.Height, bc.Height())
+	}
+
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	start := bc.journal.snapshot()
+	defer bc.journal.revertTo(start)
+
+	if _, err := bc.executeBlockWithoutLock(b); err != nil {
+		return err
+	}
+
+	b.StateRoot = bc.stateRoot()
+	// The header changed so the cached hash is no longer valid.
+	b.hash = types.Hash{}
+
+	return nil
+}
@@ -1,15 +1,85 @@
 package core
 
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
 type Storage interface {
 	Put(*Block) error
+	Get(types.Hash) (*Block, error)
+	Has(types.Hash) bool
+	// Iterate calls fn for every stored block in the order they were put.
+	// Iteration stops at the first error returned by fn.
+	Iterate(fn func(*Block) error) error
+	Close() error
 }
 
-type MemoryStore struct{}
+type MemoryStore struct {
+	lock   sync.RWMutex
+	blocks []*Block
+	lookup map[types.Hash]*Block
+}
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{}
+	return &MemoryStore{
+		blocks: []*Block{},
+		lookup: make(map[types.Hash]*Block),
+	}
 }
 
 func (s *MemoryStore) Put(b *Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hash := b.Hash(BlockHasher{})
+	if _, ok := s.lookup[hash]; ok {
+		return nil
+	}
+
+	s.blocks = append(s.blocks, b)
+	s.lookup[hash] = b
+
+	return nil
+}
+
+func (s *MemoryStore) Get(hash types.Hash) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	b, ok := s.lookup[hash]
+	if !ok {
+		return nil, fmt.Errorf("block with hash (%s) not found in store", hash)
+	}
+
+	return b, nil
+}
+
+func (s *MemoryStore) Has(hash types.Hash) bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.lookup[hash]
+	return ok
+}
+
+func (s *MemoryStore) Iterate(fn func(*Block) error) error {
+	s.lock.RLock()
+	blocks := make([]*Block, len(s.blocks))
+	copy(blocks, s.blocks)
+	s.lock.RUnlock()
+
+	for _, b := range blocks {
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
 	return nil
 }
@@ -1,19 +1,28 @@
 package core
 
 import (
+	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
-	"math/rand"
+	"math"
+	"math/bits"
 
 	"github.com/gabrielluizsf/go-web3/crypto"
 	"github.com/gabrielluizsf/go-web3/types"
 )
 
+var ErrNegativeFee = errors.New("transaction fee can not be negative")
+
 type TransactionType byte
 
 const (
 	TransactionTypeCollection TransactionType = iota // 0x0
 	TransactionTypeMint                              // 0x01
+	TransactionTypeStake                             // 0x02
+	TransactionTypeDelegate                          // 0x03
+	TransactionTypeUnstake                           // 0x04
+	TransactionTypeEvidence                          // 0x05
 )
 
 type CollectionTransaction struct {
@@ -31,7 +40,7 @@ type MintTransaction struct {
 }
 
 type Transaction struct {
-	// Only used for native NFT logic
+	// Only used for native NFT logic, staking and evidence
 	TransactionInner any
 	// Any arbitrary data for the VM
 	Data      []byte
@@ -39,7 +48,18 @@ type Transaction struct {
 	Value     uint64
 	From      crypto.PublicKey
 	Signature *crypto.Signature
-	Nonce     int64
+	// Nonce has to match the nonce of the sending account, it makes sure
+	// every transaction can only be included once.
+	Nonce uint64
+	// Fee is paid by the sender to the validator of the block that
+	// includes the transaction, whether the transaction succeeds or not.
+	Fee uint64
+	// ChainID identifies the network the transaction is meant for.
+	ChainID uint64
+	// GasLimit is the most gas the code in Data may use, GasPrice is what
+	// the sender pays for every unit of it.
+	GasLimit uint64
+	GasPrice uint64
 
 	// cached version of the Transaction data hash
 	hash types.Hash
@@ -47,8 +67,7 @@ type Transaction struct {
 
 func NewTransaction(data []byte) *Transaction {
 	return &Transaction{
-		Data:  data,
-		Nonce: rand.Int63n(1000000000000000),
+		Data: data,
 	}
 }
 
@@ -59,14 +78,36 @@ func (transaction *Transaction) Hash(hasher Hasher[*Transaction]) types.Hash {
 	return transaction.hash
 }
 
-func (transaction *Transaction) Sign(privKey crypto.PrivateKey) error {
+// SigningHash returns the digest the sender signs.
+func (transaction *Transaction) SigningHash() types.Hash {
 	hash := transaction.Hash(TransactionHasher{})
+	return signingHash(transactionSigningDomain, transaction.ChainID, hash[:])
+}
+
+// Size returns the number of bytes the transaction takes up in a block, the
+// size limits of the chain are checked against it.
+func (transaction *Transaction) Size() int {
+	size := len(transactionBytes(transaction))
+	if transaction.Signature != nil {
+		buf := new(bytes.Buffer)
+		writeSignature(buf, *transaction.Signature)
+		size += buf.Len()
+	}
+
+	return size
+}
+
+func (transaction *Transaction) Sign(privKey crypto.PrivateKey) error {
+	// The sender is part of the hash, so it has to be set before hashing.
+	transaction.From = privKey.PublicKey()
+	transaction.hash = types.Hash{}
+
+	hash := transaction.SigningHash()
 	sig, err := privKey.Sign(hash.ToSlice())
 	if err != nil {
 		return err
 	}
 
-	transaction.From = privKey.PublicKey()
 	transaction.Signature = sig
 
 	return nil
@@ -77,14 +118,50 @@ func (transaction *Transaction) Verify() error {
 		return fmt.Errorf("transaction has no signature")
 	}
 
-	hash := transaction.Hash(TransactionHasher{})
+	hash := transaction.SigningHash()
 	if !transaction.Signature.Verify(transaction.From, hash.ToSlice()) {
 		return fmt.Errorf("invalid transaction signature")
 	}
 
+	if _, err := transaction.TotalFee(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// TotalFee returns the most the sender pays for the transaction, that is
+// the fee of the transaction plus the fee of its native NFT part plus the
+// price of all of its gas. Gas that is not used is refunded.
+func (transaction *Transaction) TotalFee() (uint64, error) {
+	var inner int64
+	switch t := transaction.TransactionInner.(type) {
+	case CollectionTransaction:
+		inner = t.Fee
+	case MintTransaction:
+		inner = t.Fee
+	}
+
+	if inner < 0 {
+		return 0, ErrNegativeFee
+	}
+
+	hi, gas := bits.Mul64(transaction.GasLimit, transaction.GasPrice)
+	if hi != 0 {
+		return 0, fmt.Errorf("transaction gas price overflows")
+	}
+
+	fee := transaction.Fee
+	for _, amount := range []uint64{uint64(inner), gas} {
+		if fee > math.MaxUint64-amount {
+			return 0, fmt.Errorf("transaction fee overflows")
+		}
+		fee += amount
+	}
+
+	return fee, nil
+}
+
 func (transaction *Transaction) Decode(dec Decoder[*Transaction]) error {
 	return dec.Decode(transaction)
 }
@@ -96,4 +173,8 @@ func (transaction *Transaction) Encode(enc Encoder[*Transaction]) error {
 func init() {
 	gob.Register(CollectionTransaction{})
 	gob.Register(MintTransaction{})
+	gob.Register(StakeTransaction{})
+	gob.Register(DelegateTransaction{})
+	gob.Register(UnstakeTransaction{})
+	gob.Register(DoubleSignEvidence{})
 }
@@ -49,6 +49,25 @@ func TestNFTTransaction(t *testing.T) {
 	assert.Equal(t, transaction, transactionDecoded)
 }
 
+func TestVerifyNFTTransactionWithTamper(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	transaction := &Transaction{
+		TransactionInner: CollectionTransaction{
+			Fee:      200,
+			MetaData: []byte("The beginning of a new collection"),
+		},
+	}
+	assert.Nil(t, transaction.Sign(privKey))
+	transaction.hash = types.Hash{}
+
+	transaction.TransactionInner = CollectionTransaction{
+		Fee:      200,
+		MetaData: []byte("Something else"),
+	}
+
+	assert.NotNil(t, transaction.Verify())
+}
+
 func TestNativeTransferTransaction(t *testing.T) {
 	fromPrivKey := crypto.GeneratePrivateKey()
 	toPrivKey := crypto.GeneratePrivateKey()
@@ -85,6 +104,35 @@ func TestVerifyTransaction(t *testing.T) {
 	assert.NotNil(t, transaction.Verify())
 }
 
+func TestVerifyTransactionChainID(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	transaction := &Transaction{
+		Data:    []byte("foo"),
+		ChainID: 1,
+	}
+
+	assert.Nil(t, transaction.Sign(privKey))
+	assert.Nil(t, transaction.Verify())
+
+	// A signature for one chain can not be replayed on another.
+	transaction.ChainID = 2
+	assert.NotNil(t, transaction.Verify())
+}
+
+func TestVerifyTransactionShiftedData(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	to := crypto.GeneratePrivateKey().PublicKey()
+	transaction := &Transaction{
+		Data: append([]byte{0x01}, to...),
+	}
+	assert.Nil(t, transaction.Sign(privKey))
+
+	// Moving the tail of the data into the recipient breaks the signature.
+	transaction.Data, transaction.To = transaction.Data[:1], to
+	transaction.hash = types.Hash{}
+	assert.NotNil(t, transaction.Verify())
+}
+
 func TestTransactionEncodeDecode(t *testing.T) {
 	transaction := randomTransactionWithSignature(t)
 	buf := &bytes.Buffer{}
@@ -99,7 +147,8 @@ func TestTransactionEncodeDecode(t *testing.T) {
 func randomTransactionWithSignature(t *testing.T) *Transaction {
 	privKey := crypto.GeneratePrivateKey()
 	transaction := Transaction{
-		Data: []byte("foo"),
+		Data:     []byte("FOO"),
+		GasLimit: 10,
 	}
 	assert.Nil(t, transaction.Sign(privKey))
 
@@ -0,0 +1,243 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"io"
+	"sort"
+
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+const (
+	trieLeafPrefix byte = 0x00
+	trieNodePrefix byte = 0x01
+)
+
+// SparseMerkleTree is a compact binary Merkle tree over 256 bit keys.
+//
+// A key addresses a leaf by its bits, starting with the most significant
+// one. Empty subtrees hash to the zero hash and a subtree holding a single
+// leaf collapses into that leaf, so the root only depends on the set of
+// (key, value hash) pairs and not on the order they were written in:
+//
+//	leaf  = sha256(0x00 || key || valueHash)
+//	node  = sha256(0x01 || left || right)
+//	empty = 0x00..00
+//
+// The nodes keep their hashes, an update only hashes the nodes on the path
+// to the changed leaf again the next time the root is asked for.
+type SparseMerkleTree struct {
+	leaves map[types.Hash]types.Hash
+	root   *trieNode
+}
+
+// trieNode is a leaf when it has no children. An inner node always holds at
+// least two leaves below it, a single leaf is moved up to where its
+// subtree starts.
+type trieNode struct {
+	left, right *trieNode
+	key, value  types.Hash
+	hash        types.Hash
+	dirty       bool
+}
+
+func (n *trieNode) isLeaf() bool {
+	return n.left == nil && n.right == nil
+}
+
+func NewSparseMerkleTree() *SparseMerkleTree {
+	return &SparseMerkleTree{
+		leaves: make(map[types.Hash]types.Hash),
+	}
+}
+
+func (t *SparseMerkleTree) Get(key types.Hash) (types.Hash, bool) {
+	value, ok := t.leaves[key]
+	return value, ok
+}
+
+func (t *SparseMerkleTree) Update(key, valueHash types.Hash) {
+	if current, ok := t.leaves[key]; ok && current == valueHash {
+		return
+	}
+
+	t.leaves[key] = valueHash
+	t.root = trieInsert(t.root, 0, key, valueHash)
+}
+
+func (t *SparseMerkleTree) Delete(key types.Hash) {
+	if _, ok := t.leaves[key]; !ok {
+		return
+	}
+
+	delete(t.leaves, key)
+	t.root = trieRemove(t.root, 0, key)
+}
+
+// Sync makes the tree hold exactly the given leaves. Every leaf is compared,
+// which is linear in the size of the tree, but only the paths of the leaves
+// that changed are hashed again.
+func (t *SparseMerkleTree) Sync(leaves map[types.Hash]types.Hash) {
+	for key := range t.leaves {
+		if _, ok := leaves[key]; !ok {
+			t.Delete(key)
+		}
+	}
+
+	for key, value := range leaves {
+		t.Update(key, value)
+	}
+}
+
+func (t *SparseMerkleTree) Len() int {
+	return len(t.leaves)
+}
+
+// Root returns the root hash, hashing the nodes that changed since the last
+// call.
+func (t *SparseMerkleTree) Root() types.Hash {
+	return trieHash(t.root)
+}
+
+// trieInsert puts the leaf into the subtree at the given depth and returns
+// the new root of the subtree.
+func trieInsert(n *trieNode, depth int, key, value types.Hash) *trieNode {
+	if n == nil {
+		return &trieNode{key: key, value: value, dirty: true}
+	}
+
+	if n.isLeaf() {
+		if n.key == key {
+			n.value = value
+			n.dirty = true
+			return n
+		}
+
+		// The leaf moves down next to the new one, below inner nodes
+		// for every bit the two keys share.
+		inner := &trieNode{dirty: true}
+		if keyBit(n.key, depth) == 0 {
+			inner.left = n
+		} else {
+			inner.right = n
+		}
+		n = inner
+	}
+
+	n.dirty = true
+	if keyBit(key, depth) == 0 {
+		n.left = trieInsert(n.left, depth+1, key, value)
+	} else {
+		n.right = trieInsert(n.right, depth+1, key, value)
+	}
+
+	return n
+}
+
+// trieRemove deletes the leaf from the subtree at the given depth and returns
+// the new root of the subtree.
+func trieRemove(n *trieNode, depth int, key types.Hash) *trieNode {
+	if n == nil {
+		return nil
+	}
+
+	if n.isLeaf() {
+		if n.key == key {
+			return nil
+		}
+		return n
+	}
+
+	n.dirty = true
+	if keyBit(key, depth) == 0 {
+		n.left = trieRemove(n.left, depth+1, key)
+	} else {
+		n.right = trieRemove(n.right, depth+1, key)
+	}
+
+	// A single leaf left below the node takes its place.
+	switch {
+	case n.left == nil && n.right.isLeaf():
+		return n.right
+	case n.right == nil && n.left.isLeaf():
+		return n.left
+	}
+
+	return n
+}
+
+func trieHash(n *trieNode) types.Hash {
+	if n == nil {
+		return types.Hash{}
+	}
+	if !n.dirty {
+		return n.hash
+	}
+
+	if n.isLeaf() {
+		n.hash = trieLeafHash(n.key, n.value)
+	} else {
+		n.hash = trieNodeHash(trieHash(n.left), trieHash(n.right))
+	}
+	n.dirty = false
+
+	return n.hash
+}
+
+type trieLeaf struct {
+	Key   types.Hash
+	Value types.Hash
+}
+
+// Encode writes all leaves of the tree sorted by key, so the same tree
+// always encodes to the same bytes.
+func (t *SparseMerkleTree) Encode(w io.Writer) error {
+	leaves := make([]trieLeaf, 0, len(t.leaves))
+	for key, value := range t.leaves {
+		leaves = append(leaves, trieLeaf{Key: key, Value: value})
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return bytes.Compare(leaves[i].Key[:], leaves[j].Key[:]) < 0
+	})
+
+	return gob.NewEncoder(w).Encode(leaves)
+}
+
+func (t *SparseMerkleTree) Decode(r io.Reader) error {
+	leaves := []trieLeaf{}
+	if err := gob.NewDecoder(r).Decode(&leaves); err != nil {
+		return err
+	}
+
+	t.leaves = make(map[types.Hash]types.Hash, len(leaves))
+	t.root = nil
+	for _, leaf := range leaves {
+		t.Update(leaf.Key, leaf.Value)
+	}
+
+	return nil
+}
+
+func keyBit(key types.Hash, depth int) byte {
+	return (key[depth/8] >> (7 - uint(depth%8))) & 1
+}
+
+func trieLeafHash(key, valueHash types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+2*types.HASH_LENGHT)
+	buf = append(buf, trieLeafPrefix)
+	buf = append(buf, key[:]...)
+	buf = append(buf, valueHash[:]...)
+
+	return sha256.Sum256(buf)
+}
+
+func trieNodeHash(left, right types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+2*types.HASH_LENGHT)
+	buf = append(buf, trieNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+
+	return sha256.Sum256(buf)
+}
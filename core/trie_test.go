@@ -0,0 +1,184 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSparseMerkleTreeEmpty(t *testing.T) {
+	tree := NewSparseMerkleTree()
+	assert.Equal(t, types.Hash{}, tree.Root())
+}
+
+func TestSparseMerkleTreeSingleLeaf(t *testing.T) {
+	tree := NewSparseMerkleTree()
+	key := types.Hash(sha256.Sum256([]byte("foo")))
+	value := types.Hash(sha256.Sum256([]byte("bar")))
+
+	tree.Update(key, value)
+	assert.Equal(t, trieLeafHash(key, value), tree.Root())
+
+	fetched, ok := tree.Get(key)
+	assert.True(t, ok)
+	assert.Equal(t, value, fetched)
+}
+
+func TestSparseMerkleTreeOrderIndependent(t *testing.T) {
+	a := NewSparseMerkleTree()
+	b := NewSparseMerkleTree()
+
+	keys := []types.Hash{}
+	for i := 0; i < 100; i++ {
+		keys = append(keys, sha256.Sum256([]byte{byte(i)}))
+	}
+
+	for i := 0; i < len(keys); i++ {
+		a.Update(keys[i], keys[i])
+		b.Update(keys[len(keys)-1-i], keys[len(keys)-1-i])
+	}
+
+	assert.Equal(t, a.Root(), b.Root())
+	assert.False(t, a.Root().IsZero())
+}
+
+func TestSparseMerkleTreeUpdateDelete(t *testing.T) {
+	tree := NewSparseMerkleTree()
+	first := types.Hash(sha256.Sum256([]byte("first")))
+	second := types.Hash(sha256.Sum256([]byte("second")))
+
+	tree.Update(first, first)
+	rootOne := tree.Root()
+
+	tree.Update(second, second)
+	rootTwo := tree.Root()
+	assert.NotEqual(t, rootOne, rootTwo)
+
+	tree.Update(second, first)
+	assert.NotEqual(t, rootTwo, tree.Root())
+
+	tree.Delete(second)
+	assert.Equal(t, rootOne, tree.Root())
+
+	tree.Delete(first)
+	assert.Equal(t, types.Hash{}, tree.Root())
+}
+
+// referenceRoot hashes the leaves from scratch, splitting the sorted keys by
+// their bit at every depth.
+func referenceRoot(leaves map[types.Hash]types.Hash) types.Hash {
+	keys := make([]types.Hash, 0, len(leaves))
+	for key := range leaves {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+
+	var subtree func(keys []types.Hash, depth int) types.Hash
+	subtree = func(keys []types.Hash, depth int) types.Hash {
+		switch len(keys) {
+		case 0:
+			return types.Hash{}
+		case 1:
+			return trieLeafHash(keys[0], leaves[keys[0]])
+		}
+
+		split := sort.Search(len(keys), func(i int) bool {
+			return keyBit(keys[i], depth) == 1
+		})
+
+		return trieNodeHash(subtree(keys[:split], depth+1), subtree(keys[split:], depth+1))
+	}
+
+	return subtree(keys, 0)
+}
+
+// assertStateRoot checks the state root the chain keeps up to date from the
+// journal against one built from all of its state.
+func assertStateRoot(t *testing.T, bc *Blockchain) {
+	t.Helper()
+
+	bc.stateLock.Lock()
+	defer bc.stateLock.Unlock()
+
+	assert.Equal(t, referenceRoot(bc.stateLeaves()), bc.stateRoot())
+}
+
+func TestSparseMerkleTreeIncremental(t *testing.T) {
+	var (
+		r      = rand.New(rand.NewSource(1))
+		tree   = NewSparseMerkleTree()
+		leaves = make(map[types.Hash]types.Hash)
+		keys   = []types.Hash{}
+	)
+
+	for i := 0; i < 300; i++ {
+		var key types.Hash
+		r.Read(key[:])
+		// Keys that share a long prefix need a chain of inner nodes.
+		if i%10 == 0 && len(keys) > 0 {
+			key = keys[r.Intn(len(keys))]
+			key[31] ^= 1
+		}
+		keys = append(keys, key)
+	}
+
+	for i := 0; i < 2000; i++ {
+		key := keys[r.Intn(len(keys))]
+		if r.Intn(3) == 0 {
+			tree.Delete(key)
+			delete(leaves, key)
+		} else {
+			value := types.Hash{byte(r.Intn(4))}
+			tree.Update(key, value)
+			leaves[key] = value
+		}
+
+		if i%50 == 0 {
+			assert.Equal(t, referenceRoot(leaves), tree.Root())
+		}
+	}
+	assert.Equal(t, referenceRoot(leaves), tree.Root())
+
+	for key := range leaves {
+		tree.Delete(key)
+	}
+	assert.Equal(t, types.Hash{}, tree.Root())
+}
+
+func TestSparseMerkleTreeSync(t *testing.T) {
+	tree := NewSparseMerkleTree()
+	first := types.Hash(sha256.Sum256([]byte("first")))
+	second := types.Hash(sha256.Sum256([]byte("second")))
+
+	tree.Update(first, first)
+	tree.Sync(map[types.Hash]types.Hash{second: second})
+
+	expected := NewSparseMerkleTree()
+	expected.Update(second, second)
+
+	assert.Equal(t, 1, tree.Len())
+	assert.Equal(t, expected.Root(), tree.Root())
+}
+
+func TestSparseMerkleTreeEncodeDecode(t *testing.T) {
+	tree := NewSparseMerkleTree()
+	for i := 0; i < 10; i++ {
+		key := types.Hash(sha256.Sum256([]byte{byte(i)}))
+		tree.Update(key, key)
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tree.Encode(buf))
+
+	decoded := NewSparseMerkleTree()
+	assert.Nil(t, decoded.Decode(buf))
+	assert.Equal(t, tree.Root(), decoded.Root())
+	assert.Equal(t, tree.Len(), decoded.Len())
+}
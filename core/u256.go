@@ -0,0 +1,179 @@
+package core
+
+import (
+	"encoding/binary"
+	"math/big"
+	"math/bits"
+)
+
+// U256 is an unsigned 256 bit integer, the integer type of the VM. The words
+// are in little endian order. Arithmetic wraps around, the methods report
+// whether it did.
+type U256 [4]uint64
+
+func NewU256(v uint64) U256 {
+	return U256{v}
+}
+
+// U256FromInt64 returns the two's complement of v, so -1 is the largest
+// U256.
+func U256FromInt64(v int64) U256 {
+	if v >= 0 {
+		return U256{uint64(v)}
+	}
+
+	max := uint64(1<<64 - 1)
+	return U256{uint64(v), max, max, max}
+}
+
+// U256FromBytes interprets b as a big endian number, b can be at most 32
+// bytes long.
+func U256FromBytes(b []byte) U256 {
+	var buf [32]byte
+	copy(buf[32-len(b):], b)
+
+	var x U256
+	for i := range x {
+		x[i] = binary.BigEndian.Uint64(buf[24-8*i:])
+	}
+
+	return x
+}
+
+// Bytes32 returns x as 32 big endian bytes.
+func (x U256) Bytes32() [32]byte {
+	var buf [32]byte
+	for i, word := range x {
+		binary.BigEndian.PutUint64(buf[24-8*i:], word)
+	}
+
+	return buf
+}
+
+func (x U256) IsZero() bool {
+	return x == U256{}
+}
+
+// Uint64 returns x when it fits into an uint64.
+func (x U256) Uint64() (uint64, bool) {
+	return x[0], x[1] == 0 && x[2] == 0 && x[3] == 0
+}
+
+// Cmp returns -1, 0 or 1 when x is less than, equal to or greater than y.
+func (x U256) Cmp(y U256) int {
+	for i := len(x) - 1; i >= 0; i-- {
+		switch {
+		case x[i] < y[i]:
+			return -1
+		case x[i] > y[i]:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func (x U256) BitLen() int {
+	for i := len(x) - 1; i >= 0; i-- {
+		if x[i] != 0 {
+			return i*64 + bits.Len64(x[i])
+		}
+	}
+
+	return 0
+}
+
+// Add returns x + y and whether it overflowed.
+func (x U256) Add(y U256) (U256, bool) {
+	var (
+		z     U256
+		carry uint64
+	)
+	for i := range z {
+		z[i], carry = bits.Add64(x[i], y[i], carry)
+	}
+
+	return z, carry != 0
+}
+
+// Sub returns x - y and whether it underflowed.
+func (x U256) Sub(y U256) (U256, bool) {
+	var (
+		z      U256
+		borrow uint64
+	)
+	for i := range z {
+		z[i], borrow = bits.Sub64(x[i], y[i], borrow)
+	}
+
+	return z, borrow != 0
+}
+
+// Mul returns x * y and whether it overflowed.
+func (x U256) Mul(y U256) (U256, bool) {
+	var product [8]uint64
+	for i := range x {
+		var carry uint64
+		for j := range y {
+			hi, lo := bits.Mul64(x[i], y[j])
+			lo, c := bits.Add64(lo, product[i+j], 0)
+			hi += c
+			lo, c = bits.Add64(lo, carry, 0)
+			hi += c
+			product[i+j] = lo
+			carry = hi
+		}
+		product[i+len(y)] = carry
+	}
+
+	z := U256{product[0], product[1], product[2], product[3]}
+	return z, product[4]|product[5]|product[6]|product[7] != 0
+}
+
+// DivMod returns the quotient and the remainder of x / y, y must not be
+// zero.
+func (x U256) DivMod(y U256) (U256, U256) {
+	q, r := new(big.Int).QuoRem(x.Big(), y.Big(), new(big.Int))
+	return u256FromBig(q), u256FromBig(r)
+}
+
+// Exp returns x to the power of y and whether it overflowed.
+func (x U256) Exp(y U256) (U256, bool) {
+	var (
+		result       = NewU256(1)
+		base         = x
+		overflow     bool
+		baseOverflow bool
+		o            bool
+	)
+
+	n := y.BitLen()
+	for i := 0; i < n; i++ {
+		if y[i/64]&(1<<(i%64)) != 0 {
+			// result is at least 1, so a base that overflowed makes the
+			// result overflow as well.
+			result, o = result.Mul(base)
+			overflow = overflow || o || baseOverflow
+		}
+		if i < n-1 {
+			base, o = base.Mul(base)
+			baseOverflow = baseOverflow || o
+		}
+	}
+
+	return result, overflow
+}
+
+func (x U256) Big() *big.Int {
+	b := x.Bytes32()
+	return new(big.Int).SetBytes(b[:])
+}
+
+// u256FromBig returns v, which has to fit into 256 bits.
+func u256FromBig(v *big.Int) U256 {
+	return U256FromBytes(v.Bytes())
+}
+
+func (x U256) String() string {
+	return x.Big().String()
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var maxU256 = U256FromInt64(-1)
+
+func randomU256(r *rand.Rand) U256 {
+	var x U256
+	// Small numbers make carries between the words more likely to matter.
+	for i := 0; i < r.Intn(len(x))+1; i++ {
+		x[i] = r.Uint64()
+	}
+
+	return x
+}
+
+func TestU256Bytes(t *testing.T) {
+	x := U256FromBytes([]byte{0x01, 0x02})
+	assert.Equal(t, NewU256(0x0102), x)
+
+	b := maxU256.Bytes32()
+	assert.Equal(t, maxU256, U256FromBytes(b[:]))
+	assert.Equal(t, "115792089237316195423570985008687907853269984665640564039457584007913129639935", maxU256.String())
+
+	v, ok := NewU256(7).Uint64()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(7), v)
+	_, ok = U256{0, 1}.Uint64()
+	assert.False(t, ok)
+}
+
+func TestU256Arithmetic(t *testing.T) {
+	var (
+		r       = rand.New(rand.NewSource(1))
+		modulus = new(big.Int).Lsh(big.NewInt(1), 256)
+	)
+
+	check := func(z U256, overflow bool, expected *big.Int) {
+		t.Helper()
+		assert.Equal(t, expected.Sign() < 0 || expected.Cmp(modulus) >= 0, overflow)
+		assert.Equal(t, new(big.Int).Mod(expected, modulus).String(), z.String())
+	}
+
+	for i := 0; i < 1000; i++ {
+		x, y := randomU256(r), randomU256(r)
+
+		z, overflow := x.Add(y)
+		check(z, overflow, new(big.Int).Add(x.Big(), y.Big()))
+
+		z, overflow = x.Sub(y)
+		check(z, overflow, new(big.Int).Sub(x.Big(), y.Big()))
+
+		z, overflow = x.Mul(y)
+		check(z, overflow, new(big.Int).Mul(x.Big(), y.Big()))
+
+		assert.Equal(t, x.Big().Cmp(y.Big()), x.Cmp(y))
+		assert.Equal(t, x.Big().BitLen(), x.BitLen())
+
+		if !y.IsZero() {
+			q, m := x.DivMod(y)
+			assert.Equal(t, new(big.Int).Quo(x.Big(), y.Big()).String(), q.String())
+			assert.Equal(t, new(big.Int).Rem(x.Big(), y.Big()).String(), m.String())
+		}
+
+		e := NewU256(uint64(r.Intn(300)))
+		z, overflow = x.Exp(e)
+		check(z, overflow, new(big.Int).Exp(x.Big(), e.Big(), nil))
+	}
+}
+
+func TestU256Exp(t *testing.T) {
+	tests := []struct {
+		x, y     U256
+		z        U256
+		overflow bool
+	}{
+		{x: U256{}, y: U256{}, z: NewU256(1)},
+		{x: U256{}, y: maxU256, z: U256{}},
+		{x: NewU256(1), y: maxU256, z: NewU256(1)},
+		{x: NewU256(2), y: NewU256(255), z: U256{0, 0, 0, 1 << 63}},
+		{x: NewU256(2), y: NewU256(256), z: U256{}, overflow: true},
+		{x: NewU256(10), y: NewU256(18), z: NewU256(1_000_000_000_000_000_000)},
+	}
+
+	for _, test := range tests {
+		z, overflow := test.x.Exp(test.y)
+		assert.Equal(t, test.z, z)
+		assert.Equal(t, test.overflow, overflow)
+	}
+}
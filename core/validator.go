@@ -3,12 +3,23 @@ package core
 import (
 	"errors"
 	"fmt"
+
+	"github.com/gabrielluizsf/go-web3/types"
 )
 
-var ErrBlockKnown = errors.New("block already known")
+var (
+	ErrBlockKnown   = errors.New("block already known")
+	ErrInvalidNonce = errors.New("invalid transaction nonce")
+	// ErrInsufficientFee is returned when the sender of a transaction can
+	// not pay its fee, which makes the whole block invalid.
+	ErrInsufficientFee = errors.New("insufficient balance to pay the transaction fee")
+)
 
 type Validator interface {
 	ValidateBlock(*Block) error
+	// ValidateStateRoot is called after the block has been executed with
+	// the root of the resulting state.
+	ValidateStateRoot(*Block, types.Hash) error
 }
 
 type BlockValidator struct {
@@ -20,27 +31,70 @@ func NewBlockValidator(bc *Blockchain) *BlockValidator {
 }
 
 func (v *BlockValidator) ValidateBlock(b *Block) error {
-	if v.bc.HasBlock(b.Height) {
+	hash := b.Hash(BlockHasher{})
+	if v.bc.HasBlockHash(hash) {
 		return ErrBlockKnown
 	}
 
-	if b.Height != v.bc.Height()+1 {
-		return fmt.Errorf("block (%s) with height (%d) is too high => current height (%d)", b.Hash(BlockHasher{}), b.Height, v.bc.Height())
+	if b.ChainID != v.bc.ChainID() {
+		return fmt.Errorf("block (%s) has chain id (%d) expected (%d)", hash, b.ChainID, v.bc.ChainID())
 	}
 
-	prevHeader, err := v.bc.GetHeader(b.Height - 1)
+	// The block may extend any known block, the fork choice decides
+	// whether it ends up on the canonical chain.
+	prevBlock, err := v.bc.GetBlockByHash(b.PrevBlockHash)
 	if err != nil {
-		return err
+		return fmt.Errorf("the previous block (%s) of block (%s) is unknown", b.PrevBlockHash, hash)
 	}
 
-	hash := BlockHasher{}.Hash(prevHeader)
-	if hash != b.PrevBlockHash {
-		return fmt.Errorf("the hash of the previous block (%s) is invalid", b.PrevBlockHash)
+	if b.Height != prevBlock.Height+1 {
+		return fmt.Errorf("block (%s) with height (%d) does not follow its previous block with height (%d)", hash, b.Height, prevBlock.Height)
+	}
+
+	if err := v.bc.validateTimestamp(b, prevBlock); err != nil {
+		return err
 	}
 
 	if err := b.Verify(); err != nil {
 		return err
 	}
 
+	if err := v.bc.validateLimits(b); err != nil {
+		return err
+	}
+
+	engine := v.bc.Engine()
+	if err := engine.VerifyHeader(v.bc, b.Header, prevBlock.Header); err != nil {
+		return err
+	}
+
+	// The signature is valid, so the validator really signed the block.
+	// The seal is checked against the validator set of the branch of the
+	// block. When the parent was never executed that set is not known yet
+	// and the seal is checked once a reorg connects the block.
+	if chain, ok := v.bc.branchReader(b.PrevBlockHash); ok {
+		if err := engine.VerifySeal(chain, b, prevBlock.Header); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateLimits checks that the transactions of the block fit into the
+// limits of the consensus params of the chain.
+func (bc *Blockchain) validateLimits(b *Block) error {
+	if err := bc.ConsensusParams().ValidateTransactions(b.Transactions); err != nil {
+		return fmt.Errorf("block (%s) exceeds the limits of the chain: %w", b.Hash(BlockHasher{}), err)
+	}
+
+	return nil
+}
+
+func (v *BlockValidator) ValidateStateRoot(b *Block, root types.Hash) error {
+	if b.StateRoot != root {
+		return fmt.Errorf("block (%s) has state root (%s) but execution resulted in (%s)", b.Hash(BlockHasher{}), b.StateRoot, root)
+	}
+
 	return nil
 }
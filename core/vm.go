@@ -2,6 +2,8 @@ package core
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 )
 
 type Instruction byte
@@ -13,8 +15,99 @@ const (
 	InstrPack     Instruction = 0x0d
 	InstrSub      Instruction = 0x0e
 	InstrStore    Instruction = 0x0f
+	InstrJump     Instruction = 0x10
+	InstrJumpI    Instruction = 0x11
+	InstrEq       Instruction = 0x12
+	InstrLt       Instruction = 0x13
+	InstrGt       Instruction = 0x14
+	InstrNot      Instruction = 0x15
+	InstrAnd      Instruction = 0x16
+	InstrOr       Instruction = 0x17
+	InstrHalt     Instruction = 0x18
+	InstrRevert   Instruction = 0x19
+	InstrLoad     Instruction = 0x1a
+	InstrDel      Instruction = 0x1b
+	InstrMul      Instruction = 0x1c
+	InstrDiv      Instruction = 0x1d
+	InstrMod      Instruction = 0x1e
+	InstrExp      Instruction = 0x1f
+	// The safe variants fail with ErrIntegerOverflow instead of wrapping
+	// around.
+	InstrSafeAdd Instruction = 0x20
+	InstrSafeSub Instruction = 0x21
+	InstrSafeMul Instruction = 0x22
+	InstrSafeExp Instruction = 0x23
+	// InstrPush1 to InstrPush32 push the big endian number in the 1 to 32
+	// bytes that follow them. Unlike the value of the other pushes it
+	// comes after the instruction, otherwise its length would be unknown.
+	InstrPush1  Instruction = 0x60
+	InstrPush32 Instruction = 0x7f
 )
 
+// Gas every instruction costs. PACK costs GasPackByte for every packed byte
+// on top of GasStep.
+const (
+	GasStep     uint64 = 1
+	GasJump     uint64 = 2
+	GasMul      uint64 = 3
+	GasLoad     uint64 = 5
+	GasExp      uint64 = 10
+	GasStore    uint64 = 20
+	GasPackByte uint64 = 1
+)
+
+func (instr Instruction) gas() uint64 {
+	switch instr {
+	case InstrJump, InstrJumpI:
+		return GasJump
+	case InstrMul, InstrDiv, InstrMod, InstrSafeMul:
+		return GasMul
+	case InstrExp, InstrSafeExp:
+		return GasExp
+	case InstrLoad:
+		return GasLoad
+	case InstrStore, InstrDel:
+		return GasStore
+	default:
+		return GasStep
+	}
+}
+
+// isPush reports whether the value of the instruction comes right before
+// it.
+func (instr Instruction) isPush() bool {
+	return instr == InstrPushInt || instr == InstrPushByte
+}
+
+// immediate returns the number of value bytes that follow the instruction.
+func (instr Instruction) immediate() int {
+	if instr >= InstrPush1 && instr <= InstrPush32 {
+		return int(instr-InstrPush1) + 1
+	}
+
+	return 0
+}
+
+var (
+	ErrStackOverflow  = errors.New("stack overflow")
+	ErrStackUnderflow = errors.New("stack underflow")
+	// ErrInvalidOperand is returned when a value on the stack has the wrong
+	// type for the instruction.
+	ErrInvalidOperand = errors.New("invalid operand")
+	ErrInvalidJump    = errors.New("invalid jump destination")
+	// ErrOutOfGas is returned when the code needs more gas than it was
+	// given, all of its gas is used up.
+	ErrOutOfGas = errors.New("out of gas")
+	// ErrReverted is returned when the code reverts, none of its storage
+	// writes are kept.
+	ErrReverted = errors.New("execution reverted")
+	// ErrInvalidValue is returned when a stored value can not be loaded.
+	ErrInvalidValue    = errors.New("invalid stored value")
+	ErrIntegerOverflow = errors.New("integer overflow")
+	ErrDivisionByZero  = errors.New("division by zero")
+)
+
+// Stack is the last in, first out stack of the VM with a fixed capacity.
 type Stack struct {
 	data []any
 	sp   int
@@ -27,17 +120,48 @@ func NewStack(size int) *Stack {
 	}
 }
 
-func (s *Stack) Push(v any) {
+func (s *Stack) Push(v any) error {
+	if s.sp == len(s.data) {
+		return ErrStackOverflow
+	}
+
 	s.data[s.sp] = v
 	s.sp++
+
+	return nil
 }
 
-func (s *Stack) Pop() any {
-	value := s.data[0]
-	s.data = append(s.data[:0], s.data[1:]...)
+// Pop removes and returns the value that was pushed last.
+func (s *Stack) Pop() (any, error) {
+	if s.sp == 0 {
+		return nil, ErrStackUnderflow
+	}
+
 	s.sp--
+	value := s.data[s.sp]
+	s.data[s.sp] = nil
+
+	return value, nil
+}
+
+func (s *Stack) Len() int {
+	return s.sp
+}
 
-	return value
+// pop removes the top value of the stack, which has to be a T.
+func pop[T any](s *Stack) (T, error) {
+	var zero T
+	value, err := s.Pop()
+	if err != nil {
+		return zero, err
+	}
+
+	v, ok := value.(T)
+	if !ok {
+		return zero, fmt.Errorf("%w: expected %T got %T", ErrInvalidOperand, zero, value)
+	}
+
+	return v, nil
 }
 
 type VM struct {
@@ -45,85 +169,426 @@ type VM struct {
 	ip            int // instruction pointer
 	stack         *Stack
 	contractState *State
+	// jumpdests marks the positions instructions start at.
+	jumpdests []bool
+	halted    bool
+	gasLimit  uint64
+	gas       uint64 // gas left
 }
 
-func NewVM(data []byte, contractState *State) *VM {
+// NewVM returns a VM that runs the code with the given amount of gas.
+func NewVM(data []byte, contractState *State, gasLimit uint64) *VM {
 	return &VM{
 		contractState: contractState,
 		data:          data,
 		ip:            0,
 		stack:         NewStack(128),
+		jumpdests:     jumpdests(data),
+		gasLimit:      gasLimit,
+		gas:           gasLimit,
+	}
+}
+
+// GasUsed returns the gas the code used so far.
+func (vm *VM) GasUsed() uint64 {
+	return vm.gasLimit - vm.gas
+}
+
+func (vm *VM) useGas(gas uint64) error {
+	if gas > vm.gas {
+		vm.gas = 0
+		return ErrOutOfGas
+	}
+
+	vm.gas -= gas
+
+	return nil
+}
+
+// jumpdests returns for every position of the code whether an instruction
+// starts there. A push starts at its value, so a jump can not skip it, and
+// the value of PUSH1 to PUSH32 is never executed.
+func jumpdests(data []byte) []bool {
+	dests := make([]bool, len(data))
+	for i := 0; i < len(data); i++ {
+		dests[i] = true
+		if hasPushValue(data, i) {
+			i++
+		} else {
+			i += Instruction(data[i]).immediate()
+		}
 	}
+
+	return dests
 }
 
+// hasPushValue reports whether the byte at i is the value of the push that
+// follows it. This takes precedence over PUSH1 to PUSH32, so their value
+// can not start with the byte of PUSHINT or PUSHBYTE, a wider push with a
+// leading zero byte has to be used instead.
+func hasPushValue(data []byte, i int) bool {
+	return i+1 < len(data) && Instruction(data[i+1]).isPush()
+}
+
+// Run executes the code until it ends, halts or runs out of gas. When it
+// fails or reverts all storage writes of the code are rolled back.
 func (vm *VM) Run() error {
-	for {
-		instr := Instruction(vm.data[vm.ip])
+	journal := vm.contractState.journal
+	if journal == nil {
+		journal = newJournal()
+		vm.contractState.journal = journal
+		defer func() { vm.contractState.journal = nil }()
+	}
+	start := journal.snapshot()
 
-		if err := vm.Exec(instr); err != nil {
+	for vm.ip < len(vm.data) && !vm.halted {
+		// The value of a push comes right before it, it is not executed.
+		if hasPushValue(vm.data, vm.ip) {
+			vm.ip++
+		}
+
+		if err := vm.Exec(Instruction(vm.data[vm.ip])); err != nil {
+			journal.revertTo(start)
 			return err
 		}
 
 		vm.ip++
-
-		if vm.ip > len(vm.data)-1 {
-			break
-		}
 	}
 
 	return nil
 }
 
+// Exec runs a single instruction. The operand of a push comes right before
+// the instruction, the operands of the other instructions are taken from the
+// stack in the order they were pushed, so "a b SUB" results in a - b.
+// Integers are U256, arithmetic wraps around unless the safe variant is used.
+// Comparisons and logic push 1 for true and 0 for false, every value but 0
+// counts as true.
 func (vm *VM) Exec(instr Instruction) error {
+	if err := vm.useGas(instr.gas()); err != nil {
+		return err
+	}
+
+	if n := instr.immediate(); n > 0 {
+		if vm.ip+n >= len(vm.data) {
+			return fmt.Errorf("%w: push of (%d) bytes is cut off", ErrInvalidOperand, n)
+		}
+
+		value := U256FromBytes(vm.data[vm.ip+1 : vm.ip+1+n])
+		// Run moves on to the next instruction after Exec.
+		vm.ip += n
+
+		return vm.stack.Push(value)
+	}
+
 	switch instr {
 	case InstrStore:
-		var (
-			key             = vm.stack.Pop().([]byte)
-			value           = vm.stack.Pop()
-			serializedValue []byte
-		)
+		// value key STORE
+		key, err := pop[[]byte](vm.stack)
+		if err != nil {
+			return err
+		}
+		value, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
 
-		switch v := value.(type) {
-		case int:
-			serializedValue = serializeInt64(int64(v))
-		default:
-			panic("TODO: unknown type")
+		serializedValue, err := serializeValue(value)
+		if err != nil {
+			return err
 		}
 
 		vm.contractState.Put(key, serializedValue)
 
+	case InstrLoad:
+		// key LOAD pushes the stored value, keys that are not set load
+		// as 0.
+		key, err := pop[[]byte](vm.stack)
+		if err != nil {
+			return err
+		}
+
+		b, err := vm.contractState.Get(key)
+		if err != nil {
+			return vm.stack.Push(U256{})
+		}
+
+		value, err := deserializeValue(b)
+		if err != nil {
+			return err
+		}
+		if v, ok := value.(int64); ok {
+			value = U256FromInt64(v)
+		}
+
+		return vm.stack.Push(value)
+
+	case InstrDel:
+		// key DEL
+		key, err := pop[[]byte](vm.stack)
+		if err != nil {
+			return err
+		}
+
+		vm.contractState.Delete(key)
+
 	case InstrPushInt:
-		vm.stack.Push(int(vm.data[vm.ip-1]))
+		operand, err := vm.operand()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(NewU256(uint64(operand)))
 
 	case InstrPushByte:
-		vm.stack.Push(byte(vm.data[vm.ip-1]))
+		operand, err := vm.operand()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(operand)
 
 	case InstrPack:
-		n := vm.stack.Pop().(int)
+		// b1 ... bn n PACK results in the bytes b1 ... bn.
+		v, err := pop[U256](vm.stack)
+		if err != nil {
+			return err
+		}
+		n, ok := v.Uint64()
+		if !ok || n > uint64(vm.stack.Len()) {
+			return fmt.Errorf("%w: can not pack (%s) bytes", ErrStackUnderflow, v)
+		}
+		if err := vm.useGas(n * GasPackByte); err != nil {
+			return err
+		}
+
 		b := make([]byte, n)
+		for i := int(n) - 1; i >= 0; i-- {
+			if b[i], err = pop[byte](vm.stack); err != nil {
+				return err
+			}
+		}
+
+		return vm.stack.Push(b)
+
+	case InstrAdd, InstrSafeAdd:
+		return vm.arithmetic(instr == InstrSafeAdd, U256.Add)
+
+	case InstrSub, InstrSafeSub:
+		return vm.arithmetic(instr == InstrSafeSub, U256.Sub)
+
+	case InstrMul, InstrSafeMul:
+		return vm.arithmetic(instr == InstrSafeMul, U256.Mul)
+
+	case InstrExp, InstrSafeExp:
+		return vm.arithmetic(instr == InstrSafeExp, U256.Exp)
+
+	case InstrDiv, InstrMod:
+		a, b, err := vm.popInts()
+		if err != nil {
+			return err
+		}
+		if b.IsZero() {
+			return ErrDivisionByZero
+		}
+
+		q, r := a.DivMod(b)
+		if instr == InstrDiv {
+			return vm.stack.Push(q)
+		}
+		return vm.stack.Push(r)
+
+	case InstrJump:
+		// dest JUMP
+		dest, err := pop[U256](vm.stack)
+		if err != nil {
+			return err
+		}
+		return vm.jump(dest)
+
+	case InstrJumpI:
+		// cond dest JUMPI jumps when cond is true.
+		dest, err := pop[U256](vm.stack)
+		if err != nil {
+			return err
+		}
+		cond, err := pop[U256](vm.stack)
+		if err != nil {
+			return err
+		}
+		if !cond.IsZero() {
+			return vm.jump(dest)
+		}
+
+	case InstrEq:
+		a, b, err := vm.popInts()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(boolInt(a == b))
+
+	case InstrLt:
+		a, b, err := vm.popInts()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(boolInt(a.Cmp(b) < 0))
+
+	case InstrGt:
+		a, b, err := vm.popInts()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(boolInt(a.Cmp(b) > 0))
+
+	case InstrNot:
+		a, err := pop[U256](vm.stack)
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(boolInt(a.IsZero()))
+
+	case InstrAnd:
+		a, b, err := vm.popInts()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(boolInt(!a.IsZero() && !b.IsZero()))
 
-		for i := 0; i < n; i++ {
-			b[i] = vm.stack.Pop().(byte)
+	case InstrOr:
+		a, b, err := vm.popInts()
+		if err != nil {
+			return err
 		}
+		return vm.stack.Push(boolInt(!a.IsZero() || !b.IsZero()))
+
+	case InstrHalt:
+		vm.halted = true
+
+	case InstrRevert:
+		return ErrReverted
+	}
+
+	return nil
+}
+
+// arithmetic pushes op(a, b) for "a b OP", when safe is set an overflow
+// fails instead.
+func (vm *VM) arithmetic(safe bool, op func(U256, U256) (U256, bool)) error {
+	a, b, err := vm.popInts()
+	if err != nil {
+		return err
+	}
 
-		vm.stack.Push(b)
+	c, overflow := op(a, b)
+	if safe && overflow {
+		return ErrIntegerOverflow
+	}
 
-	case InstrSub:
-		a := vm.stack.Pop().(int)
-		b := vm.stack.Pop().(int)
-		c := a - b
-		vm.stack.Push(c)
+	return vm.stack.Push(c)
+}
 
-	case InstrAdd:
-		a := vm.stack.Pop().(int)
-		b := vm.stack.Pop().(int)
-		c := a + b
-		vm.stack.Push(c)
+// jump continues the execution at the instruction that starts at dest.
+func (vm *VM) jump(dest U256) error {
+	d, ok := dest.Uint64()
+	if !ok || d >= uint64(len(vm.data)) || !vm.jumpdests[d] {
+		return fmt.Errorf("%w: (%s)", ErrInvalidJump, dest)
 	}
 
+	// Run moves on to the next instruction after Exec.
+	vm.ip = int(d) - 1
+
 	return nil
 }
 
+func boolInt(b bool) U256 {
+	if b {
+		return NewU256(1)
+	}
+
+	return U256{}
+}
+
+// operand returns the byte in front of the current instruction.
+func (vm *VM) operand() (byte, error) {
+	if vm.ip == 0 {
+		return 0, fmt.Errorf("%w: push without a value", ErrInvalidOperand)
+	}
+
+	return vm.data[vm.ip-1], nil
+}
+
+// popInts pops the operands a and b of "a b OP".
+func (vm *VM) popInts() (U256, U256, error) {
+	b, err := pop[U256](vm.stack)
+	if err != nil {
+		return U256{}, U256{}, err
+	}
+	a, err := pop[U256](vm.stack)
+	if err != nil {
+		return U256{}, U256{}, err
+	}
+
+	return a, b, nil
+}
+
+// Stored values start with the tag of their type, so LOAD gives back the
+// value STORE wrote. The stack only holds U256s, bytes and byte slices, an
+// int64 is left from before the U256 and LOAD turns it into its U256 two's
+// complement.
+const (
+	valueTagInt64 byte = 0x01
+	valueTagBytes byte = 0x02
+	valueTagU256  byte = 0x03
+	valueTagByte  byte = 0x04
+)
+
+// serializeValue encodes an int64, an U256, a byte or a byte slice for the
+// contract state.
+func serializeValue(value any) ([]byte, error) {
+	switch v := value.(type) {
+	case int64:
+		return append([]byte{valueTagInt64}, serializeInt64(v)...), nil
+	case U256:
+		b := v.Bytes32()
+		return append([]byte{valueTagU256}, b[:]...), nil
+	case byte:
+		return []byte{valueTagByte, v}, nil
+	case []byte:
+		return append([]byte{valueTagBytes}, v...), nil
+	default:
+		return nil, fmt.Errorf("%w: can not store %T", ErrInvalidOperand, value)
+	}
+}
+
+func deserializeValue(b []byte) (any, error) {
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidValue)
+	}
+
+	switch b[0] {
+	case valueTagInt64:
+		if len(b) != 9 {
+			return nil, fmt.Errorf("%w: int64 has (%d) bytes", ErrInvalidValue, len(b)-1)
+		}
+		return deserializeInt64(b[1:]), nil
+	case valueTagU256:
+		if len(b) != 33 {
+			return nil, fmt.Errorf("%w: U256 has (%d) bytes", ErrInvalidValue, len(b)-1)
+		}
+		return U256FromBytes(b[1:]), nil
+	case valueTagByte:
+		if len(b) != 2 {
+			return nil, fmt.Errorf("%w: byte has (%d) bytes", ErrInvalidValue, len(b)-1)
+		}
+		return b[1], nil
+	case valueTagBytes:
+		value := make([]byte, len(b)-1)
+		copy(value, b[1:])
+		return value, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown type (%d)", ErrInvalidValue, b[0])
+	}
+}
+
 func serializeInt64(value int64) []byte {
 	buf := make([]byte, 8)
 
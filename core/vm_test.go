@@ -1,32 +1,511 @@
 package core
 
 import (
+	"bytes"
+	"errors"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestStack(t *testing.T) {
-	s := NewStack(128)
+	s := NewStack(2)
 
-	s.Push(1)
-	s.Push(2)
+	_, err := s.Pop()
+	assert.True(t, errors.Is(err, ErrStackUnderflow))
 
-	value := s.Pop()
-	assert.Equal(t, value, 1)
+	assert.Nil(t, s.Push(1))
+	assert.Nil(t, s.Push(2))
+	assert.True(t, errors.Is(s.Push(3), ErrStackOverflow))
+	assert.Equal(t, 2, s.Len())
 
-	value = s.Pop()
+	value, err := s.Pop()
+	assert.Nil(t, err)
 	assert.Equal(t, value, 2)
+
+	// The space of popped values can be used again.
+	assert.Nil(t, s.Push(3))
+
+	value, err = s.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, value, 3)
+
+	value, err = s.Pop()
+	assert.Nil(t, err)
+	assert.Equal(t, value, 1)
+
+	_, err = s.Pop()
+	assert.True(t, errors.Is(err, ErrStackUnderflow))
 }
 
 func TestVM(t *testing.T) {
-	data := []byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x05, 0x0a, 0x0f}
+	data := []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
 	contractState := NewState()
-	vm := NewVM(data, contractState)
+	vm := NewVM(data, contractState, 1000)
 	assert.Nil(t, vm.Run())
 
 	valueBytes, err := contractState.Get([]byte("FOO"))
-	value := deserializeInt64(valueBytes)
 	assert.Nil(t, err)
-	assert.Equal(t, value, int64(5))
+	value, err := deserializeValue(valueBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, value, NewU256(5))
+	assert.Equal(t, 0, vm.stack.Len())
+}
+
+// TestVMConformance pins the semantics of every instruction. The stack is
+// listed from the bottom to the top.
+func TestVMConformance(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		stack []any
+		err   error
+	}{
+		{
+			name:  "push int",
+			data:  []byte{0x01, 0x0a, 0x02, 0x0a},
+			stack: []any{NewU256(1), NewU256(2)},
+		},
+		{
+			name:  "push byte",
+			data:  []byte{0x61, 0x0c},
+			stack: []any{byte(0x61)},
+		},
+		{
+			name: "push without value",
+			data: []byte{0x0a},
+			err:  ErrInvalidOperand,
+		},
+		{
+			name:  "add",
+			data:  []byte{0x02, 0x0a, 0x03, 0x0a, 0x0b},
+			stack: []any{NewU256(5)},
+		},
+		{
+			name:  "sub takes the last pushed value from the first",
+			data:  []byte{0x07, 0x0a, 0x02, 0x0a, 0x0e},
+			stack: []any{NewU256(5)},
+		},
+		{
+			name:  "sub wraps around",
+			data:  []byte{0x02, 0x0a, 0x07, 0x0a, 0x0e},
+			stack: []any{U256FromInt64(-5)},
+		},
+		{
+			name:  "sub leaves the values below",
+			data:  []byte{0x01, 0x0a, 0x07, 0x0a, 0x02, 0x0a, 0x0e},
+			stack: []any{NewU256(1), NewU256(5)},
+		},
+		{
+			name:  "pack keeps the order of the bytes",
+			data:  []byte{0x61, 0x0c, 0x62, 0x0c, 0x63, 0x0c, 0x03, 0x0a, 0x0d},
+			stack: []any{[]byte("abc")},
+		},
+		{
+			name:  "pack zero bytes",
+			data:  []byte{0x00, 0x0a, 0x0d},
+			stack: []any{[]byte{}},
+		},
+		{
+			name: "pack more bytes than on the stack",
+			data: []byte{0x61, 0x0c, 0x02, 0x0a, 0x0d},
+			err:  ErrStackUnderflow,
+		},
+		{
+			name: "pack an int",
+			data: []byte{0x01, 0x0a, 0x01, 0x0a, 0x0d},
+			err:  ErrInvalidOperand,
+		},
+		{
+			name: "add on empty stack",
+			data: []byte{0x0b},
+			err:  ErrStackUnderflow,
+		},
+		{
+			name: "sub with one value",
+			data: []byte{0x01, 0x0a, 0x0e},
+			err:  ErrStackUnderflow,
+		},
+		{
+			name: "add a byte",
+			data: []byte{0x01, 0x0a, 0x61, 0x0c, 0x0b},
+			err:  ErrInvalidOperand,
+		},
+		{
+			name: "store without key",
+			data: []byte{0x01, 0x0a, 0x01, 0x0a, 0x0f},
+			err:  ErrInvalidOperand,
+		},
+		{
+			name:  "eq",
+			data:  []byte{0x03, 0x0a, 0x03, 0x0a, 0x12, 0x03, 0x0a, 0x04, 0x0a, 0x12},
+			stack: []any{NewU256(1), NewU256(0)},
+		},
+		{
+			name:  "lt",
+			data:  []byte{0x02, 0x0a, 0x03, 0x0a, 0x13, 0x03, 0x0a, 0x02, 0x0a, 0x13},
+			stack: []any{NewU256(1), NewU256(0)},
+		},
+		{
+			name:  "gt",
+			data:  []byte{0x02, 0x0a, 0x03, 0x0a, 0x14, 0x03, 0x0a, 0x02, 0x0a, 0x14},
+			stack: []any{NewU256(0), NewU256(1)},
+		},
+		{
+			name:  "not",
+			data:  []byte{0x00, 0x0a, 0x15, 0x05, 0x0a, 0x15},
+			stack: []any{NewU256(1), NewU256(0)},
+		},
+		{
+			name:  "and",
+			data:  []byte{0x02, 0x0a, 0x03, 0x0a, 0x16, 0x02, 0x0a, 0x00, 0x0a, 0x16},
+			stack: []any{NewU256(1), NewU256(0)},
+		},
+		{
+			name:  "or",
+			data:  []byte{0x00, 0x0a, 0x00, 0x0a, 0x17, 0x00, 0x0a, 0x07, 0x0a, 0x17},
+			stack: []any{NewU256(0), NewU256(1)},
+		},
+		{
+			name:  "jump skips code",
+			data:  []byte{0x04, 0x0a, 0x10, 0x19, 0x01, 0x0a},
+			stack: []any{NewU256(1)},
+		},
+		{
+			name:  "jumpi not taken",
+			data:  []byte{0x00, 0x0a, 0x06, 0x0a, 0x11, 0x02, 0x0a},
+			stack: []any{NewU256(2)},
+		},
+		{
+			name:  "jumpi taken",
+			data:  []byte{0x01, 0x0a, 0x07, 0x0a, 0x11, 0x02, 0x0a, 0x03, 0x0a},
+			stack: []any{NewU256(3)},
+		},
+		{
+			name:  "jump back",
+			data:  []byte{0x01, 0x0a, 0x00, 0x0a, 0x0a, 0x0a, 0x11, 0x04, 0x0a, 0x10, 0x09, 0x0a},
+			stack: []any{NewU256(9)},
+		},
+		{
+			name: "jump into a push",
+			data: []byte{0x01, 0x0a, 0x01, 0x0a, 0x10},
+			err:  ErrInvalidJump,
+		},
+		{
+			name: "jump out of the code",
+			data: []byte{0x09, 0x0a, 0x10},
+			err:  ErrInvalidJump,
+		},
+		{
+			name: "jump to a byte",
+			data: []byte{0x00, 0x0c, 0x10},
+			err:  ErrInvalidOperand,
+		},
+		{
+			name:  "halt",
+			data:  []byte{0x01, 0x0a, 0x18, 0x02, 0x0a},
+			stack: []any{NewU256(1)},
+		},
+		{
+			name: "revert",
+			data: []byte{0x01, 0x0a, 0x19, 0x02, 0x0a},
+			err:  ErrReverted,
+		},
+		{
+			name:  "push a value that is an instruction",
+			data:  []byte{0x10, 0x0a, 0x0a, 0x0a},
+			stack: []any{NewU256(16), NewU256(10)},
+		},
+		{
+			name:  "mul",
+			data:  []byte{0x06, 0x0a, 0x07, 0x0a, 0x1c},
+			stack: []any{NewU256(42)},
+		},
+		{
+			name:  "div",
+			data:  []byte{0x07, 0x0a, 0x02, 0x0a, 0x1d},
+			stack: []any{NewU256(3)},
+		},
+		{
+			name:  "mod",
+			data:  []byte{0x07, 0x0a, 0x02, 0x0a, 0x1e},
+			stack: []any{NewU256(1)},
+		},
+		{
+			name: "div by zero",
+			data: []byte{0x07, 0x0a, 0x00, 0x0a, 0x1d},
+			err:  ErrDivisionByZero,
+		},
+		{
+			name: "mod by zero",
+			data: []byte{0x07, 0x0a, 0x00, 0x0a, 0x1e},
+			err:  ErrDivisionByZero,
+		},
+		{
+			name:  "exp",
+			data:  []byte{0x02, 0x0a, 0x08, 0x0a, 0x1f},
+			stack: []any{NewU256(256)},
+		},
+		{
+			name:  "exp wraps around",
+			data:  []byte{0x02, 0x0a, 0x61, 0x01, 0x00, 0x1f},
+			stack: []any{U256{}},
+		},
+		{
+			name: "safe exp overflows",
+			data: []byte{0x02, 0x0a, 0x61, 0x01, 0x00, 0x23},
+			err:  ErrIntegerOverflow,
+		},
+		{
+			name:  "safe exp",
+			data:  []byte{0x02, 0x0a, 0x08, 0x0a, 0x23},
+			stack: []any{NewU256(256)},
+		},
+		{
+			name:  "safe add",
+			data:  []byte{0x02, 0x0a, 0x03, 0x0a, 0x20},
+			stack: []any{NewU256(5)},
+		},
+		{
+			name: "safe sub underflows",
+			data: []byte{0x02, 0x0a, 0x07, 0x0a, 0x21},
+			err:  ErrIntegerOverflow,
+		},
+		{
+			name:  "safe sub",
+			data:  []byte{0x07, 0x0a, 0x02, 0x0a, 0x21},
+			stack: []any{NewU256(5)},
+		},
+		{
+			name:  "safe mul",
+			data:  []byte{0x06, 0x0a, 0x07, 0x0a, 0x22},
+			stack: []any{NewU256(42)},
+		},
+		{
+			name: "safe mul overflows",
+			data: append(append([]byte{0x7f}, bytes.Repeat([]byte{0xff}, 32)...), 0x02, 0x0a, 0x22),
+			err:  ErrIntegerOverflow,
+		},
+		{
+			name:  "add wraps around",
+			data:  append(append([]byte{0x7f}, bytes.Repeat([]byte{0xff}, 32)...), 0x02, 0x0a, 0x0b),
+			stack: []any{NewU256(1)},
+		},
+		{
+			name: "safe add overflows",
+			data: append(append([]byte{0x7f}, bytes.Repeat([]byte{0xff}, 32)...), 0x01, 0x0a, 0x20),
+			err:  ErrIntegerOverflow,
+		},
+		{
+			name:  "push1",
+			data:  []byte{0x60, 0xff},
+			stack: []any{NewU256(255)},
+		},
+		{
+			name:  "push2 is big endian",
+			data:  []byte{0x61, 0x01, 0x00},
+			stack: []any{NewU256(256)},
+		},
+		{
+			name:  "push32",
+			data:  append([]byte{0x7f}, bytes.Repeat([]byte{0xff}, 32)...),
+			stack: []any{U256FromInt64(-1)},
+		},
+		{
+			name:  "push value bytes are not executed",
+			data:  []byte{0x62, 0x00, 0x19, 0x18, 0x01, 0x0a},
+			stack: []any{NewU256(0x1918), NewU256(1)},
+		},
+		{
+			name: "push cut off",
+			data: []byte{0x61, 0x01},
+			err:  ErrInvalidOperand,
+		},
+		{
+			name: "jump into the value of a push",
+			data: []byte{0x60, 0x05, 0x10, 0x61, 0x00, 0x19},
+			err:  ErrInvalidJump,
+		},
+		{
+			name:  "jump over a push",
+			data:  []byte{0x60, 0x08, 0x10, 0x61, 0x00, 0x19, 0x00, 0x19, 0x01, 0x0a},
+			stack: []any{NewU256(1)},
+		},
+		{
+			name:  "load a byte",
+			data:  []byte{0x07, 0x0c, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x0f, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x1a},
+			stack: []any{byte(7)},
+		},
+		{
+			name:  "load an int",
+			data:  []byte{0x05, 0x0a, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x0f, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x1a},
+			stack: []any{NewU256(5)},
+		},
+		{
+			name:  "load bytes",
+			data:  []byte{0x78, 0x0c, 0x01, 0x0a, 0x0d, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x0f, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x1a},
+			stack: []any{[]byte("x")},
+		},
+		{
+			name:  "load a missing key",
+			data:  []byte{0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x1a},
+			stack: []any{NewU256(0)},
+		},
+		{
+			name:  "del",
+			data:  []byte{0x05, 0x0a, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x0f, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x1b, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x1a},
+			stack: []any{NewU256(0)},
+		},
+		{
+			name: "load with an int key",
+			data: []byte{0x01, 0x0a, 0x1a},
+			err:  ErrInvalidOperand,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := NewVM(test.data, NewState(), 1000)
+			err := vm.Run()
+			if test.err != nil {
+				assert.True(t, errors.Is(err, test.err), err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, test.stack, vm.stack.data[:vm.stack.Len()])
+		})
+	}
+}
+
+func TestVMStackOverflow(t *testing.T) {
+	data := []byte{}
+	for i := 0; i <= 128; i++ {
+		data = append(data, 0x01, 0x0a)
+	}
+
+	vm := NewVM(data, NewState(), 1000)
+	assert.True(t, errors.Is(vm.Run(), ErrStackOverflow))
+}
+
+func TestVMRevertRollsBackStorage(t *testing.T) {
+	contractState := NewState()
+	one, err := serializeValue(int64(1))
+	assert.Nil(t, err)
+	assert.Nil(t, contractState.Put([]byte("FOO"), one))
+
+	// Stores 5 under FOO and reverts.
+	data := []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f, 0x19}
+	vm := NewVM(data, contractState, 1000)
+	assert.True(t, errors.Is(vm.Run(), ErrReverted))
+
+	value, err := contractState.Get([]byte("FOO"))
+	assert.Nil(t, err)
+	assert.Equal(t, one, value)
+	assert.Nil(t, contractState.journal)
+}
+
+func TestVMGas(t *testing.T) {
+	// Stores the value 5 under the key "FOO".
+	data := []byte{0x05, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x03, 0x0a, 0x0d, 0x0f}
+
+	contractState := NewState()
+	vm := NewVM(data, contractState, 29)
+	assert.Nil(t, vm.Run())
+	// Five pushes, a pack of three bytes and a store.
+	assert.Equal(t, 5*GasStep+GasStep+3*GasPackByte+GasStore, vm.GasUsed())
+
+	contractState = NewState()
+	vm = NewVM(data, contractState, 28)
+	assert.True(t, errors.Is(vm.Run(), ErrOutOfGas))
+	assert.Equal(t, uint64(28), vm.GasUsed())
+	_, err := contractState.Get([]byte("FOO"))
+	assert.NotNil(t, err)
+}
+
+func TestVMInfiniteLoopRunsOutOfGas(t *testing.T) {
+	// 0 JUMP forever.
+	vm := NewVM([]byte{0x00, 0x0a, 0x10}, NewState(), 1000)
+	assert.True(t, errors.Is(vm.Run(), ErrOutOfGas))
+	assert.Equal(t, uint64(1000), vm.GasUsed())
+}
+
+func TestVMCounter(t *testing.T) {
+	// "a" LOAD 1 ADD "a" STORE
+	data := []byte{0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x1a, 0x01, 0x0a, 0x0b, 0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x0f}
+	contractState := NewState()
+
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, NewVM(data, contractState, 1000).Run())
+	}
+
+	b, err := contractState.Get([]byte("a"))
+	assert.Nil(t, err)
+	value, err := deserializeValue(b)
+	assert.Nil(t, err)
+	assert.Equal(t, NewU256(3), value)
+}
+
+func TestVMLoadInvalidValue(t *testing.T) {
+	contractState := NewState()
+	assert.Nil(t, contractState.Put([]byte("a"), []byte{0x05}))
+
+	vm := NewVM([]byte{0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x1a}, contractState, 1000)
+	assert.True(t, errors.Is(vm.Run(), ErrInvalidValue))
+}
+
+func TestSerializeValue(t *testing.T) {
+	for _, value := range []any{int64(0), int64(-1), int64(math.MaxInt64), int64(math.MinInt64), NewU256(0), U256FromInt64(-1), byte(0), byte(0xff), []byte{}, []byte("FOO")} {
+		b, err := serializeValue(value)
+		assert.Nil(t, err)
+
+		decoded, err := deserializeValue(b)
+		assert.Nil(t, err)
+		assert.Equal(t, value, decoded)
+	}
+
+	b, err := serializeValue(int64(5))
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte{valueTagInt64}, serializeInt64(5)...), b)
+	assert.Equal(t, int64(5), deserializeInt64(b[1:]))
+
+	_, err = serializeValue("FOO")
+	assert.True(t, errors.Is(err, ErrInvalidOperand))
+
+	for _, b := range [][]byte{nil, {valueTagInt64, 0x01}, {valueTagByte}, {valueTagByte, 0x01, 0x02}, {0xff}} {
+		_, err := deserializeValue(b)
+		assert.True(t, errors.Is(err, ErrInvalidValue))
+	}
+}
+
+func TestVMTokenBalance(t *testing.T) {
+	// 1000 "a" STORE "a" LOAD 250000 SAFEADD "a" STORE
+	data := []byte{
+		0x61, 0x03, 0xe8,
+		0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x0f,
+		0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x1a,
+		0x62, 0x03, 0xd0, 0x90, 0x20,
+		0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x0f,
+	}
+	contractState := NewState()
+	assert.Nil(t, NewVM(data, contractState, 1000).Run())
+
+	b, err := contractState.Get([]byte("a"))
+	assert.Nil(t, err)
+	value, err := deserializeValue(b)
+	assert.Nil(t, err)
+	assert.Equal(t, NewU256(251000), value)
+}
+
+func TestVMLoadInt64(t *testing.T) {
+	contractState := NewState()
+	b, err := serializeValue(int64(-1))
+	assert.Nil(t, err)
+	assert.Nil(t, contractState.Put([]byte("a"), b))
+
+	// "a" LOAD 1 ADD wraps around to 0.
+	vm := NewVM([]byte{0x61, 0x0c, 0x01, 0x0a, 0x0d, 0x1a, 0x01, 0x0a, 0x0b}, contractState, 1000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, []any{U256{}}, vm.stack.data[:vm.stack.Len()])
 }
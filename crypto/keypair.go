@@ -5,7 +5,10 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"io"
 	"math/big"
 
@@ -43,6 +46,37 @@ func GeneratePrivateKey() PrivateKey {
 	return NewPrivateKeyFromReader(rand.Reader)
 }
 
+// NewPrivateKeyFromPEM parses a P-256 private key from a PEM encoded EC
+// PRIVATE KEY block, as written by MarshalPEM.
+func NewPrivateKeyFromPEM(data []byte) (PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		return PrivateKey{}, errors.New("no EC PRIVATE KEY block found")
+	}
+
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return PrivateKey{}, err
+	}
+	if key.Curve != elliptic.P256() {
+		return PrivateKey{}, errors.New("private key is not on the P-256 curve")
+	}
+
+	return PrivateKey{
+		key: key,
+	}, nil
+}
+
+// MarshalPEM encodes the key as a PEM EC PRIVATE KEY block.
+func (k PrivateKey) MarshalPEM() ([]byte, error) {
+	b, err := x509.MarshalECPrivateKey(k.key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), nil
+}
+
 func (k PrivateKey) PublicKey() PublicKey {
 	return elliptic.MarshalCompressed(k.key.PublicKey, k.key.PublicKey.X, k.key.PublicKey.Y)
 }
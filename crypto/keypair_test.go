@@ -31,3 +31,22 @@ func TestKeypairSignVerifyFail(t *testing.T) {
 	assert.False(t, sig.Verify(otherPublicKey, msg))
 	assert.False(t, sig.Verify(publicKey, []byte("xxxxxx")))
 }
+
+func TestPrivateKeyPEM(t *testing.T) {
+	privKey := GeneratePrivateKey()
+
+	data, err := privKey.MarshalPEM()
+	assert.Nil(t, err)
+
+	decoded, err := NewPrivateKeyFromPEM(data)
+	assert.Nil(t, err)
+	assert.Equal(t, privKey.PublicKey(), decoded.PublicKey())
+
+	msg := []byte("hello world")
+	sig, err := decoded.Sign(msg)
+	assert.Nil(t, err)
+	assert.True(t, sig.Verify(privKey.PublicKey(), msg))
+
+	_, err = NewPrivateKeyFromPEM([]byte("not a key"))
+	assert.NotNil(t, err)
+}
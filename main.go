@@ -3,8 +3,12 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gabrielluizsf/go-web3/core"
@@ -15,34 +19,101 @@ import (
 )
 
 func main() {
+	genesisPath := flag.String("genesis", "", "path to a JSON or YAML genesis file")
+	validatorKeyPath := flag.String("validator-key", "", "path to the PEM encoded private key of the local validator")
+	dataDir := flag.String("datadir", "", "directory the local node stores its blocks in, blocks are kept in memory when empty")
+	verify := flag.Bool("verify", false, "verify the blocks stored in -datadir without executing them and exit")
+	flag.Parse()
+
 	validatorPrivKey := crypto.GeneratePrivateKey()
-	localNode := makeServer("LOCAL_NODE", &validatorPrivKey, ":3000", []string{":4000"}, ":9000")
+	if len(*validatorKeyPath) > 0 {
+		data, err := os.ReadFile(*validatorKeyPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		validatorPrivKey, err = crypto.NewPrivateKeyFromPEM(data)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	genesis := core.Genesis{
+		Alloc: map[types.Address]uint64{
+			validatorPrivKey.PublicKey().Address(): 10_000_000,
+		},
+		Validators: []crypto.PublicKey{validatorPrivKey.PublicKey()},
+	}
+
+	if len(*genesisPath) > 0 {
+		spec, err := core.LoadGenesis(*genesisPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		genesis = *spec
+	}
+
+	if *verify {
+		height, err := network.VerifyStore(network.ServerOpts{ID: "LOCAL_NODE", DataDir: *dataDir, Genesis: genesis})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("verified the stored blocks up to height (%d)", height)
+		return
+	}
+
+	// A key that is not in the validator set of the genesis can never
+	// propose a block.
+	if len(*genesisPath) > 0 && !isValidator(genesis.Validators, validatorPrivKey.PublicKey()) {
+		log.Fatalf("validator key (%s) is not in the validator set of the genesis, pass it with -validator-key", validatorPrivKey.PublicKey())
+	}
+
+	localNode := makeServer("LOCAL_NODE", &validatorPrivKey, ":3000", []string{":4000"}, ":9000", *dataDir, genesis)
 	go localNode.Start()
 
-	remoteNode := makeServer("REMOTE_NODE", nil, ":4000", []string{":5000"}, "")
+	remoteNode := makeServer("REMOTE_NODE", nil, ":4000", []string{":5000"}, "", "", genesis)
 	go remoteNode.Start()
 
-	remoteNodeB := makeServer("REMOTE_NODE_B", nil, ":5000", nil, "")
+	remoteNodeB := makeServer("REMOTE_NODE_B", nil, ":5000", nil, "", "", genesis)
 	go remoteNodeB.Start()
 
-	go func() {
-		time.Sleep(11 * time.Second)
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
-		lateNode := makeServer("LATE_NODE", nil, ":6000", []string{":4000"}, "")
+	nodes := []*network.Server{localNode, remoteNode, remoteNodeB}
+	select {
+	case <-time.After(11 * time.Second):
+		lateNode := makeServer("LATE_NODE", nil, ":6000", []string{":4000"}, "", "", genesis)
 		go lateNode.Start()
-	}()
+		nodes = append(nodes, lateNode)
+
+		<-quit
+	case <-quit:
+	}
 
-	time.Sleep(1 * time.Second)
+	// Stopping the nodes closes their chains, so no stored block is lost.
+	for _, node := range nodes {
+		node.Stop()
+	}
+}
+
+func isValidator(validators []crypto.PublicKey, key crypto.PublicKey) bool {
+	for _, validator := range validators {
+		if bytes.Equal(validator, key) {
+			return true
+		}
+	}
 
-	select {}
+	return false
 }
 
-func sendTransaction(privKey crypto.PrivateKey) error {
+func sendTransaction(privKey crypto.PrivateKey, chainID, nonce uint64) error {
 	toPrivKey := crypto.GeneratePrivateKey()
 
 	transaction := core.NewTransaction(nil)
 	transaction.To = toPrivKey.PublicKey()
 	transaction.Value = 42
+	transaction.ChainID = chainID
+	transaction.Nonce = nonce
 
 	if err := transaction.Sign(privKey); err != nil {
 		return err
@@ -64,13 +135,15 @@ func sendTransaction(privKey crypto.PrivateKey) error {
 	return err
 }
 
-func makeServer(id string, pk *crypto.PrivateKey, addr string, seedNodes []string, apiListenAddr string) *network.Server {
+func makeServer(id string, pk *crypto.PrivateKey, addr string, seedNodes []string, apiListenAddr string, dataDir string, genesis core.Genesis) *network.Server {
 	opts := network.ServerOpts{
 		APIListenAddr: apiListenAddr,
 		SeedNodes:     seedNodes,
 		ListenAddr:    addr,
 		PrivateKey:    pk,
 		ID:            id,
+		DataDir:       dataDir,
+		Genesis:       genesis,
 	}
 
 	s, err := network.NewServer(opts)
@@ -81,12 +154,14 @@ func makeServer(id string, pk *crypto.PrivateKey, addr string, seedNodes []strin
 	return s
 }
 
-func createCollectionTransaction(privKey crypto.PrivateKey) types.Hash {
+func createCollectionTransaction(privKey crypto.PrivateKey, chainID, nonce uint64) types.Hash {
 	transaction := core.NewTransaction(nil)
 	transaction.TransactionInner = core.CollectionTransaction{
 		Fee:      200,
 		MetaData: []byte("chicken and egg collection!"),
 	}
+	transaction.ChainID = chainID
+	transaction.Nonce = nonce
 	transaction.Sign(privKey)
 
 	buf := &bytes.Buffer{}
@@ -108,7 +183,7 @@ func createCollectionTransaction(privKey crypto.PrivateKey) types.Hash {
 	return transaction.Hash(core.TransactionHasher{})
 }
 
-func nftMinter(privKey crypto.PrivateKey, collection types.Hash) {
+func nftMinter(privKey crypto.PrivateKey, chainID, nonce uint64, collection types.Hash) {
 	metaData := map[string]any{
 		"power":  8,
 		"health": 100,
@@ -129,6 +204,8 @@ func nftMinter(privKey crypto.PrivateKey, collection types.Hash) {
 		Collection:      collection,
 		CollectionOwner: privKey.PublicKey(),
 	}
+	transaction.ChainID = chainID
+	transaction.Nonce = nonce
 	transaction.Sign(privKey)
 
 	buf := &bytes.Buffer{}
@@ -0,0 +1,551 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/go-kit/log"
+)
+
+// maxFutureMessages is the number of messages for the next height that are
+// kept until the engine gets there.
+const maxFutureMessages = 1024
+
+// BFTStep is the step of a round a validator is in.
+type BFTStep byte
+
+const (
+	// StepNewHeight waits for the next Timeout after a commit before the
+	// first round of the height starts, which spaces the blocks.
+	StepNewHeight BFTStep = iota
+	StepPropose
+	StepPrevote
+	StepPrecommit
+)
+
+type BFTOpts struct {
+	Logger     log.Logger
+	PrivateKey crypto.PrivateKey
+	Chain      *core.Blockchain
+	// Broadcast sends an encoded message to the other validators.
+	Broadcast func([]byte) error
+	// BuildBlock returns a new signed block on top of the head of the
+	// chain, it is called when this validator is the proposer of a round.
+	BuildBlock func() (*core.Block, error)
+	// OnCommit is called with every block that was committed.
+	OnCommit func(*core.Block)
+}
+
+type voteKey struct {
+	round    uint32
+	voteType core.VoteType
+}
+
+// BFTEngine commits blocks with a propose/prevote/precommit protocol among
+// the validators of the chain. Every round the proposer proposes a block, the
+// validators prevote for it and once more than two thirds prevoted for the
+// same block they precommit it. The precommits of more than two thirds of
+// the validators commit the block, which makes it final.
+//
+// The engine does not keep time itself, Timeout has to be called when a
+// step takes too long, e.g. because the proposer is offline, and to start
+// the first round after a commit.
+type BFTEngine struct {
+	BFTOpts
+
+	lock   sync.Mutex
+	height uint32
+	round  uint32
+	step   BFTStep
+	// proposals holds the valid proposed blocks of the height by hash and
+	// proposed the first valid proposal of every round.
+	proposals map[types.Hash]*core.Block
+	proposed  map[uint32]*core.Proposal
+	votes     map[voteKey]map[types.Address]*core.Vote
+	// lockedBlock is the block the validator precommitted in lockedRound.
+	// It keeps prevoting for it until a proposal proves that more than two
+	// thirds prevoted for another block in a later round.
+	lockedBlock *core.Block
+	lockedRound uint32
+	// failed holds the committed blocks the chain did not accept, they are
+	// not added again until the chain syncs past the height.
+	failed map[types.Hash]bool
+	// future holds the messages for the next height.
+	future []any
+}
+
+func NewBFTEngine(opts BFTOpts) *BFTEngine {
+	if opts.Logger == nil {
+		opts.Logger = log.NewNopLogger()
+	}
+
+	return &BFTEngine{
+		BFTOpts: opts,
+	}
+}
+
+// Start starts the first round of the height on top of the chain.
+func (e *BFTEngine) Start() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.enterHeight(e.Chain.Height() + 1)
+	e.startRound(0)
+	e.checkVotes()
+}
+
+// State returns the height, round and step the engine is in.
+func (e *BFTEngine) State() (uint32, uint32, BFTStep) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.height, e.round, e.step
+}
+
+// Timeout moves on when the current step takes too long. Without a proposal
+// the validator prevotes for its locked block or no block, without a prevote
+// majority it precommits no block and without a commit it starts the next
+// round.
+func (e *BFTEngine) Timeout() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.syncHeight()
+
+	switch e.step {
+	case StepNewHeight:
+		e.startRound(0)
+	case StepPropose:
+		e.vote(core.VotePrevote, e.lockedHash())
+		e.step = StepPrevote
+	case StepPrevote:
+		e.vote(core.VotePrecommit, types.Hash{})
+		e.step = StepPrecommit
+	case StepPrecommit:
+		e.startRound(e.round + 1)
+	}
+
+	e.checkVotes()
+}
+
+func (e *BFTEngine) ProcessProposal(msg *ProposalMessage) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.syncHeight()
+
+	if err := e.handleProposal(msg.Proposal); err != nil {
+		return err
+	}
+	e.checkVotes()
+
+	return nil
+}
+
+func (e *BFTEngine) ProcessVote(msg *VoteMessage) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.syncHeight()
+
+	if err := e.handleVote(msg.Vote); err != nil {
+		return err
+	}
+	e.checkVotes()
+
+	return nil
+}
+
+// syncHeight moves to the next height when the chain got ahead of the
+// engine, e.g. because a committed block was received from a peer.
+func (e *BFTEngine) syncHeight() {
+	if height := e.Chain.Height() + 1; height > e.height {
+		e.enterHeight(height)
+	}
+}
+
+func (e *BFTEngine) enterHeight(height uint32) {
+	e.height = height
+	e.proposals = make(map[types.Hash]*core.Block)
+	e.proposed = make(map[uint32]*core.Proposal)
+	e.votes = make(map[voteKey]map[types.Address]*core.Vote)
+	e.failed = make(map[types.Hash]bool)
+	e.lockedBlock = nil
+	e.lockedRound = 0
+	e.round = 0
+	e.step = StepNewHeight
+
+	future := e.future
+	e.future = nil
+	for _, msg := range future {
+		switch t := msg.(type) {
+		case *core.Proposal:
+			e.handleProposal(t)
+		case *core.Vote:
+			e.handleVote(t)
+		}
+	}
+}
+
+func (e *BFTEngine) startRound(round uint32) {
+	e.round = round
+	e.step = StepPropose
+
+	proposer := e.Chain.Proposer(e.height, uint64(round))
+	if bytes.Equal(proposer, e.PrivateKey.PublicKey()) {
+		if err := e.propose(); err != nil {
+			e.Logger.Log("msg", "failed to propose", "height", e.height, "round", round, "err", err)
+		}
+	}
+}
+
+func (e *BFTEngine) propose() error {
+	// A locked block is proposed again with the round it was locked in, so
+	// the validators that locked on another block in an earlier round can
+	// unlock and vote for it.
+	block, polRound := e.lockedBlock, int32(-1)
+	if block != nil {
+		polRound = int32(e.lockedRound)
+	} else {
+		b, err := e.BuildBlock()
+		if err != nil {
+			return err
+		}
+		block = b
+	}
+
+	proposal := &core.Proposal{
+		ChainID:  e.Chain.ChainID(),
+		Round:    e.round,
+		POLRound: polRound,
+		Block:    block,
+	}
+	if err := proposal.Sign(e.PrivateKey); err != nil {
+		return err
+	}
+
+	e.broadcast(MessageTypeProposal, &ProposalMessage{Proposal: proposal})
+
+	return e.handleProposal(proposal)
+}
+
+func (e *BFTEngine) handleProposal(p *core.Proposal) error {
+	if p == nil || p.Block == nil {
+		return fmt.Errorf("proposal has no block")
+	}
+
+	if height := p.Block.Height; height != e.height {
+		if height == e.height+1 {
+			e.deferMessage(p)
+		}
+		return nil
+	}
+
+	if err := e.Chain.ValidateProposal(p); err != nil {
+		return err
+	}
+
+	hash := p.Block.Hash(core.BlockHasher{})
+	e.proposals[hash] = p.Block
+	if _, ok := e.proposed[p.Round]; !ok {
+		e.proposed[p.Round] = p
+	}
+
+	return nil
+}
+
+func (e *BFTEngine) handleVote(v *core.Vote) error {
+	if v == nil {
+		return fmt.Errorf("empty vote")
+	}
+
+	if v.Height != e.height {
+		if v.Height == e.height+1 {
+			e.deferMessage(v)
+		}
+		return nil
+	}
+
+	if v.Type != core.VotePrevote && v.Type != core.VotePrecommit {
+		return fmt.Errorf("invalid vote type (%s)", v.Type)
+	}
+	if v.ChainID != e.Chain.ChainID() {
+		return fmt.Errorf("vote has chain id (%d) expected (%d)", v.ChainID, e.Chain.ChainID())
+	}
+	if !e.isValidator(v.Validator) {
+		return fmt.Errorf("vote of (%s) which is not a validator", v.Validator.Address())
+	}
+	if err := v.Verify(); err != nil {
+		return err
+	}
+
+	key := voteKey{round: v.Round, voteType: v.Type}
+	if e.votes[key] == nil {
+		e.votes[key] = make(map[types.Address]*core.Vote)
+	}
+
+	address := v.Validator.Address()
+	if prev, ok := e.votes[key][address]; ok {
+		if prev.BlockHash != v.BlockHash {
+			return fmt.Errorf("conflicting %s of (%s) in round (%d)", v.Type, address, v.Round)
+		}
+		return nil
+	}
+	e.votes[key][address] = v
+
+	return nil
+}
+
+func (e *BFTEngine) deferMessage(msg any) {
+	if len(e.future) < maxFutureMessages {
+		e.future = append(e.future, msg)
+	}
+}
+
+// checkVotes takes every step the received proposals and votes allow.
+func (e *BFTEngine) checkVotes() {
+	for e.tryCommit() || e.tryAdvance() {
+	}
+}
+
+// tryCommit commits a block once more than two thirds of the validators
+// precommitted it in any round of the height.
+func (e *BFTEngine) tryCommit() bool {
+	for key, votes := range e.votes {
+		if key.voteType != core.VotePrecommit {
+			continue
+		}
+
+		hash, ok := e.majority(votes)
+		if !ok || hash.IsZero() {
+			continue
+		}
+
+		block, ok := e.proposals[hash]
+		if !ok || e.failed[hash] {
+			continue
+		}
+
+		return e.commit(block, key.round, votes)
+	}
+
+	return false
+}
+
+// tryAdvance takes the next step of the current round.
+func (e *BFTEngine) tryAdvance() bool {
+	// More than a third of the validators is in a later round, so at least
+	// one honest validator is.
+	if round, ok := e.laterRound(); ok {
+		e.startRound(round)
+		return true
+	}
+
+	if e.step == StepNewHeight {
+		return false
+	}
+
+	if e.step == StepPropose {
+		if proposal, ok := e.proposed[e.round]; ok {
+			e.vote(core.VotePrevote, e.prevote(proposal))
+			e.step = StepPrevote
+			return true
+		}
+	}
+
+	if e.step != StepPrecommit {
+		prevotes := e.votes[voteKey{round: e.round, voteType: core.VotePrevote}]
+		if hash, ok := e.majority(prevotes); ok {
+			// Prevotes for no block do not unlock, the locked block may
+			// already be committed by validators this one did not hear from.
+			if hash.IsZero() {
+				e.vote(core.VotePrecommit, hash)
+				e.step = StepPrecommit
+				return true
+			}
+
+			if block, ok := e.proposals[hash]; ok {
+				e.lockedBlock = block
+				e.lockedRound = e.round
+				e.vote(core.VotePrecommit, hash)
+				e.step = StepPrecommit
+				return true
+			}
+		}
+	}
+
+	precommits := e.votes[voteKey{round: e.round, voteType: core.VotePrecommit}]
+	if hash, ok := e.majority(precommits); ok && hash.IsZero() {
+		e.startRound(e.round + 1)
+		return true
+	}
+
+	return false
+}
+
+// prevote returns the block to prevote for the proposal of the round. A
+// locked validator prevotes for its locked block, unless the proposal carries
+// a proof of lock: more than two thirds prevoted for the proposed block in a
+// round after the one the validator locked in.
+func (e *BFTEngine) prevote(p *core.Proposal) types.Hash {
+	hash := p.Block.Hash(core.BlockHasher{})
+	if e.lockedBlock == nil {
+		return hash
+	}
+
+	locked := e.lockedHash()
+	if hash == locked {
+		return hash
+	}
+
+	if p.POLRound > int32(e.lockedRound) && p.POLRound < int32(e.round) {
+		prevotes := e.votes[voteKey{round: uint32(p.POLRound), voteType: core.VotePrevote}]
+		if polHash, ok := e.majority(prevotes); ok && polHash == hash {
+			e.lockedBlock = nil
+			return hash
+		}
+	}
+
+	return locked
+}
+
+// lockedHash returns the hash of the locked block or the zero hash when the
+// validator is not locked.
+func (e *BFTEngine) lockedHash() types.Hash {
+	if e.lockedBlock == nil {
+		return types.Hash{}
+	}
+
+	return e.lockedBlock.Hash(core.BlockHasher{})
+}
+
+// laterRound returns the first round after the current one that more than a
+// third of the validators voted in. While waiting for the first round the
+// current round counts as well.
+func (e *BFTEngine) laterRound() (uint32, bool) {
+	voters := make(map[uint32]map[types.Address]bool)
+	for key, votes := range e.votes {
+		if key.round < e.round || (key.round == e.round && e.step != StepNewHeight) {
+			continue
+		}
+		if voters[key.round] == nil {
+			voters[key.round] = make(map[types.Address]bool)
+		}
+		for address := range votes {
+			voters[key.round][address] = true
+		}
+	}
+
+	var (
+		n     = len(e.Chain.Validators())
+		round uint32
+		found bool
+	)
+	for r, addresses := range voters {
+		if len(addresses) > n/3 && (!found || r < round) {
+			round, found = r, true
+		}
+	}
+
+	return round, found
+}
+
+// majority returns the block hash that more than two thirds of the
+// validators voted for.
+func (e *BFTEngine) majority(votes map[types.Address]*core.Vote) (types.Hash, bool) {
+	quorum := core.Quorum(len(e.Chain.Validators()))
+
+	counts := make(map[types.Hash]int)
+	for _, vote := range votes {
+		counts[vote.BlockHash]++
+		if counts[vote.BlockHash] >= quorum {
+			return vote.BlockHash, true
+		}
+	}
+
+	return types.Hash{}, false
+}
+
+// commit adds the block with its commit certificate to the chain and enters
+// the next height. When the chain does not accept the block the votes and the
+// lock are kept, the engine moves on once the chain synced the height.
+func (e *BFTEngine) commit(block *core.Block, round uint32, votes map[types.Address]*core.Vote) bool {
+	hash := block.Hash(core.BlockHasher{})
+
+	precommits := []*core.Vote{}
+	for _, vote := range votes {
+		if vote.BlockHash == hash {
+			precommits = append(precommits, vote)
+		}
+	}
+	sort.Slice(precommits, func(i, j int) bool {
+		return bytes.Compare(precommits[i].Validator, precommits[j].Validator) < 0
+	})
+
+	block.Commit = &core.CommitCertificate{
+		Height:     block.Height,
+		Round:      round,
+		BlockHash:  hash,
+		Precommits: precommits,
+	}
+
+	if err := e.Chain.AddBlock(block); err != nil && !errors.Is(err, core.ErrBlockKnown) {
+		e.Logger.Log("msg", "failed to add committed block", "hash", hash, "err", err)
+		e.failed[hash] = true
+		return false
+	}
+
+	e.Logger.Log("msg", "committed block", "hash", hash, "height", block.Height, "round", round)
+	if e.OnCommit != nil {
+		e.OnCommit(block)
+	}
+
+	e.enterHeight(e.Chain.Height() + 1)
+	return true
+}
+
+func (e *BFTEngine) vote(voteType core.VoteType, hash types.Hash) {
+	vote := &core.Vote{
+		Type:      voteType,
+		ChainID:   e.Chain.ChainID(),
+		Height:    e.height,
+		Round:     e.round,
+		BlockHash: hash,
+	}
+	if err := vote.Sign(e.PrivateKey); err != nil {
+		e.Logger.Log("msg", "failed to sign vote", "err", err)
+		return
+	}
+
+	e.broadcast(MessageTypeVote, &VoteMessage{Vote: vote})
+	e.handleVote(vote)
+}
+
+func (e *BFTEngine) broadcast(t MessageType, data any) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(data); err != nil {
+		e.Logger.Log("msg", "failed to encode message", "err", err)
+		return
+	}
+
+	if err := e.Broadcast(NewMessage(t, buf.Bytes()).Bytes()); err != nil {
+		e.Logger.Log("msg", "failed to broadcast message", "err", err)
+	}
+}
+
+func (e *BFTEngine) isValidator(key crypto.PublicKey) bool {
+	for _, validator := range e.Chain.Validators() {
+		if bytes.Equal(validator, key) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,406 @@
+package network
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/consensus"
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/types"
+	"github.com/gabrielluizsf/go-web3/util"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+type bftNode struct {
+	privKey crypto.PrivateKey
+	tr      *LocalTransport
+	chain   *core.Blockchain
+	// engine is nil for validators that are offline.
+	engine *BFTEngine
+	// proposals holds the proposals an offline validator received.
+	proposals []*core.Proposal
+}
+
+type manualClock struct {
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	return c.now
+}
+
+// newBFTNetwork returns n validators that are connected over local
+// transports. The validators at the given indexes stay silent.
+func newBFTNetwork(t *testing.T, n int, silent ...int) []*bftNode {
+	return newBFTNetworkWithClocks(t, make([]core.Clock, n), silent...)
+}
+
+// newBFTNetworkWithClocks is newBFTNetwork with a clock for every validator,
+// the chains of the validators without one use the system clock.
+func newBFTNetworkWithClocks(t *testing.T, clocks []core.Clock, silent ...int) []*bftNode {
+	n := len(clocks)
+	genesis := core.Genesis{Timestamp: time.Now().UnixNano()}
+	nodes := make([]*bftNode, n)
+	for i := range nodes {
+		nodes[i] = &bftNode{
+			privKey: crypto.GeneratePrivateKey(),
+			tr:      NewLocalTransport(&net.TCPAddr{Port: 3000 + i}),
+		}
+		genesis.Validators = append(genesis.Validators, nodes[i].privKey.PublicKey())
+	}
+
+	block, err := genesis.Block()
+	assert.Nil(t, err)
+
+	for i, node := range nodes {
+		chain, err := core.NewBlockchainWithOpts(log.NewNopLogger(), block, core.BlockchainOpts{
+			Genesis: genesis,
+			Engine:  consensus.BFT{},
+			Clock:   clocks[i],
+		})
+		assert.Nil(t, err)
+		node.chain = chain
+
+		for _, peer := range nodes {
+			if peer != node {
+				assert.Nil(t, node.tr.Connect(peer.tr))
+			}
+		}
+	}
+
+	isSilent := make(map[int]bool)
+	for _, i := range silent {
+		isSilent[i] = true
+	}
+
+	for i, node := range nodes {
+		if isSilent[i] {
+			continue
+		}
+
+		node := node
+		node.engine = NewBFTEngine(BFTOpts{
+			PrivateKey: node.privKey,
+			Chain:      node.chain,
+			Broadcast:  node.tr.Broadcast,
+			BuildBlock: func() (*core.Block, error) {
+				return util.ProposeBlock(t, node.chain, node.privKey, node.chain.Now()), nil
+			},
+		})
+	}
+
+	for _, node := range nodes {
+		if node.engine != nil {
+			node.engine.Start()
+		}
+	}
+
+	return nodes
+}
+
+// pump delivers messages until no node has anything left to process.
+func pump(t *testing.T, nodes []*bftNode) {
+	pumpFiltered(t, nodes, func(*bftNode, *DecodedMessage) bool { return false })
+}
+
+// pumpFiltered is pump but drops the messages for which drop returns true.
+func pumpFiltered(t *testing.T, nodes []*bftNode, drop func(to *bftNode, msg *DecodedMessage) bool) {
+	for {
+		delivered := false
+		for _, node := range nodes {
+			select {
+			case rpc := <-node.tr.Consume():
+				delivered = true
+				msg, err := DefaultRPCDecodeFunc(rpc)
+				assert.Nil(t, err)
+
+				if drop(node, msg) {
+					continue
+				}
+				if node.engine == nil {
+					if m, ok := msg.Data.(*ProposalMessage); ok {
+						node.proposals = append(node.proposals, m.Proposal)
+					}
+					continue
+				}
+				switch m := msg.Data.(type) {
+				case *ProposalMessage:
+					node.engine.ProcessProposal(m)
+				case *VoteMessage:
+					node.engine.ProcessVote(m)
+				}
+			default:
+			}
+		}
+
+		if !delivered {
+			return
+		}
+	}
+}
+
+// runUntil delivers messages and times out the rounds until every running
+// validator committed the given height or the attempts are used up.
+func runUntil(t *testing.T, nodes []*bftNode, height uint32, attempts int) {
+	for i := 0; i < attempts; i++ {
+		pump(t, nodes)
+
+		done := true
+		for _, node := range nodes {
+			if node.engine != nil && node.chain.Height() < height {
+				done = false
+			}
+		}
+		if done {
+			return
+		}
+
+		for _, node := range nodes {
+			if node.engine != nil {
+				node.engine.Timeout()
+			}
+		}
+	}
+}
+
+func assertSameCommittedChain(t *testing.T, nodes []*bftNode, height uint32) {
+	var reference *core.Blockchain
+	for _, node := range nodes {
+		if node.engine == nil {
+			continue
+		}
+		assert.Equal(t, height, node.chain.Height())
+
+		if reference == nil {
+			reference = node.chain
+			continue
+		}
+
+		for h := uint32(1); h <= height; h++ {
+			a, err := reference.GetBlock(h)
+			assert.Nil(t, err)
+			b, err := node.chain.GetBlock(h)
+			assert.Nil(t, err)
+			assert.Equal(t, a.Hash(core.BlockHasher{}), b.Hash(core.BlockHasher{}))
+
+			assert.NotNil(t, b.Commit)
+			assert.Nil(t, b.Commit.Verify(node.chain.ChainID(), node.chain.Validators()))
+		}
+	}
+}
+
+func TestBFTCommit(t *testing.T) {
+	nodes := newBFTNetwork(t, 4)
+
+	for height := uint32(1); height <= 5; height++ {
+		runUntil(t, nodes, height, 2)
+	}
+	assertSameCommittedChain(t, nodes, 5)
+
+	// Without faults every height is committed in the first round.
+	for height := uint32(1); height <= 5; height++ {
+		block, err := nodes[0].chain.GetBlock(height)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(0), block.Commit.Round)
+	}
+}
+
+func TestBFTSilentValidator(t *testing.T) {
+	nodes := newBFTNetwork(t, 4, 0)
+
+	runUntil(t, nodes, 8, 20)
+	assertSameCommittedChain(t, nodes, 8)
+
+	// The silent validator is the proposer of height 4, the block is
+	// committed in a later round.
+	block, err := nodes[1].chain.GetBlock(4)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(1), block.Commit.Round)
+}
+
+func TestBFTFaultyValidator(t *testing.T) {
+	nodes := newBFTNetwork(t, 4, 0)
+	faulty := nodes[0]
+
+	for height := uint32(1); height <= 4; height++ {
+		// The faulty validator proposes a block with a wrong state root and
+		// votes for a block that does not exist in every round.
+		block := util.ProposeBlock(t, nodes[1].chain, faulty.privKey, nodes[1].chain.Now())
+		block.StateRoot = types.Hash{1}
+		assert.Nil(t, block.Sign(faulty.privKey))
+
+		proposal := &core.Proposal{Round: 0, POLRound: -1, Block: block}
+		assert.Nil(t, proposal.Sign(faulty.privKey))
+		faulty.engine = nil
+		assert.Nil(t, faulty.tr.Broadcast(encodeTestMessage(t, MessageTypeProposal, &ProposalMessage{Proposal: proposal})))
+
+		for _, voteType := range []core.VoteType{core.VotePrevote, core.VotePrecommit} {
+			vote := &core.Vote{Type: voteType, Height: height, BlockHash: types.Hash{2}}
+			assert.Nil(t, vote.Sign(faulty.privKey))
+			assert.Nil(t, faulty.tr.Broadcast(encodeTestMessage(t, MessageTypeVote, &VoteMessage{Vote: vote})))
+		}
+
+		runUntil(t, nodes, height, 5)
+	}
+
+	assertSameCommittedChain(t, nodes, 4)
+}
+
+func TestBFTBadTimestamp(t *testing.T) {
+	nodes := newBFTNetwork(t, 4, 0)
+	faulty := nodes[0]
+
+	// The faulty validator is the proposer of height 4.
+	runUntil(t, nodes, 3, 20)
+
+	// It proposes a block that is not later than its parent. Voting for it
+	// would lock the validators on a block no chain accepts.
+	block := util.ProposeBlock(t, nodes[1].chain, faulty.privKey, nodes[1].chain.Now())
+	parent, err := nodes[1].chain.GetHeader(3)
+	assert.Nil(t, err)
+	block.Timestamp = parent.Timestamp
+	assert.Nil(t, block.Sign(faulty.privKey))
+
+	proposal := &core.Proposal{Round: 0, POLRound: -1, Block: block}
+	assert.Nil(t, proposal.Sign(faulty.privKey))
+	assert.Nil(t, faulty.tr.Broadcast(encodeTestMessage(t, MessageTypeProposal, &ProposalMessage{Proposal: proposal})))
+
+	runUntil(t, nodes, 4, 5)
+	assertSameCommittedChain(t, nodes, 4)
+
+	committed, err := nodes[1].chain.GetBlock(4)
+	assert.Nil(t, err)
+	assert.NotEqual(t, block.Hash(core.BlockHasher{}), committed.Hash(core.BlockHasher{}))
+	assert.Equal(t, uint32(1), committed.Commit.Round)
+}
+
+// TestBFTLockedValidators runs the round after a commit that only one honest
+// validator saw. Nodes 1 and 3 miss the precommits of round 0 and stay locked
+// on the block, node 2 is faulty: it proposes another block in round 1 and
+// prevotes nil, later it votes for every block but the locked one. Without
+// keeping their lock nodes 1 and 3 would commit a second block at height 1.
+func TestBFTLockedValidators(t *testing.T) {
+	nodes := newBFTNetwork(t, 4, 2)
+	committed, faulty := nodes[0], nodes[2]
+	locked := []*bftNode{nodes[1], nodes[3]}
+	chainID := committed.chain.ChainID()
+
+	// Only node 0 receives the precommits of round 0.
+	pumpFiltered(t, nodes, func(to *bftNode, msg *DecodedMessage) bool {
+		m, ok := msg.Data.(*VoteMessage)
+		return ok && m.Vote.Type == core.VotePrecommit && to != committed
+	})
+	assert.Equal(t, uint32(1), committed.chain.Height())
+	block, err := committed.chain.GetBlock(1)
+	assert.Nil(t, err)
+	blockHash := block.Hash(core.BlockHasher{})
+
+	// Node 0 moved on to the next height and is cut off from now on.
+	cutOff := func(to *bftNode, msg *DecodedMessage) bool {
+		return msg.From == committed.tr.Addr() || to == committed
+	}
+
+	faultyVote := func(voteType core.VoteType, round uint32, hash types.Hash) {
+		vote := &core.Vote{Type: voteType, ChainID: chainID, Height: 1, Round: round, BlockHash: hash}
+		assert.Nil(t, vote.Sign(faulty.privKey))
+		assert.Nil(t, faulty.tr.Broadcast(encodeTestMessage(t, MessageTypeVote, &VoteMessage{Vote: vote})))
+	}
+
+	voted := make(map[uint32]bool)
+	for i := 0; i < 30; i++ {
+		for _, node := range locked {
+			node.engine.Timeout()
+		}
+		pumpFiltered(t, nodes, cutOff)
+
+		_, round, _ := locked[0].engine.State()
+		if voted[round] {
+			continue
+		}
+		voted[round] = true
+
+		hash := types.Hash{}
+		if round == 1 {
+			other := util.ProposeBlock(t, faulty.chain, faulty.privKey, faulty.chain.Now())
+			proposal := &core.Proposal{ChainID: chainID, Round: round, POLRound: -1, Block: other}
+			assert.Nil(t, proposal.Sign(faulty.privKey))
+			assert.Nil(t, faulty.tr.Broadcast(encodeTestMessage(t, MessageTypeProposal, &ProposalMessage{Proposal: proposal})))
+		}
+		for _, proposal := range faulty.proposals {
+			if h := proposal.Block.Hash(core.BlockHasher{}); proposal.Round == round && h != blockHash {
+				hash = h
+			}
+		}
+		faultyVote(core.VotePrevote, round, hash)
+		faultyVote(core.VotePrecommit, round, hash)
+		pumpFiltered(t, nodes, cutOff)
+	}
+
+	_, round, _ := locked[0].engine.State()
+	assert.Greater(t, round, uint32(4))
+
+	// Once the precommits of round 0 arrive the locked nodes commit the same
+	// block as node 0.
+	for _, vote := range block.Commit.Precommits {
+		assert.Nil(t, faulty.tr.Broadcast(encodeTestMessage(t, MessageTypeVote, &VoteMessage{Vote: vote})))
+	}
+	pumpFiltered(t, nodes, cutOff)
+	assertSameCommittedChain(t, nodes, 1)
+}
+
+func TestBFTCommitFailure(t *testing.T) {
+	clock := &manualClock{now: time.Now()}
+	nodes := newBFTNetworkWithClocks(t, []core.Clock{nil, nil, nil, clock})
+	behind := nodes[3]
+
+	// The clock of node 3 jumps back once it voted for the block, so its
+	// chain rejects the committed block as being from the future.
+	runUntil(t, nodes[:3], 1, 2)
+	pumpFiltered(t, nodes, func(to *bftNode, msg *DecodedMessage) bool {
+		if vote, ok := msg.Data.(*VoteMessage); ok && to == behind && vote.Vote.Type == core.VotePrecommit {
+			clock.now = time.Now().Add(-time.Hour)
+		}
+		return false
+	})
+	assert.Equal(t, uint32(0), behind.chain.Height())
+
+	block, err := nodes[0].chain.GetBlock(1)
+	assert.Nil(t, err)
+	hash := block.Hash(core.BlockHasher{})
+
+	// The engine stays at the height with its lock and votes.
+	height, _, _ := behind.engine.State()
+	assert.Equal(t, uint32(1), height)
+	assert.Equal(t, hash, behind.engine.lockedHash())
+	precommits := behind.engine.votes[voteKey{round: 0, voteType: core.VotePrecommit}]
+	assert.Equal(t, 4, len(precommits))
+
+	// Once the chain synced the block the engine moves on.
+	clock.now = time.Now()
+	assert.Nil(t, behind.chain.AddBlock(block))
+	behind.engine.Timeout()
+	height, _, _ = behind.engine.State()
+	assert.Equal(t, uint32(2), height)
+}
+
+func TestBFTNoQuorum(t *testing.T) {
+	nodes := newBFTNetwork(t, 4, 0, 1)
+
+	// Two of four validators are not enough to commit anything.
+	runUntil(t, nodes, 1, 10)
+	for _, node := range nodes {
+		assert.Equal(t, uint32(0), node.chain.Height())
+	}
+}
+
+func encodeTestMessage(t *testing.T, messageType MessageType, data any) []byte {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, gob.NewEncoder(buf).Encode(data))
+
+	return NewMessage(messageType, buf.Bytes()).Bytes()
+}
@@ -0,0 +1,114 @@
+package network
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/types"
+)
+
+// signedSlot is a height a validator signed a block at.
+type signedSlot struct {
+	validator types.Address
+	height    uint32
+}
+
+// EvidencePool detects validators that sign two different blocks at the
+// same height and keeps the evidence until it is included in a block.
+type EvidencePool struct {
+	lock sync.RWMutex
+	// signed holds the first block every validator signed at every height.
+	signed  map[signedSlot]*core.Block
+	pending map[types.Hash]*core.DoubleSignEvidence
+}
+
+func NewEvidencePool() *EvidencePool {
+	return &EvidencePool{
+		signed:  make(map[signedSlot]*core.Block),
+		pending: make(map[types.Hash]*core.DoubleSignEvidence),
+	}
+}
+
+// Check remembers the block and returns evidence when its validator already
+// signed another block at the same height. Blocks with an invalid signature
+// are ignored.
+func (p *EvidencePool) Check(b *core.Block) *core.DoubleSignEvidence {
+	if b.Header == nil || b.SignedHeader().Verify() != nil {
+		return nil
+	}
+
+	slot := signedSlot{validator: b.Validator.Address(), height: b.Height}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	first, ok := p.signed[slot]
+	if !ok {
+		p.signed[slot] = b
+		return nil
+	}
+
+	evidence, err := core.NewDoubleSignEvidence(first, b)
+	if err != nil {
+		return nil
+	}
+
+	return evidence
+}
+
+// Add adds the evidence to the pool, it returns false when the pool already
+// holds it.
+func (p *EvidencePool) Add(evidence *core.DoubleSignEvidence) bool {
+	hash := evidence.Hash()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.pending[hash]; ok {
+		return false
+	}
+	p.pending[hash] = evidence
+
+	return true
+}
+
+func (p *EvidencePool) Remove(hash types.Hash) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.pending, hash)
+}
+
+// Pending returns the evidence in the pool ordered by height.
+func (p *EvidencePool) Pending() []*core.DoubleSignEvidence {
+	p.lock.RLock()
+	pending := make([]*core.DoubleSignEvidence, 0, len(p.pending))
+	for _, evidence := range p.pending {
+		pending = append(pending, evidence)
+	}
+	p.lock.RUnlock()
+
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].Height() != pending[j].Height() {
+			return pending[i].Height() < pending[j].Height()
+		}
+		a, b := pending[i].Hash(), pending[j].Hash()
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+
+	return pending
+}
+
+// Prune forgets the blocks signed below the given height.
+func (p *EvidencePool) Prune(height uint32) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for slot := range p.signed {
+		if slot.height < height {
+			delete(p.signed, slot)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func signedTestBlock(t *testing.T, privKey crypto.PrivateKey, height uint32, timestamp int64) *core.Block {
+	b, err := core.NewBlock(&core.Header{Version: 1, Height: height, Timestamp: timestamp}, []*core.Transaction{})
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}
+
+func TestEvidencePool(t *testing.T) {
+	var (
+		p       = NewEvidencePool()
+		privKey = crypto.GeneratePrivateKey()
+		now     = time.Now().UnixNano()
+		first   = signedTestBlock(t, privKey, 1, now)
+	)
+
+	assert.Nil(t, p.Check(first))
+	// Seeing the same block again is fine.
+	assert.Nil(t, p.Check(first))
+	// So is another validator at the same height.
+	assert.Nil(t, p.Check(signedTestBlock(t, crypto.GeneratePrivateKey(), 1, now+1)))
+
+	// A block with a broken signature is not evidence.
+	forged := signedTestBlock(t, privKey, 1, now+1)
+	forged.Signature = first.Signature
+	assert.Nil(t, p.Check(forged))
+
+	evidence := p.Check(signedTestBlock(t, privKey, 1, now+1))
+	assert.NotNil(t, evidence)
+	assert.Equal(t, privKey.PublicKey(), evidence.Offender())
+
+	assert.True(t, p.Add(evidence))
+	assert.False(t, p.Add(evidence))
+	assert.Equal(t, []*core.DoubleSignEvidence{evidence}, p.Pending())
+
+	p.Remove(evidence.Hash())
+	assert.Empty(t, p.Pending())
+
+	// Pruned heights are forgotten, so the next block counts as the first.
+	p.Prune(2)
+	assert.Nil(t, p.Check(signedTestBlock(t, privKey, 1, now+2)))
+}
+
+func TestEvidenceMessage(t *testing.T) {
+	var (
+		privKey = crypto.GeneratePrivateKey()
+		a       = signedTestBlock(t, privKey, 1, 1)
+		b       = signedTestBlock(t, privKey, 1, 2)
+	)
+	evidence, err := core.NewDoubleSignEvidence(a, b)
+	assert.Nil(t, err)
+
+	payload := encodeTestMessage(t, MessageTypeEvidence, &EvidenceMessage{Evidence: evidence})
+	msg, err := DefaultRPCDecodeFunc(RPC{Payload: bytes.NewReader(payload)})
+	assert.Nil(t, err)
+
+	decoded, ok := msg.Data.(*EvidenceMessage)
+	assert.True(t, ok)
+	assert.Nil(t, decoded.Evidence.Verify())
+	assert.Equal(t, evidence.Hash(), decoded.Evidence.Hash())
+}
@@ -20,3 +20,18 @@ type StatusMessage struct {
 	Version       uint32
 	CurrentHeight uint32
 }
+
+// ProposalMessage carries the block the proposer of a round proposes.
+type ProposalMessage struct {
+	Proposal *core.Proposal
+}
+
+// VoteMessage carries a prevote or precommit of a validator.
+type VoteMessage struct {
+	Vote *core.Vote
+}
+
+// EvidenceMessage carries evidence of a validator that double signed.
+type EvidenceMessage struct {
+	Evidence *core.DoubleSignEvidence
+}
@@ -21,6 +21,9 @@ const (
 	MessageTypeStatus      MessageType = 0x4
 	MessageTypeGetStatus   MessageType = 0x5
 	MessageTypeBlocks      MessageType = 0x6
+	MessageTypeProposal    MessageType = 0x7
+	MessageTypeVote        MessageType = 0x8
+	MessageTypeEvidence    MessageType = 0x9
 )
 
 type RPC struct {
@@ -128,6 +131,39 @@ func DefaultRPCDecodeFunc(rpc RPC) (*DecodedMessage, error) {
 			Data: blocks,
 		}, nil
 
+	case MessageTypeProposal:
+		proposal := new(ProposalMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(proposal); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: proposal,
+		}, nil
+
+	case MessageTypeVote:
+		vote := new(VoteMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(vote); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: vote,
+		}, nil
+
+	case MessageTypeEvidence:
+		evidence := new(EvidenceMessage)
+		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(evidence); err != nil {
+			return nil, err
+		}
+
+		return &DecodedMessage{
+			From: rpc.From,
+			Data: evidence,
+		}, nil
+
 	default:
 		return nil, fmt.Errorf("invalid message header %x", msg.Header)
 	}
@@ -3,13 +3,16 @@ package network
 import (
 	"bytes"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/gabrielluizsf/go-web3/api"
+	"github.com/gabrielluizsf/go-web3/consensus"
 	"github.com/gabrielluizsf/go-web3/core"
 	"github.com/gabrielluizsf/go-web3/crypto"
 	"github.com/gabrielluizsf/go-web3/types"
@@ -18,6 +21,25 @@ import (
 
 var defaultBlockTime = 5 * time.Second
 
+// signedBlocksWindow is the number of heights below the head for which the
+// signed blocks are kept to detect double signs.
+const signedBlocksWindow = 256
+
+// Consensus selects how the nodes agree on the next block.
+type Consensus byte
+
+const (
+	// ConsensusPoA lets the genesis validators take turns proposing blocks.
+	ConsensusPoA Consensus = iota
+	// ConsensusBFT commits every block with the votes of the genesis
+	// validators instead of adding it as soon as the proposer created it.
+	// Blocks are final once committed.
+	ConsensusBFT
+	// ConsensusPoW lets every node with a PrivateKey mine blocks, the
+	// branch with the most cumulative work wins.
+	ConsensusPoW
+)
+
 type ServerOpts struct {
 	APIListenAddr string
 	SeedNodes     []string
@@ -28,7 +50,37 @@ type ServerOpts struct {
 	RPCDecodeFunc RPCDecodeFunc
 	RPCProcessor  RPCProcessor
 	BlockTime     time.Duration
-	PrivateKey    *crypto.PrivateKey
+	// ProposerTimeout is how long the validators wait for the proposer of
+	// a block before the next validator takes over. Defaults to three
+	// times the BlockTime.
+	ProposerTimeout time.Duration
+	PrivateKey      *crypto.PrivateKey
+	// DataDir is the directory where the node persists its blocks.
+	// When empty the blocks are only kept in memory.
+	DataDir string
+	// SnapshotInterval is the number of blocks between two state snapshots.
+	// Snapshots are only written when a DataDir is configured.
+	SnapshotInterval uint32
+	// BlockReward is minted to the validator of every block.
+	BlockReward uint64
+	// Genesis is the specification the genesis block is derived from,
+	// every node of the network has to use the same.
+	Genesis core.Genesis
+	// Consensus selects the consensus engine. Defaults to ConsensusPoA.
+	Consensus Consensus
+	// Engine replaces the engine the Consensus mode would use. Blocks are
+	// produced with it unless the mode is ConsensusBFT, which runs its own
+	// protocol.
+	Engine consensus.Engine
+	// RetargetInterval is the number of blocks between two difficulty
+	// adjustments of ConsensusPoW, which aims for one block per BlockTime.
+	RetargetInterval uint32
+	// Clock is the local time the timestamps of blocks are checked
+	// against. Defaults to the system clock.
+	Clock core.Clock
+	// MaxClockDrift is how far the timestamp of a block may be ahead of
+	// the Clock.
+	MaxClockDrift time.Duration
 }
 
 type Server struct {
@@ -40,17 +92,25 @@ type Server struct {
 
 	ServerOpts
 	mempool         *TransactionPool
+	evidence        *EvidencePool
 	chain           *core.Blockchain
 	isValidator     bool
+	bft             *BFTEngine
 	rpcCh           chan RPC
 	quitCh          chan struct{}
+	doneCh          chan struct{}
+	stopOnce        sync.Once
 	TransactionChan chan *core.Transaction
 }
 
-func NewServer(opts ServerOpts) (*Server, error) {
+// withDefaults returns the opts with every unset field set to its default.
+func (opts ServerOpts) withDefaults() ServerOpts {
 	if opts.BlockTime == time.Duration(0) {
 		opts.BlockTime = defaultBlockTime
 	}
+	if opts.ProposerTimeout == time.Duration(0) {
+		opts.ProposerTimeout = 3 * opts.BlockTime
+	}
 	if opts.RPCDecodeFunc == nil {
 		opts.RPCDecodeFunc = DefaultRPCDecodeFunc
 	}
@@ -59,7 +119,79 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		opts.Logger = log.With(opts.Logger, "addr", opts.ID)
 	}
 
-	chain, err := core.NewBlockchain(opts.Logger, genesisBlock())
+	return opts
+}
+
+// chainOpts returns the options of the chain of the node, the blocks are
+// stored in the DataDir when one is set.
+func (opts ServerOpts) chainOpts() (core.BlockchainOpts, error) {
+	chainOpts := core.BlockchainOpts{
+		Store:            core.NewMemoryStore(),
+		SnapshotInterval: opts.SnapshotInterval,
+		BlockReward:      opts.BlockReward,
+		Genesis:          opts.Genesis,
+		Engine:           opts.Engine,
+		Clock:            opts.Clock,
+		MaxClockDrift:    opts.MaxClockDrift,
+	}
+	if chainOpts.Engine == nil {
+		chainOpts.Engine = newEngine(opts)
+	}
+	if len(opts.DataDir) > 0 {
+		fileStore, err := core.NewFileStore(filepath.Join(opts.DataDir, "blocks"), core.FileStoreOpts{})
+		if err != nil {
+			return core.BlockchainOpts{}, err
+		}
+		chainOpts.Store = fileStore
+
+		snapshots, err := core.NewSnapshotStore(filepath.Join(opts.DataDir, "snapshots"), 0)
+		if err != nil {
+			fileStore.Close()
+			return core.BlockchainOpts{}, err
+		}
+		chainOpts.Snapshots = snapshots
+	}
+
+	return chainOpts, nil
+}
+
+// VerifyStore checks the blocks stored in the DataDir of the opts without
+// executing them and returns the height of the best branch. No node is
+// started, so the store can be checked before the node is run on it.
+func VerifyStore(opts ServerOpts) (uint32, error) {
+	if len(opts.DataDir) == 0 {
+		return 0, errors.New("verifying the store needs a data dir")
+	}
+	opts = opts.withDefaults()
+
+	chainOpts, err := opts.chainOpts()
+	if err != nil {
+		return 0, err
+	}
+	defer chainOpts.Store.Close()
+
+	genesis, err := opts.Genesis.Block()
+	if err != nil {
+		return 0, err
+	}
+
+	return core.VerifyStore(opts.Logger, genesis, chainOpts)
+}
+
+func NewServer(opts ServerOpts) (*Server, error) {
+	opts = opts.withDefaults()
+
+	chainOpts, err := opts.chainOpts()
+	if err != nil {
+		return nil, err
+	}
+
+	genesis, err := opts.Genesis.Block()
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := core.NewBlockchainWithOpts(opts.Logger, genesis, chainOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -89,28 +221,60 @@ func NewServer(opts ServerOpts) (*Server, error) {
 		peerMap:         make(map[net.Addr]*TCPPeer),
 		ServerOpts:      opts,
 		chain:           chain,
-		mempool:         NewTransactionPool(1000),
+		mempool:         NewTransactionPoolWithParams(1000, chain.ConsensusParams()),
+		evidence:        NewEvidencePool(),
 		isValidator:     opts.PrivateKey != nil,
 		rpcCh:           make(chan RPC),
-		quitCh:          make(chan struct{}, 1),
+		quitCh:          make(chan struct{}),
+		doneCh:          make(chan struct{}),
 		TransactionChan: TransactionChan,
 	}
 
 	s.TCPTransport.peerCh = peerCh
 
+	s.chain.SubscribeReorg(s.handleReorg)
+
 	// If we dont got any processor from the server options, we going to use
 	// the server as default.
 	if s.RPCProcessor == nil {
 		s.RPCProcessor = s
 	}
 
-	if s.isValidator {
+	if s.isValidator && s.Consensus == ConsensusBFT {
+		s.bft = NewBFTEngine(BFTOpts{
+			Logger:     s.Logger,
+			PrivateKey: *s.PrivateKey,
+			Chain:      s.chain,
+			Broadcast:  s.broadcast,
+			BuildBlock: s.buildBlock,
+			OnCommit:   s.handleCommit,
+		})
+		go s.bftLoop()
+	} else if s.isValidator {
 		go s.validatorLoop()
 	}
 
 	return s, nil
 }
 
+// newEngine returns the consensus engine of the Consensus mode.
+func newEngine(opts ServerOpts) consensus.Engine {
+	switch opts.Consensus {
+	case ConsensusBFT:
+		return consensus.BFT{}
+	case ConsensusPoW:
+		return consensus.PoW{
+			TargetBlockTime:  opts.BlockTime,
+			RetargetInterval: opts.RetargetInterval,
+		}
+	default:
+		return consensus.PoA{
+			Period:  opts.BlockTime,
+			Timeout: opts.ProposerTimeout,
+		}
+	}
+}
+
 func (s *Server) bootstrapNetwork() {
 	for _, addr := range s.SeedNodes {
 		fmt.Println("trying to connect to ", addr)
@@ -177,24 +341,89 @@ free:
 	}
 
 	s.Logger.Log("msg", "Server is shutting down")
+
+	// Closing the chain flushes the blocks the store has not synced yet.
+	if err := s.chain.Close(); err != nil {
+		s.Logger.Log("msg", "failed to close the chain", "err", err)
+	}
+	close(s.doneCh)
+}
+
+// Stop shuts the server down and waits until Start closed the chain, so it
+// must only be called for a server that was started.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.quitCh)
+	})
+	<-s.doneCh
 }
 
 func (s *Server) validatorLoop() {
+	s.Logger.Log("msg", "Starting validator loop", "blockTime", s.BlockTime)
+
+	for {
+		// The engine decides when a block can be sealed, a node that is not
+		// the proposer waits a block time before it tries again.
+		err := s.createNewBlock()
+		switch {
+		case err == nil, errors.Is(err, consensus.ErrSealAborted):
+			continue
+		case !errors.Is(err, consensus.ErrNotProposer):
+			s.Logger.Log("create block error", err)
+		}
+
+		select {
+		case <-s.quitCh:
+			return
+		case <-time.After(s.BlockTime):
+		}
+	}
+}
+
+// bftLoop runs the consensus engine. A new height starts every BlockTime
+// after the last commit and a step times out when the engine made no
+// progress for a whole ProposerTimeout.
+func (s *Server) bftLoop() {
 	ticker := time.NewTicker(s.BlockTime)
+	defer ticker.Stop()
 
-	s.Logger.Log("msg", "Starting validator loop", "blockTime", s.BlockTime)
+	s.Logger.Log("msg", "Starting BFT consensus", "blockTime", s.BlockTime, "timeout", s.ProposerTimeout)
+
+	s.bft.Start()
+
+	var (
+		height, round, step = s.bft.State()
+		progress            = time.Now()
+	)
 
 	for {
-		fmt.Println("creating new block")
+		select {
+		case <-s.quitCh:
+			return
+		case <-ticker.C:
+		}
 
-		if err := s.createNewBlock(); err != nil {
-			s.Logger.Log("create block error", err)
+		h, r, st := s.bft.State()
+		if h != height || r != round || st != step {
+			height, round, step = h, r, st
+			progress = time.Now()
 		}
 
-		<-ticker.C
+		if step == StepNewHeight || time.Since(progress) >= s.ProposerTimeout {
+			s.bft.Timeout()
+			height, round, step = s.bft.State()
+			progress = time.Now()
+		}
 	}
 }
 
+// handleCommit is called by the consensus engine for every committed block.
+func (s *Server) handleCommit(b *core.Block) {
+	s.mempool.RemovePending(b.Transactions)
+
+	go s.broadcastBlock(b)
+}
+
 func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 	switch t := msg.Data.(type) {
 	case *core.Transaction:
@@ -209,6 +438,18 @@ func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 		return s.processGetBlocksMessage(msg.From, t)
 	case *BlocksMessage:
 		return s.processBlocksMessage(msg.From, t)
+	case *ProposalMessage:
+		if s.bft != nil {
+			return s.bft.ProcessProposal(t)
+		}
+	case *VoteMessage:
+		if s.bft != nil {
+			return s.bft.ProcessVote(t)
+		}
+	case *EvidenceMessage:
+		if t.Evidence != nil {
+			return s.processEvidence(t.Evidence)
+		}
 	}
 
 	return nil
@@ -332,6 +573,17 @@ func (s *Server) processGetStatusMessage(from net.Addr, data *GetStatusMessage)
 }
 
 func (s *Server) processBlock(b *core.Block) error {
+	// A block that conflicts with another block of its validator is
+	// evidence, whether the chain accepts it or not.
+	if evidence := s.evidence.Check(b); evidence != nil {
+		if err := s.processEvidence(evidence); err != nil {
+			s.Logger.Log("msg", "invalid double sign evidence", "err", err)
+		}
+	}
+	if height := s.chain.Height(); height > signedBlocksWindow {
+		s.evidence.Prune(height - signedBlocksWindow)
+	}
+
 	if err := s.chain.AddBlock(b); err != nil {
 		s.Logger.Log("error", err.Error())
 		return err
@@ -342,6 +594,22 @@ func (s *Server) processBlock(b *core.Block) error {
 	return nil
 }
 
+// handleReorg puts the transactions of the blocks that were removed from
+// the chain back into the mempool so they can be included again.
+func (s *Server) handleReorg(event *core.ReorgEvent) {
+	s.Logger.Log(
+		"msg", "chain reorganized",
+		"ancestor", event.CommonAncestor.Hash(core.BlockHasher{}),
+		"removed", len(event.Removed),
+		"added", len(event.Added),
+		"evicted", len(event.Evicted),
+	)
+
+	for _, transaction := range event.Evicted {
+		s.mempool.Requeue(transaction)
+	}
+}
+
 func (s *Server) processTransaction(transaction *core.Transaction) error {
 	hash := transaction.Hash(core.TransactionHasher{})
 
@@ -349,16 +617,71 @@ func (s *Server) processTransaction(transaction *core.Transaction) error {
 		return nil
 	}
 
+	if transaction.ChainID != s.chain.ChainID() {
+		return fmt.Errorf("transaction (%s) has chain id (%d) expected (%d)", hash, transaction.ChainID, s.chain.ChainID())
+	}
+
 	if err := transaction.Verify(); err != nil {
 		return err
 	}
+
+	if nonce := s.chain.GetNonce(transaction.From.Address()); transaction.Nonce < nonce {
+		return fmt.Errorf("transaction (%s) has nonce (%d) which is already used, next nonce is (%d)", hash, transaction.Nonce, nonce)
+	}
+
+	if err := s.mempool.Add(transaction); err != nil {
+		return err
+	}
+
 	go s.broadcastTransaction(transaction)
 
-	s.mempool.Add(transaction)
+	return nil
+}
+
+// processEvidence adds new evidence of a validator of the chain that double
+// signed to the evidence pool and gossips it, it is included in the next
+// block this node builds.
+func (s *Server) processEvidence(evidence *core.DoubleSignEvidence) error {
+	if err := evidence.Verify(); err != nil {
+		return err
+	}
+
+	if chainID := evidence.A.Header.ChainID; chainID != s.chain.ChainID() {
+		return fmt.Errorf("evidence (%s) has chain id (%d) expected (%d)", evidence.Hash(), chainID, s.chain.ChainID())
+	}
+
+	offender := evidence.Offender()
+	if !s.isChainValidator(offender) {
+		stake, err := s.chain.GetStake(offender.Address())
+		if err != nil {
+			return err
+		}
+		if stake == 0 {
+			return fmt.Errorf("evidence (%s) is against (%s) which is not a validator", evidence.Hash(), offender.Address())
+		}
+	}
+
+	if s.chain.HasEvidence(evidence) || !s.evidence.Add(evidence) {
+		return nil
+	}
+
+	s.Logger.Log("msg", "validator double signed", "validator", offender.Address(), "height", evidence.Height())
+
+	go s.broadcastEvidence(evidence)
 
 	return nil
 }
 
+func (s *Server) isChainValidator(key crypto.PublicKey) bool {
+	for _, validator := range s.chain.Validators() {
+		if bytes.Equal(validator, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // TODO: Find a way to make sure we dont keep syncing when we are at the highest
 // block height in the network.
 func (s *Server) requestBlocksLoop(peer net.Addr) error {
@@ -408,6 +731,17 @@ func (s *Server) broadcastBlock(b *core.Block) error {
 	return s.broadcast(msg.Bytes())
 }
 
+func (s *Server) broadcastEvidence(evidence *core.DoubleSignEvidence) error {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(&EvidenceMessage{Evidence: evidence}); err != nil {
+		return err
+	}
+
+	msg := NewMessage(MessageTypeEvidence, buf.Bytes())
+
+	return s.broadcast(msg.Bytes())
+}
+
 func (s *Server) broadcastTransaction(transaction *core.Transaction) error {
 	buf := &bytes.Buffer{}
 	if err := transaction.Encode(core.NewGobTransactionEncoder(buf)); err != nil {
@@ -419,24 +753,138 @@ func (s *Server) broadcastTransaction(transaction *core.Transaction) error {
 	return s.broadcast(msg.Bytes())
 }
 
+// blockSpace keeps track of how many more transactions fit into a block.
+type blockSpace struct {
+	transactions int
+	bytes        int
+	gas          uint64
+}
+
+func newBlockSpace(params core.ConsensusParams) *blockSpace {
+	return &blockSpace{
+		transactions: params.MaxTransactions,
+		bytes:        params.MaxBlockBytes,
+		gas:          params.MaxBlockGas,
+	}
+}
+
+// take reserves the space and the gas of the transaction, it reports false
+// when the transaction does not fit.
+func (b *blockSpace) take(transaction *core.Transaction) bool {
+	size := transaction.Size()
+	if b.transactions == 0 || size > b.bytes || transaction.GasLimit > b.gas {
+		return false
+	}
+
+	b.transactions--
+	b.bytes -= size
+	b.gas -= transaction.GasLimit
+
+	return true
+}
+
+// executableTransactions returns the transactions whose nonces follow the
+// nonce of their sender without any gap and whose fees the sender can pay,
+// as long as they fit into the block. The others have to wait.
+func (s *Server) executableTransactions(pending []*core.Transaction, space *blockSpace) []*core.Transaction {
+	var (
+		transactions = []*core.Transaction{}
+		nonces       = make(map[types.Address]uint64)
+		balances     = make(map[types.Address]uint64)
+	)
+
+	for _, transaction := range pending {
+		from := transaction.From.Address()
+		nonce, ok := nonces[from]
+		if !ok {
+			nonce = s.chain.GetNonce(from)
+			// Unknown accounts have no balance.
+			balances[from], _ = s.chain.GetBalance(from)
+		}
+
+		if transaction.Nonce != nonce {
+			continue
+		}
+
+		fee, err := transaction.TotalFee()
+		if err != nil || fee > balances[from] {
+			continue
+		}
+
+		// The nonce is not taken, so the later transactions of the sender
+		// are left out as well.
+		if !space.take(transaction) {
+			continue
+		}
+
+		transactions = append(transactions, transaction)
+		nonces[from] = nonce + 1
+		balances[from] -= fee
+	}
+
+	return transactions
+}
+
+// evidenceTransactions wraps the evidence of the pool that still has to be
+// punished into transactions sent by this node, their nonces follow the
+// ones of the given transactions. Evidence the chain already punished or
+// that is too old is dropped from the pool.
+func (s *Server) evidenceTransactions(height uint32, included []*core.Transaction, space *blockSpace) []*core.Transaction {
+	var (
+		from         = s.PrivateKey.PublicKey().Address()
+		nonce        = s.chain.GetNonce(from)
+		period       = s.Genesis.Staking.UnbondingPeriod
+		transactions = []*core.Transaction{}
+	)
+
+	for _, transaction := range included {
+		if transaction.From.Address() == from && transaction.Nonce >= nonce {
+			nonce = transaction.Nonce + 1
+		}
+	}
+
+	for _, evidence := range s.evidence.Pending() {
+		if s.chain.HasEvidence(evidence) || (period > 0 && height-evidence.Height() > period) {
+			s.evidence.Remove(evidence.Hash())
+			continue
+		}
+
+		transaction := core.NewTransaction(nil)
+		transaction.TransactionInner = *evidence
+		transaction.Nonce = nonce
+		transaction.ChainID = s.chain.ChainID()
+		if err := transaction.Sign(*s.PrivateKey); err != nil {
+			s.Logger.Log("msg", "failed to sign evidence", "err", err)
+			continue
+		}
+		if !space.take(transaction) {
+			break
+		}
+
+		transactions = append(transactions, transaction)
+		nonce++
+	}
+
+	return transactions
+}
+
 func (s *Server) createNewBlock() error {
 	currentHeader, err := s.chain.GetHeader(s.chain.Height())
 	if err != nil {
 		return err
 	}
 
-	// For now we are going to use all transactions that are in the pending pool
-	// Later on when we know the internal structure of our transaction
-	// we will implement some kind of complexity function to determine how
-	// many transactions can be included in a block.
-	transactions := s.mempool.Pending()
-
-	block, err := core.NewBlockFromPrevHeader(currentHeader, transactions)
+	block, err := s.newBlock(currentHeader)
 	if err != nil {
 		return err
 	}
 
-	if err := block.Sign(*s.PrivateKey); err != nil {
+	// Sealing is given up as soon as another block extends the chain.
+	stop, done := make(chan struct{}), make(chan struct{})
+	go s.stopOnNewHead(currentHeader, stop, done)
+	err = s.chain.Engine().Seal(s.chain, block, *s.PrivateKey, stop)
+	close(done)
+	if err != nil {
 		return err
 	}
 
@@ -446,34 +894,77 @@ func (s *Server) createNewBlock() error {
 
 	// TODO(@anthdm): pending pool of Transaction should only reflect on validator nodes.
 	// Right now "normal nodes" does not have their pending pool cleared.
-	s.mempool.ClearPending()
+	s.mempool.RemovePending(block.Transactions)
 
 	go s.broadcastBlock(block)
 
 	return nil
 }
 
-func genesisBlock() *core.Block {
-	header := &core.Header{
-		Version:   1,
-		DataHash:  types.Hash{},
-		Height:    0,
-		Timestamp: 000000,
+// stopOnNewHead closes stop once the given header is no longer the head of
+// the chain, unless done is closed first.
+func (s *Server) stopOnNewHead(head *core.Header, stop chan struct{}, done <-chan struct{}) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if current, err := s.chain.GetHeader(s.chain.Height()); err == nil && current != head {
+				close(stop)
+				return
+			}
+		}
 	}
+}
 
-	b, _ := core.NewBlock(header, nil)
+// buildBlock returns a new sealed block on top of the head of the chain, it
+// is proposed by the BFT engine.
+func (s *Server) buildBlock() (*core.Block, error) {
+	currentHeader, err := s.chain.GetHeader(s.chain.Height())
+	if err != nil {
+		return nil, err
+	}
 
-	coinbase := crypto.PublicKey{}
-	transaction := core.NewTransaction(nil)
-	transaction.From = coinbase
-	transaction.To = coinbase
-	transaction.Value = 10_000_000
-	b.Transactions = append(b.Transactions, transaction)
+	block, err := s.newBlock(currentHeader)
+	if err != nil {
+		return nil, err
+	}
 
-	privKey := crypto.GeneratePrivateKey()
-	if err := b.Sign(privKey); err != nil {
-		panic(err)
+	if err := s.chain.Engine().Seal(s.chain, block, *s.PrivateKey, nil); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// newBlock returns a block on top of the given header with the executable
+// transactions of the mempool that fit into it. The block is prepared by the
+// consensus engine but not sealed yet.
+func (s *Server) newBlock(prevHeader *core.Header) (*core.Block, error) {
+	// The transactions are taken in the order of the pending pool until the
+	// block is full, evidence gets the space that is left.
+	space := newBlockSpace(s.chain.ConsensusParams())
+	transactions := s.executableTransactions(s.mempool.Pending(), space)
+	transactions = append(transactions, s.evidenceTransactions(prevHeader.Height+1, transactions, space)...)
+
+	block, err := core.NewBlockFromPrevHeader(prevHeader, transactions, s.chain.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.chain.Engine().Prepare(s.chain, block.Header, prevHeader); err != nil {
+		return nil, err
+	}
+
+	// The fees of the block are paid to the validator while calculating
+	// the state root.
+	block.Validator = s.PrivateKey.PublicKey()
+	if err := s.chain.SetStateRoot(block); err != nil {
+		return nil, err
 	}
 
-	return b
+	return block, nil
 }
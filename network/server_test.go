@@ -0,0 +1,116 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/gabrielluizsf/go-web3/consensus"
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/gabrielluizsf/go-web3/util"
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutableTransactionsFitBlock(t *testing.T) {
+	var (
+		alice = crypto.GeneratePrivateKey()
+		bob   = crypto.GeneratePrivateKey()
+		carol = crypto.GeneratePrivateKey()
+	)
+
+	genesis := core.Genesis{}
+	block, err := genesis.Block()
+	assert.Nil(t, err)
+	chain, err := core.NewBlockchain(log.NewNopLogger(), block)
+	assert.Nil(t, err)
+	s := &Server{chain: chain}
+
+	small := func(privKey crypto.PrivateKey, nonce uint64) *core.Transaction {
+		transaction := core.NewTransaction(nil)
+		transaction.Nonce = nonce
+		assert.Nil(t, transaction.Sign(privKey))
+		return transaction
+	}
+
+	var (
+		alice0 = small(alice, 0)
+		bob0   = util.NewRandomTransactionWithSignature(t, bob, 1000)
+		alice1 = small(alice, 1)
+		bob1   = small(bob, 1)
+		carol0 = small(carol, 0)
+	)
+	pending := []*core.Transaction{alice0, bob0, alice1, bob1, carol0}
+
+	// The large transaction of bob does not fit, so his next one has to
+	// wait as well.
+	space := newBlockSpace(core.ConsensusParams{
+		MaxBlockBytes:   alice0.Size() + alice1.Size() + carol0.Size(),
+		MaxTransactions: 10,
+	})
+	assert.Equal(t, []*core.Transaction{alice0, alice1, carol0}, s.executableTransactions(pending, space))
+	assert.False(t, space.take(alice0))
+
+	space = newBlockSpace(core.ConsensusParams{MaxBlockBytes: 1 << 20, MaxTransactions: 2})
+	assert.Equal(t, []*core.Transaction{alice0, bob0}, s.executableTransactions(pending, space))
+}
+
+func TestBlockSpaceGas(t *testing.T) {
+	space := newBlockSpace(core.ConsensusParams{MaxBlockGas: 100}.WithDefaults())
+
+	transaction := core.NewTransaction(nil)
+	transaction.GasLimit = 60
+	assert.True(t, space.take(transaction))
+	assert.False(t, space.take(transaction))
+
+	transaction.GasLimit = 40
+	assert.True(t, space.take(transaction))
+	assert.Equal(t, uint64(0), space.gas)
+}
+
+func TestServerStopClosesChain(t *testing.T) {
+	s, err := NewServer(ServerOpts{
+		ID:         "TEST",
+		ListenAddr: "127.0.0.1:0",
+		DataDir:    t.TempDir(),
+		Engine:     consensus.NoOp{},
+	})
+	assert.Nil(t, err)
+
+	go s.Start()
+	s.Stop()
+
+	// The store of a closed chain takes no more blocks.
+	b := util.ProposeBlock(t, s.chain, crypto.GeneratePrivateKey(), s.chain.Now())
+	assert.ErrorIs(t, s.chain.AddBlock(b), core.ErrStoreClosed)
+}
+
+func TestVerifyStore(t *testing.T) {
+	opts := ServerOpts{
+		ID:         "TEST",
+		ListenAddr: "127.0.0.1:0",
+		DataDir:    t.TempDir(),
+		Engine:     consensus.NoOp{},
+	}
+	s, err := NewServer(opts)
+	assert.Nil(t, err)
+
+	go s.Start()
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, s.chain.AddBlock(util.ProposeBlock(t, s.chain, crypto.GeneratePrivateKey(), s.chain.Now())))
+	}
+	s.Stop()
+
+	height, err := VerifyStore(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), height)
+
+	// The node can be started on the store again once it is verified.
+	s, err = NewServer(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), s.chain.Height())
+	assert.Nil(t, s.chain.Close())
+
+	opts.DataDir = ""
+	_, err = VerifyStore(opts)
+	assert.NotNil(t, err)
+}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"sort"
 	"sync"
 
 	"github.com/gabrielluizsf/go-web3/core"
@@ -13,17 +14,30 @@ type TransactionPool struct {
 	// The maxLength of the total pool of transactions.
 	// When the pool is full we will prune the oldest transaction.
 	maxLength int
+	// Transactions that can never fit into a block are rejected.
+	params core.ConsensusParams
 }
 
 func NewTransactionPool(maxLength int) *TransactionPool {
+	return NewTransactionPoolWithParams(maxLength, core.ConsensusParams{})
+}
+
+// NewTransactionPoolWithParams returns a pool that rejects transactions
+// that exceed the given limits of the chain.
+func NewTransactionPoolWithParams(maxLength int, params core.ConsensusParams) *TransactionPool {
 	return &TransactionPool{
 		all:       NewTransactionSortedMap(),
 		pending:   NewTransactionSortedMap(),
 		maxLength: maxLength,
+		params:    params.WithDefaults(),
 	}
 }
 
-func (p *TransactionPool) Add(transaction *core.Transaction) {
+func (p *TransactionPool) Add(transaction *core.Transaction) error {
+	if err := p.params.ValidateTransaction(transaction); err != nil {
+		return err
+	}
+
 	// prune the oldest transaction that is sitting in the all pool
 	if p.all.Count() == p.maxLength {
 		oldest := p.all.First()
@@ -34,21 +48,75 @@ func (p *TransactionPool) Add(transaction *core.Transaction) {
 		p.all.Add(transaction)
 		p.pending.Add(transaction)
 	}
+
+	return nil
+}
+
+// Requeue puts a transaction back into the pending pool, for example after
+// the block that included it was removed from the chain by a reorg.
+func (p *TransactionPool) Requeue(transaction *core.Transaction) {
+	hash := transaction.Hash(core.TransactionHasher{})
+	if !p.all.Contains(hash) {
+		// The transaction was part of a valid block, so it fits.
+		p.Add(transaction)
+		return
+	}
+
+	if !p.pending.Contains(hash) {
+		p.pending.Add(transaction)
+	}
 }
 
 func (p *TransactionPool) Contains(hash types.Hash) bool {
 	return p.all.Contains(hash)
 }
 
-// Pending returns a slice of transactions that are in the pending pool
+// Pending returns a slice of transactions that are in the pending pool.
+// The transactions of every sender are ordered by nonce, the positions they
+// take in the slice are the positions in which the sender's transactions
+// arrived.
 func (p *TransactionPool) Pending() []*core.Transaction {
-	return p.pending.transactions.Data
+	p.pending.lock.RLock()
+	transactions := make([]*core.Transaction, len(p.pending.transactions.Data))
+	copy(transactions, p.pending.transactions.Data)
+	p.pending.lock.RUnlock()
+
+	positions := make(map[types.Address][]int)
+	for i, transaction := range transactions {
+		from := transaction.From.Address()
+		positions[from] = append(positions[from], i)
+	}
+
+	sorted := make([]*core.Transaction, len(transactions))
+	for _, indexes := range positions {
+		sender := make([]*core.Transaction, len(indexes))
+		for i, index := range indexes {
+			sender[i] = transactions[index]
+		}
+		sort.SliceStable(sender, func(i, j int) bool {
+			return sender[i].Nonce < sender[j].Nonce
+		})
+
+		for i, index := range indexes {
+			sorted[index] = sender[i]
+		}
+	}
+
+	return sorted
 }
 
 func (p *TransactionPool) ClearPending() {
 	p.pending.Clear()
 }
 
+// RemovePending removes the given transactions from the pending pool, the
+// ones that did not make it into a block stay pending.
+func (p *TransactionPool) RemovePending(transactions []*core.Transaction) {
+	for _, transaction := range transactions {
+		p.pending.Remove(transaction.Hash(core.TransactionHasher{}))
+	}
+}
+
 func (p *TransactionPool) PendingCount() int {
 	return p.pending.Count()
 }
@@ -1,9 +1,11 @@
 package network
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
 	"github.com/gabrielluizsf/go-web3/util"
 	"github.com/stretchr/testify/assert"
 )
@@ -105,3 +107,69 @@ func TestTransactionSortedMapRemove(t *testing.T) {
 	assert.Equal(t, m.Count(), 0)
 	assert.False(t, m.Contains(transaction.Hash(core.TransactionHasher{})))
 }
+
+func TestTransactionPoolRequeue(t *testing.T) {
+	p := NewTransactionPool(10)
+	transaction := util.NewRandomTransaction(100)
+	p.Add(transaction)
+	p.ClearPending()
+	assert.Equal(t, 0, p.PendingCount())
+
+	p.Requeue(transaction)
+	p.Requeue(transaction)
+	assert.Equal(t, 1, p.PendingCount())
+	assert.Equal(t, 1, p.all.Count())
+}
+
+func TestTransactionPoolPendingOrderedByNonce(t *testing.T) {
+	p := NewTransactionPool(10)
+	privKey := crypto.GeneratePrivateKey()
+	other := util.NewRandomTransactionWithSignature(t, crypto.GeneratePrivateKey(), 10)
+
+	transactions := []*core.Transaction{}
+	for _, nonce := range []uint64{2, 0, 1} {
+		transaction := core.NewTransaction(util.RandomBytes(10))
+		transaction.Nonce = nonce
+		assert.Nil(t, transaction.Sign(privKey))
+		transactions = append(transactions, transaction)
+	}
+
+	p.Add(transactions[0])
+	p.Add(other)
+	p.Add(transactions[1])
+	p.Add(transactions[2])
+
+	pending := p.Pending()
+	assert.Equal(t, 4, len(pending))
+	assert.Equal(t, uint64(0), pending[0].Nonce)
+	assert.Equal(t, other, pending[1])
+	assert.Equal(t, uint64(1), pending[2].Nonce)
+	assert.Equal(t, uint64(2), pending[3].Nonce)
+}
+
+func TestTransactionPoolRejectsOversized(t *testing.T) {
+	p := NewTransactionPoolWithParams(10, core.ConsensusParams{MaxTransactionDataBytes: 10})
+
+	assert.Nil(t, p.Add(util.NewRandomTransaction(10)))
+	err := p.Add(util.NewRandomTransaction(11))
+	assert.True(t, errors.Is(err, core.ErrTransactionDataTooLarge))
+	assert.Equal(t, 1, p.all.Count())
+
+	p = NewTransactionPoolWithParams(10, core.ConsensusParams{MaxBlockBytes: 100})
+	err = p.Add(util.NewRandomTransaction(100))
+	assert.True(t, errors.Is(err, core.ErrTransactionTooLarge))
+	assert.Equal(t, 0, p.all.Count())
+}
+
+func TestTransactionPoolRemovePending(t *testing.T) {
+	p := NewTransactionPool(10)
+	included := util.NewRandomTransaction(10)
+	left := util.NewRandomTransaction(10)
+	p.Add(included)
+	p.Add(left)
+
+	p.RemovePending([]*core.Transaction{included})
+	assert.Equal(t, []*core.Transaction{left}, p.Pending())
+	// Removed transactions are still known.
+	assert.True(t, p.Contains(included.Hash(core.TransactionHasher{})))
+}
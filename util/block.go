@@ -0,0 +1,30 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gabrielluizsf/go-web3/core"
+	"github.com/gabrielluizsf/go-web3/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// ProposeBlock returns the block on top of the chain with the given
+// timestamp and transactions, signed by the given key. It commits to the
+// state after executing the transactions.
+func ProposeBlock(t *testing.T, bc *core.Blockchain, privKey crypto.PrivateKey, timestamp time.Time, transactions ...*core.Transaction) *core.Block {
+	header, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+
+	b, err := core.NewBlockFromPrevHeader(header, transactions, timestamp)
+	assert.Nil(t, err)
+	// The timestamp is kept even when it is not after the parent, so tests
+	// can build blocks that break the timestamp rules.
+	b.Timestamp = timestamp.UnixNano()
+
+	b.Validator = privKey.PublicKey()
+	assert.Nil(t, bc.SetStateRoot(b))
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}